@@ -215,3 +215,60 @@ func generateMonth(command string, year int, month int, keyboard tgbotapi.Inline
 func date(year, month, day int) time.Time {
 	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
 }
+
+// EndOfDay is the last second of the day of t, a picked date of expiry lasts until the end of that day
+func EndOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+
+	return time.Date(year, month, day+1, 0, 0, 0, 0, t.Location()).Add(-time.Second)
+}
+
+// Pick handles calendar callback args: it returns the chosen date, or a keyboard to keep choosing and false
+func Pick(command, args, lang string) (time.Time, tgbotapi.InlineKeyboardMarkup, bool) {
+	year, month, day, err := ParseDate(args)
+	if err == nil && year != 0 && month != 0 && day != 0 {
+		return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.Local), tgbotapi.InlineKeyboardMarkup{}, true
+	}
+
+	now := time.Now()
+	replyKeyboard := tgbotapi.InlineKeyboardMarkup{}
+
+	switch {
+	case strings.HasPrefix(args, "<"):
+		if year, month, _, err := ParseDate(strings.TrimSpace(strings.TrimLeft(args, "<"))); err == nil {
+			replyKeyboard, _, _ = HandlerPrevMonth(command, year, time.Month(month), lang)
+		}
+	case strings.HasPrefix(args, ">"):
+		if year, month, _, err := ParseDate(strings.TrimSpace(strings.TrimLeft(args, ">"))); err == nil {
+			replyKeyboard, _, _ = HandlerNextMonth(command, year, time.Month(month), lang)
+		}
+	case strings.HasPrefix(args, "«"):
+		if year, month, _, err := ParseDate(strings.TrimSpace(strings.TrimLeft(args, "«"))); err == nil {
+			replyKeyboard, _, _ = HandlerPrevYear(command, year, time.Month(month), lang)
+		}
+	case strings.HasPrefix(args, "»"):
+		if year, month, _, err := ParseDate(strings.TrimSpace(strings.TrimLeft(args, "»"))); err == nil {
+			replyKeyboard, _, _ = HandlerNextYear(command, year, time.Month(month), lang)
+		}
+	case strings.HasPrefix(args, "m"):
+		year, month := now.Year(), now.Month()
+		if year2, month2, _, err := ParseDate(strings.TrimSpace(strings.TrimLeft(args, "m"))); err == nil {
+			year, month = year2, time.Month(month2)
+		}
+		replyKeyboard = GenerateMonths(command, year, month, lang)
+	case strings.HasPrefix(args, "y"):
+		year, month := now.Year(), now.Month()
+		if year2, month2, _, err := ParseDate(strings.TrimSpace(strings.TrimLeft(args, "y"))); err == nil {
+			year, month = year2, time.Month(month2)
+		}
+		replyKeyboard = GenerateYears(command, year, month, lang)
+	default:
+		year, month := now.Year(), now.Month()
+		if year2, month2, _, err := ParseDate(args); err == nil {
+			year, month = year2, time.Month(month2)
+		}
+		replyKeyboard = GenerateCalendar(command, year, month, lang)
+	}
+
+	return time.Time{}, replyKeyboard, false
+}
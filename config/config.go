@@ -15,11 +15,22 @@ type Config struct {
 	TelegramProxyPassword string
 	TelegramDebug         bool
 	BotOwnerID            int
+	MembersCheckInterval  int
+	ExpiryCheckInterval   int
+	ExpiryReminderDays    int
+	ExpiryExtendDays      int
+	ExternalPlugins       string
+	GroupAutoDelete       int
 }
 
 // InitConfig ...
 func InitConfig() *Config {
-	config := &Config{}
+	config := &Config{
+		MembersCheckInterval: 360,
+		ExpiryCheckInterval:  60,
+		ExpiryReminderDays:   3,
+		ExpiryExtendDays:     30,
+	}
 
 	flag.StringVar(&config.TelegramToken, "telegram_token", lookupEnvOrString("CORPOBOT_TELEGRAM_TOKEN", config.TelegramToken), "telegramToken")
 	flag.StringVar(&config.TelegramProxyHost, "telegram_proxy_host", lookupEnvOrString("CORPOBOT_TELEGRAM_PROXY_HOST", config.TelegramProxyHost), "telegramProxyHost")
@@ -28,6 +39,13 @@ func InitConfig() *Config {
 	flag.StringVar(&config.TelegramProxyPassword, "telegram_proxy_password", lookupEnvOrString("CORPOBOT_TELEGRAM_PROXY_PASSWORD", config.TelegramProxyPassword), "telegramProxyPassword")
 	flag.BoolVar(&config.TelegramDebug, "telegram_debug", lookupEnvOrBool("CORPOBOT_TELEGRAM_DEBUG", config.TelegramDebug), "telegramDebug")
 	flag.IntVar(&config.BotOwnerID, "bot_owner_id", lookupEnvOrInt("CORPOBOT_BOT_OWNER_ID", config.BotOwnerID), "botOwnerID")
+	flag.IntVar(&config.MembersCheckInterval, "members_check_interval", lookupEnvOrInt("CORPOBOT_MEMBERS_CHECK_INTERVAL", config.MembersCheckInterval), "membersCheckInterval (minutes)")
+	flag.IntVar(&config.ExpiryCheckInterval, "expiry_check_interval", lookupEnvOrInt("CORPOBOT_EXPIRY_CHECK_INTERVAL", config.ExpiryCheckInterval), "expiryCheckInterval (minutes)")
+	flag.IntVar(&config.ExpiryReminderDays, "expiry_reminder_days", lookupEnvOrInt("CORPOBOT_EXPIRY_REMINDER_DAYS", config.ExpiryReminderDays), "expiryReminderDays")
+	flag.IntVar(&config.ExpiryExtendDays, "expiry_extend_days", lookupEnvOrInt("CORPOBOT_EXPIRY_EXTEND_DAYS", config.ExpiryExtendDays), "expiryExtendDays")
+
+	flag.IntVar(&config.GroupAutoDelete, "group_autodelete", lookupEnvOrInt("CORPOBOT_GROUP_AUTODELETE", config.GroupAutoDelete), "groupAutoDelete (seconds, commands and replies in groupchats are deleted after it, 0 keeps them)")
+	flag.StringVar(&config.ExternalPlugins, "external_plugins", lookupEnvOrString("CORPOBOT_EXTERNAL_PLUGINS", config.ExternalPlugins), "externalPlugins (comma separated executables)")
 
 	flag.Parse()
 
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	sql "github.com/lazada/sqle"
+
+	_ "github.com/mattn/go-sqlite3" // Register some sql
+)
+
+// AccessRequest is a request of user to join group
+type AccessRequest struct {
+	ID         int64     `sql:"id"`
+	TelegramID int64     `sql:"telegram_id"`
+	GroupID    int64     `sql:"group_id"`
+	Reason     string    `sql:"reason"`
+	State      string    `sql:"state"`
+	HandledBy  int64     `sql:"handled_by"`
+	CreatedAt  time.Time `sql:"created_at"`
+	UpdatedAt  time.Time `sql:"updated_at"`
+}
+
+// AccessRequestMessage is a message about request sent to approver
+type AccessRequestMessage struct {
+	ID        int64 `sql:"id"`
+	RequestID int64 `sql:"request_id"`
+	ChatID    int64 `sql:"chat_id"`
+	MessageID int   `sql:"message_id"`
+}
+
+// AddAccessRequest stores request unless the same one is waiting for approval
+func AddAccessRequest(db *sql.DB, request *AccessRequest) (*AccessRequest, error) {
+	var count int64
+
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM access_requests WHERE telegram_id = ? AND group_id = ? AND state = ?;",
+		request.TelegramID,
+		request.GroupID,
+		Pending,
+	).Scan(&count)
+	if err != nil {
+		return nil, err
+	}
+
+	if count > 0 {
+		return nil, errors.New(AccessRequestExists)
+	}
+
+	request.State = Pending
+
+	res, err := db.Exec(
+		"INSERT INTO access_requests (telegram_id, group_id, reason, state) VALUES (?, ?, ?, ?);",
+		request.TelegramID,
+		request.GroupID,
+		request.Reason,
+		request.State,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	request.ID, err = res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	request.CreatedAt = time.Now()
+
+	return request, nil
+}
+
+// GetAccessRequest ...
+func GetAccessRequest(db *sql.DB, id int64) (*AccessRequest, error) {
+	var returnModel AccessRequest
+
+	result, err := QuerySQLObject(db, returnModel, `SELECT * FROM access_requests WHERE id = ?;`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if returnModel, ok := result.Interface().(*AccessRequest); ok && returnModel.ID != 0 {
+		return returnModel, nil
+	}
+
+	return nil, errors.New(AccessRequestNotFound)
+}
+
+// HandleAccessRequest stores decision on pending request, only the first decision is stored, an approved request adds
+// user to group in the same transaction
+func HandleAccessRequest(db *sql.DB, request *AccessRequest) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(
+		"UPDATE access_requests SET state = ?, handled_by = ? WHERE id = ? AND state = ?;",
+		request.State,
+		request.HandledBy,
+		request.ID,
+		Pending)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if rows == 0 {
+		_ = tx.Rollback()
+		return errors.New(AccessRequestHandled)
+	}
+
+	if request.State == Approved {
+		var userID int64
+		if err = tx.QueryRow("SELECT id FROM users WHERE telegram_id = ?;", request.TelegramID).Scan(&userID); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+
+		if err = addGroupUser(tx, request.GroupID, userID); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// AddAccessRequestMessage ...
+func AddAccessRequestMessage(db *sql.DB, message *AccessRequestMessage) error {
+	_, err := db.Exec(
+		"INSERT INTO access_request_messages (request_id, chat_id, message_id) VALUES (?, ?, ?);",
+		message.RequestID,
+		message.ChatID,
+		message.MessageID,
+	)
+
+	return err
+}
+
+// GetAccessRequestMessages ...
+func GetAccessRequestMessages(db *sql.DB, requestID int64) (messages []*AccessRequestMessage, err error) {
+	var returnModel AccessRequestMessage
+
+	result, err := QuerySQLList(db, returnModel, `SELECT * FROM access_request_messages WHERE request_id = ?;`, requestID)
+	if err != nil {
+		return messages, err
+	}
+
+	for _, item := range result {
+		if returnModel, ok := item.Interface().(*AccessRequestMessage); ok {
+			messages = append(messages, returnModel)
+		}
+	}
+
+	return messages, err
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	sql "github.com/lazada/sqle"
+
+	_ "github.com/mattn/go-sqlite3" // Register some sql
+)
+
+// AuditEntry is a record of administrative action
+type AuditEntry struct {
+	ID        int64     `sql:"id"`
+	ActorID   int64     `sql:"actor_id"`
+	Actor     string    `sql:"actor"`
+	Action    string    `sql:"action"`
+	Target    string    `sql:"target"`
+	Before    string    `sql:"before"`
+	After     string    `sql:"after"`
+	CreatedAt time.Time `sql:"created_at"`
+}
+
+// AuditFilter limits audit entries, zero fields don't filter
+type AuditFilter struct {
+	ActorID int64
+	Actor   string
+	Action  string
+	Target  string
+	From    time.Time
+	To      time.Time
+	Limit   int
+}
+
+// auditTimeLayout is the way sqlite stores CURRENT_TIMESTAMP
+const auditTimeLayout = "2006-01-02 15:04:05"
+
+func (e *AuditEntry) String() string {
+	var b strings.Builder
+	b.WriteString(e.CreatedAt.Format("2006-01-02 15:04"))
+	b.WriteRune(' ')
+	if e.Actor != "" {
+		b.WriteString(e.Actor)
+	} else {
+		b.WriteString(strconv.FormatInt(e.ActorID, 10))
+	}
+	b.WriteString(" /")
+	b.WriteString(e.Action)
+	if e.Target != "" {
+		b.WriteRune(' ')
+		b.WriteString(e.Target)
+	}
+	if e.Before != "" || e.After != "" {
+		b.WriteString(": ")
+		b.WriteString(e.Before)
+		b.WriteString(" → ")
+		b.WriteString(e.After)
+	}
+
+	return b.String()
+}
+
+// AddAuditEntry ...
+func AddAuditEntry(db *sql.DB, entry *AuditEntry) error {
+	_, err := db.Exec(
+		"INSERT INTO audit_log (actor_id, actor, action, target, before, after) VALUES (?, ?, ?, ?, ?, ?);",
+		entry.ActorID,
+		entry.Actor,
+		entry.Action,
+		entry.Target,
+		entry.Before,
+		entry.After,
+	)
+
+	return err
+}
+
+// GetAuditEntries returns entries matching filter, the newest first
+func GetAuditEntries(db *sql.DB, filter AuditFilter) (entries []*AuditEntry, err error) {
+	var where []string
+	var args []interface{}
+
+	if filter.ActorID != 0 {
+		where = append(where, "actor_id = ?")
+		args = append(args, filter.ActorID)
+	}
+	if filter.Actor != "" {
+		where = append(where, "actor LIKE ?")
+		args = append(args, "%"+filter.Actor+"%")
+	}
+	if filter.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.Target != "" {
+		where = append(where, "target LIKE ?")
+		args = append(args, "%"+filter.Target+"%")
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, filter.From.UTC().Format(auditTimeLayout))
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, filter.To.UTC().Format(auditTimeLayout))
+	}
+
+	sql := `SELECT
+	*
+FROM
+	audit_log`
+	if len(where) > 0 {
+		sql += `
+WHERE
+	` + strings.Join(where, " AND ")
+	}
+	sql += `
+ORDER BY
+	id DESC`
+	if filter.Limit > 0 {
+		sql += `
+LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+	sql += ";"
+
+	var returnModel AuditEntry
+
+	result, err := QuerySQLList(db, returnModel, sql, args...)
+	if err != nil {
+		return entries, err
+	}
+
+	for _, item := range result {
+		if returnModel, ok := item.Interface().(*AuditEntry); ok {
+			entries = append(entries, returnModel)
+		}
+	}
+
+	return entries, err
+}
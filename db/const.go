@@ -9,16 +9,49 @@ const (
 	GroupAlreadyExists = "group already exists"
 	GroupNotFound      = "group not found"
 	GroupDeleted       = "group deleted"
+	GroupCycle         = "group can't be a parent of itself or of its ancestor"
 
 	GroupChatAlreadyExists = "groupchat already exists"
 	GroupChatNotFound      = "groupchat not found"
 
-	Deleted = "deleted"
-	Blocked = "blocked"
-	Active  = "active"
+	GroupChatMemberNotFound = "groupchat member not found"
+
+	RoleAlreadyExists  = "role already exists"
+	RoleNotFound       = "role not found"
+	RoleIsSystem       = "system role can't be changed"
+	RoleInUse          = "role is assigned to users"
+	PermissionNotFound = "permission not found"
+
+	RegistrationNotFound = "registration not found"
+	RegistrationHandled  = "registration already handled"
+
+	AccessRequestNotFound = "request not found"
+	AccessRequestHandled  = "request already handled"
+	AccessRequestExists   = "request is already waiting for approval"
+
+	ExpiryNotFound = "expiry not found"
+
+	CustomCommandNotFound = "custom command not found"
+
+	ScriptNotFound = "script not found"
+
+	Deleted  = "deleted"
+	Blocked  = "blocked"
+	Active   = "active"
+	Inactive = "inactive"
+	Pending  = "pending"
+	Approved = "approved"
+	Rejected = "rejected"
 
 	New    = "new"
 	Member = "member"
 	Admin  = "admin"
 	Owner  = "owner"
+
+	// chat member statuses as reported by Telegram
+	Creator       = "creator"
+	Administrator = "administrator"
+	Restricted    = "restricted"
+	Left          = "left"
+	Kicked        = "kicked"
 )
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	sql "github.com/lazada/sqle"
+	_ "github.com/mattn/go-sqlite3" // Register some sql
+)
+
+// CustomCommand is a command defined by admins at runtime, roles are comma separated, buttons are lines of
+// "text | url"
+type CustomCommand struct {
+	ID          int64     `sql:"id"`
+	Name        string    `sql:"name"`
+	Description string    `sql:"description"`
+	Template    string    `sql:"template"`
+	Markdown    bool      `sql:"markdown"`
+	Roles       string    `sql:"roles"`
+	Buttons     string    `sql:"buttons"`
+	CreatedBy   int64     `sql:"created_by"`
+	CreatedAt   time.Time `sql:"created_at"`
+	UpdatedAt   time.Time `sql:"updated_at"`
+}
+
+func (c *CustomCommand) String() string {
+	return "/" + c.Name
+}
+
+// GetCustomCommands ...
+func GetCustomCommands(db *sql.DB) (commands []*CustomCommand, err error) {
+	var returnModel CustomCommand
+
+	result, err := QuerySQLList(db, returnModel, `SELECT * FROM custom_commands ORDER BY name;`)
+	if err != nil {
+		return commands, err
+	}
+
+	for _, item := range result {
+		if returnModel, ok := item.Interface().(*CustomCommand); ok {
+			commands = append(commands, returnModel)
+		}
+	}
+
+	return commands, nil
+}
+
+// GetCustomCommand ...
+func GetCustomCommand(db *sql.DB, name string) (*CustomCommand, error) {
+	var returnModel CustomCommand
+
+	result, err := QuerySQLObject(db, returnModel, `SELECT * FROM custom_commands WHERE name = ?;`, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if returnModel, ok := result.Interface().(*CustomCommand); ok && returnModel.Name != "" {
+		return returnModel, nil
+	}
+
+	return nil, errors.New(CustomCommandNotFound)
+}
+
+// SetCustomCommand creates command or updates the one with the same name, created_by stays the first author
+func SetCustomCommand(db *sql.DB, command *CustomCommand) error {
+	result, err := db.Exec(
+		"UPDATE custom_commands SET description = ?, template = ?, markdown = ?, roles = ?, buttons = ?, updated_at = CURRENT_TIMESTAMP WHERE name = ?;",
+		command.Description,
+		command.Template,
+		command.Markdown,
+		command.Roles,
+		command.Buttons,
+		command.Name)
+	if err != nil {
+		return err
+	}
+
+	if rows, err := result.RowsAffected(); err != nil || rows > 0 {
+		return err
+	}
+
+	res, err := db.Exec(
+		"INSERT INTO custom_commands (name, description, template, markdown, roles, buttons, created_by) VALUES (?, ?, ?, ?, ?, ?, ?);",
+		command.Name,
+		command.Description,
+		command.Template,
+		command.Markdown,
+		command.Roles,
+		command.Buttons,
+		command.CreatedBy)
+	if err != nil {
+		return err
+	}
+
+	command.ID, err = res.LastInsertId()
+
+	return err
+}
+
+// DeleteCustomCommand ...
+func DeleteCustomCommand(db *sql.DB, name string) error {
+	result, err := db.Exec("DELETE FROM custom_commands WHERE name = ?;", name)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return errors.New(CustomCommandNotFound)
+	}
+
+	return nil
+}
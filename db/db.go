@@ -60,6 +60,8 @@ func InitDB() (*sql.DB, error) {
 		"created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
 		"updated_at" timestamp DEFAULT CURRENT_TIMESTAMP,
 		"birthday" datetime DEFAULT '',
+		"language_code" VARCHAR(16) NOT NULL DEFAULT "",
+		"language" VARCHAR(16) NOT NULL DEFAULT "",
 		CONSTRAINT "users_telegram_id" UNIQUE ("telegram_id") ON CONFLICT IGNORE
 	  );
 
@@ -72,6 +74,29 @@ func InitDB() (*sql.DB, error) {
 		dlog.Errorf("%s", err)
 	}
 
+	err = addColumnIfNotExist(db, "users", "language_code", `VARCHAR(16) NOT NULL DEFAULT ""`)
+	if err != nil {
+		dlog.Errorf("%s", err)
+	}
+
+	err = addColumnIfNotExist(db, "users", "language", `VARCHAR(16) NOT NULL DEFAULT ""`)
+	if err != nil {
+		dlog.Errorf("%s", err)
+	}
+
+	err = ExecSQL(db, `CREATE TABLE IF NOT EXISTS "users_usernames" (
+		"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"user_id" INTEGER NOT NULL,
+		"user_name" VARCHAR(32) NOT NULL,
+		"created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT "users_usernames_user_id" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE
+	  );
+
+		CREATE INDEX IF NOT EXISTS users_usernames_user_id ON users_usernames (user_id);`)
+	if err != nil {
+		dlog.Errorf("%s", err)
+	}
+
 	err = ExecSQL(db, `CREATE TABLE IF NOT EXISTS "groups" (
 		"id" INTEGER PRIMARY KEY AUTOINCREMENT,
 		"name" VARCHAR(32) NOT NULL,
@@ -154,6 +179,235 @@ func InitDB() (*sql.DB, error) {
 		dlog.Errorf("%s", err)
 	}
 
+	err = ExecSQL(db, `CREATE TABLE IF NOT EXISTS "groups_managers" (
+		"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"group_id" INTEGER NOT NULL,
+		"user_id" INTEGER NOT NULL,
+		"created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT "groups_managers_group_id" FOREIGN KEY ("group_id") REFERENCES "groups" ("id") ON DELETE CASCADE,
+		CONSTRAINT "groups_managers_user_id" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE,
+		CONSTRAINT "groups_managers_pair" UNIQUE ("group_id" ASC, "user_id" ASC) ON CONFLICT IGNORE
+	  );`)
+	if err != nil {
+		dlog.Errorf("%s", err)
+	}
+
+	err = ExecSQL(db, `CREATE TABLE IF NOT EXISTS "groups_groups" (
+		"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"parent_id" INTEGER NOT NULL,
+		"group_id" INTEGER NOT NULL,
+		"created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT "groups_groups_parent_id" FOREIGN KEY ("parent_id") REFERENCES "groups" ("id") ON DELETE CASCADE,
+		CONSTRAINT "groups_groups_group_id" FOREIGN KEY ("group_id") REFERENCES "groups" ("id") ON DELETE CASCADE,
+		CONSTRAINT "groups_groups_single_parent" UNIQUE ("group_id" ASC) ON CONFLICT REPLACE
+	  );`)
+	if err != nil {
+		dlog.Errorf("%s", err)
+	}
+
+	err = ExecSQL(db, `CREATE TABLE IF NOT EXISTS "groupchat_members" (
+		"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"groupchat_id" INTEGER NOT NULL,
+		"telegram_id" INTEGER NOT NULL,
+		"first_name" VARCHAR(32) NOT NULL DEFAULT "",
+		"last_name" VARCHAR(32) NOT NULL DEFAULT "",
+		"user_name" VARCHAR(32) NOT NULL DEFAULT "",
+		"status" VARCHAR(32) NOT NULL,
+		"created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+		"updated_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT "groupchat_members_groupchat_id" FOREIGN KEY ("groupchat_id") REFERENCES "groupchats" ("id") ON DELETE CASCADE,
+		CONSTRAINT "groupchat_members_pair" UNIQUE ("groupchat_id" ASC, "telegram_id" ASC) ON CONFLICT IGNORE
+	  );
+
+		CREATE TRIGGER IF NOT EXISTS groupchat_members_updated_at_Trigger
+		AFTER UPDATE On groupchat_members
+		BEGIN
+		   UPDATE groupchat_members SET updated_at = STRFTIME('%Y-%m-%d %H:%M:%f', 'NOW') WHERE id = NEW.id;
+		END;`)
+	if err != nil {
+		dlog.Errorf("%s", err)
+	}
+
+	err = ExecSQL(db, `CREATE TABLE IF NOT EXISTS "roles" (
+		"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"name" VARCHAR(32) NOT NULL,
+		"is_system" bool NOT NULL DEFAULT False,
+		"created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT "roles_name" UNIQUE ("name") ON CONFLICT IGNORE
+	  );
+
+		INSERT INTO roles (name, is_system) VALUES ("new", True), ("member", True), ("admin", True), ("owner", True), ("blocked", True), ("deleted", True);`)
+	if err != nil {
+		dlog.Errorf("%s", err)
+	}
+
+	err = ExecSQL(db, `CREATE TABLE IF NOT EXISTS "permissions" (
+		"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"name" VARCHAR(64) NOT NULL,
+		"description" TEXT DEFAULT "",
+		"created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT "permissions_name" UNIQUE ("name") ON CONFLICT IGNORE
+	  );`)
+	if err != nil {
+		dlog.Errorf("%s", err)
+	}
+
+	err = ExecSQL(db, `CREATE TABLE IF NOT EXISTS "roles_permissions" (
+		"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"role" VARCHAR(32) NOT NULL,
+		"permission" VARCHAR(64) NOT NULL,
+		"created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT "roles_permissions_role" FOREIGN KEY ("role") REFERENCES "roles" ("name") ON DELETE CASCADE,
+		CONSTRAINT "roles_permissions_permission" FOREIGN KEY ("permission") REFERENCES "permissions" ("name") ON DELETE CASCADE,
+		CONSTRAINT "roles_permissions_pair" UNIQUE ("role" ASC, "permission" ASC) ON CONFLICT IGNORE
+	  );`)
+	if err != nil {
+		dlog.Errorf("%s", err)
+	}
+
+	err = ExecSQL(db, `CREATE TABLE IF NOT EXISTS "audit_log" (
+		"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"actor_id" INTEGER NOT NULL,
+		"actor" TEXT NOT NULL DEFAULT "",
+		"action" VARCHAR(64) NOT NULL,
+		"target" TEXT NOT NULL DEFAULT "",
+		"before" TEXT NOT NULL DEFAULT "",
+		"after" TEXT NOT NULL DEFAULT "",
+		"created_at" timestamp DEFAULT CURRENT_TIMESTAMP
+	  );
+
+		CREATE INDEX IF NOT EXISTS audit_log_created_at ON audit_log (created_at);`)
+	if err != nil {
+		dlog.Errorf("%s", err)
+	}
+
+	err = ExecSQL(db, `CREATE TABLE IF NOT EXISTS "registrations" (
+		"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"telegram_id" INTEGER NOT NULL,
+		"name" TEXT NOT NULL DEFAULT "",
+		"department" TEXT NOT NULL DEFAULT "",
+		"reason" TEXT NOT NULL DEFAULT "",
+		"state" VARCHAR(32) NOT NULL DEFAULT "pending",
+		"role" VARCHAR(32) NOT NULL DEFAULT "",
+		"handled_by" INTEGER NOT NULL DEFAULT 0,
+		"created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+		"updated_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT "registrations_telegram_id" FOREIGN KEY ("telegram_id") REFERENCES "users" ("telegram_id")
+	  );
+
+		CREATE TRIGGER IF NOT EXISTS registrations_updated_at_Trigger
+		AFTER UPDATE On registrations
+		BEGIN
+		   UPDATE registrations SET updated_at = STRFTIME('%Y-%m-%d %H:%M:%f', 'NOW') WHERE id = NEW.id;
+		END;`)
+	if err != nil {
+		dlog.Errorf("%s", err)
+	}
+
+	err = ExecSQL(db, `CREATE TABLE IF NOT EXISTS "registration_messages" (
+		"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"registration_id" INTEGER NOT NULL,
+		"chat_id" INTEGER NOT NULL,
+		"message_id" INTEGER NOT NULL,
+		CONSTRAINT "registration_messages_registration_id" FOREIGN KEY ("registration_id") REFERENCES "registrations" ("id") ON DELETE CASCADE
+	  );`)
+	if err != nil {
+		dlog.Errorf("%s", err)
+	}
+
+	err = ExecSQL(db, `CREATE TABLE IF NOT EXISTS "access_requests" (
+		"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"telegram_id" INTEGER NOT NULL,
+		"group_id" INTEGER NOT NULL,
+		"reason" TEXT NOT NULL DEFAULT "",
+		"state" VARCHAR(32) NOT NULL DEFAULT "pending",
+		"handled_by" INTEGER NOT NULL DEFAULT 0,
+		"created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+		"updated_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT "access_requests_telegram_id" FOREIGN KEY ("telegram_id") REFERENCES "users" ("telegram_id"),
+		CONSTRAINT "access_requests_group_id" FOREIGN KEY ("group_id") REFERENCES "groups" ("id") ON DELETE CASCADE
+	  );
+
+		CREATE TRIGGER IF NOT EXISTS access_requests_updated_at_Trigger
+		AFTER UPDATE On access_requests
+		BEGIN
+		   UPDATE access_requests SET updated_at = STRFTIME('%Y-%m-%d %H:%M:%f', 'NOW') WHERE id = NEW.id;
+		END;`)
+	if err != nil {
+		dlog.Errorf("%s", err)
+	}
+
+	err = ExecSQL(db, `CREATE TABLE IF NOT EXISTS "access_request_messages" (
+		"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"request_id" INTEGER NOT NULL,
+		"chat_id" INTEGER NOT NULL,
+		"message_id" INTEGER NOT NULL,
+		CONSTRAINT "access_request_messages_request_id" FOREIGN KEY ("request_id") REFERENCES "access_requests" ("id") ON DELETE CASCADE
+	  );`)
+	if err != nil {
+		dlog.Errorf("%s", err)
+	}
+
+	err = ExecSQL(db, `CREATE TABLE IF NOT EXISTS "expiries" (
+		"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"group_id" INTEGER NOT NULL DEFAULT 0,
+		"user_id" INTEGER NOT NULL,
+		"role" VARCHAR(32) NOT NULL DEFAULT "",
+		"expires_at" timestamp NOT NULL,
+		"reminded" bool NOT NULL DEFAULT False,
+		"created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT "expiries_user_id" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE,
+		CONSTRAINT "expiries_pair" UNIQUE ("group_id" ASC, "user_id" ASC) ON CONFLICT REPLACE
+	  );
+
+		CREATE INDEX IF NOT EXISTS expiries_expires_at ON expiries (expires_at);`)
+	if err != nil {
+		dlog.Errorf("%s", err)
+	}
+
+	err = ExecSQL(db, `CREATE TABLE IF NOT EXISTS "plugins_settings" (
+		"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"plugin" text NOT NULL,
+		"name" text NOT NULL,
+		"value" text NOT NULL DEFAULT "",
+		"updated_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT "plugins_settings_pair" UNIQUE ("plugin" ASC, "name" ASC) ON CONFLICT REPLACE
+	  );`)
+	if err != nil {
+		dlog.Errorf("%s", err)
+	}
+
+	err = ExecSQL(db, `CREATE TABLE IF NOT EXISTS "custom_commands" (
+		"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"name" text NOT NULL,
+		"description" text NOT NULL DEFAULT "",
+		"template" text NOT NULL DEFAULT "",
+		"markdown" bool NOT NULL DEFAULT False,
+		"roles" text NOT NULL DEFAULT "",
+		"buttons" text NOT NULL DEFAULT "",
+		"created_by" INTEGER NOT NULL DEFAULT 0,
+		"created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+		"updated_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT "custom_commands_name" UNIQUE ("name" ASC)
+	  );`)
+	if err != nil {
+		dlog.Errorf("%s", err)
+	}
+
+	err = ExecSQL(db, `CREATE TABLE IF NOT EXISTS "scripts" (
+		"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"name" text NOT NULL,
+		"source" text NOT NULL DEFAULT "",
+		"state" VARCHAR(32) NOT NULL DEFAULT "enabled",
+		"created_by" INTEGER NOT NULL DEFAULT 0,
+		"created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+		"updated_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT "scripts_name" UNIQUE ("name" ASC)
+	  );`)
+	if err != nil {
+		dlog.Errorf("%s", err)
+	}
+
 	return db, nil
 }
 
@@ -167,6 +421,20 @@ func ExecSQL(db *sql.DB, sql string) error {
 	return nil
 }
 
+// addColumnIfNotExist adds column to a table created by an older version, sqlite has no ADD COLUMN IF NOT EXISTS
+func addColumnIfNotExist(db *sql.DB, table, column, definition string) error {
+	var count int64
+	if err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?;", table, column).Scan(&count); err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	return ExecSQL(db, `ALTER TABLE "`+table+`" ADD COLUMN "`+column+`" `+definition+`;`)
+}
+
 // QuerySQLObject ...
 func QuerySQLObject(db *sql.DB, returnModel interface{}, sql string, args ...interface{}) (reflect.Value, error) {
 	t := reflect.TypeOf(returnModel)
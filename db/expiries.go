@@ -0,0 +1,159 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	sql "github.com/lazada/sqle"
+	_ "github.com/mattn/go-sqlite3" // Register some sql
+)
+
+// Expiry is a temporary group membership (GroupID != 0) or a temporary role (GroupID == 0) of user
+type Expiry struct {
+	ID         int64     `sql:"id"`
+	GroupID    int64     `sql:"group_id"`
+	UserID     int64     `sql:"user_id"`
+	TelegramID int64     `sql:"telegram_id"`
+	Role       string    `sql:"role"`
+	ExpiresAt  time.Time `sql:"expires_at"`
+	Reminded   bool      `sql:"reminded"`
+	CreatedAt  time.Time `sql:"created_at"`
+}
+
+// IsRole ...
+func (e *Expiry) IsRole() bool {
+	return e.GroupID == 0
+}
+
+// Date is a readable expiry date
+func (e *Expiry) Date() string {
+	return e.ExpiresAt.Local().Format("2006.01.02")
+}
+
+const expirySelect = `SELECT
+	expiries.*,
+	users.telegram_id
+FROM
+	expiries
+JOIN
+	users ON users.id = expiries.user_id
+`
+
+// SetGroupUserExpiry makes user's membership in group temporary, previous expiry and its reminder are replaced
+func SetGroupUserExpiry(db *sql.DB, group *Group, user *User, expiresAt time.Time) error {
+	_, err := db.Exec(
+		"INSERT INTO expiries (group_id, user_id, expires_at) VALUES (?, ?, ?);",
+		group.ID,
+		user.ID,
+		expiresAt.UTC(),
+	)
+
+	return err
+}
+
+// DeleteGroupUserExpiry makes user's membership in group permanent
+func DeleteGroupUserExpiry(db *sql.DB, group *Group, user *User) (int64, error) {
+	result, err := db.Exec("DELETE FROM expiries WHERE group_id = ? AND user_id = ?;", group.ID, user.ID)
+	if err != nil {
+		return -1, err
+	}
+
+	return result.RowsAffected()
+}
+
+// GetGroupUserExpiry ...
+func GetGroupUserExpiry(db *sql.DB, group *Group, user *User) (*Expiry, error) {
+	return getExpiry(db, expirySelect+`WHERE expiries.group_id = ? AND expiries.user_id = ?;`, group.ID, user.ID)
+}
+
+// GetGroupExpiries returns temporary memberships of group
+func GetGroupExpiries(db *sql.DB, group *Group) ([]*Expiry, error) {
+	return queryExpiries(db, expirySelect+`WHERE expiries.group_id = ? ORDER BY expiries.expires_at;`, group.ID)
+}
+
+// SetUserRoleExpiry makes user's current role temporary
+func SetUserRoleExpiry(db *sql.DB, user *User, expiresAt time.Time) error {
+	_, err := db.Exec(
+		"INSERT INTO expiries (group_id, user_id, role, expires_at) VALUES (0, ?, ?, ?);",
+		user.ID,
+		user.Role,
+		expiresAt.UTC(),
+	)
+
+	return err
+}
+
+// DeleteUserRoleExpiry makes user's role permanent
+func DeleteUserRoleExpiry(db *sql.DB, user *User) (int64, error) {
+	result, err := db.Exec("DELETE FROM expiries WHERE group_id = 0 AND user_id = ?;", user.ID)
+	if err != nil {
+		return -1, err
+	}
+
+	return result.RowsAffected()
+}
+
+// GetUserRoleExpiry returns expiry of user's role, expiry of a role the user no longer has is not returned
+func GetUserRoleExpiry(db *sql.DB, user *User) (*Expiry, error) {
+	return getExpiry(db, expirySelect+`WHERE expiries.group_id = 0 AND expiries.user_id = ? AND expiries.role = users.role;`, user.ID)
+}
+
+// GetExpiries returns expiries due before t, oldest first
+func GetExpiries(db *sql.DB, t time.Time) ([]*Expiry, error) {
+	return queryExpiries(db, expirySelect+`WHERE expiries.expires_at <= ? ORDER BY expiries.expires_at;`, t.UTC())
+}
+
+// MarkExpiryReminded ...
+func MarkExpiryReminded(db *sql.DB, expiry *Expiry) error {
+	_, err := db.Exec("UPDATE expiries SET reminded = True WHERE id = ?;", expiry.ID)
+
+	return err
+}
+
+// DeleteExpiry ...
+func DeleteExpiry(db *sql.DB, expiry *Expiry) error {
+	_, err := db.Exec("DELETE FROM expiries WHERE id = ?;", expiry.ID)
+
+	return err
+}
+
+func getExpiry(db *sql.DB, sql string, args ...interface{}) (*Expiry, error) {
+	var returnModel Expiry
+
+	result, err := QuerySQLObject(db, returnModel, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if returnModel, ok := result.Interface().(*Expiry); ok && returnModel.ID != 0 {
+		return returnModel, nil
+	}
+
+	return nil, errors.New(ExpiryNotFound)
+}
+
+func queryExpiries(db *sql.DB, sql string, args ...interface{}) (expiries []*Expiry, err error) {
+	var returnModel Expiry
+
+	result, err := QuerySQLList(db, returnModel, sql, args...)
+	if err != nil {
+		return expiries, err
+	}
+
+	for _, item := range result {
+		if returnModel, ok := item.Interface().(*Expiry); ok {
+			expiries = append(expiries, returnModel)
+		}
+	}
+
+	return expiries, err
+}
+
+// FallbackRole is a role user gets when a temporary role expires, temporary members become new again
+func FallbackRole(role string) string {
+	if role == Member {
+		return New
+	}
+
+	return Member
+}
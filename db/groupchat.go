@@ -34,6 +34,11 @@ func (gc *Groupchat) String() string {
 	return b.String()
 }
 
+// Label is title and Telegram ID of groupchat without invite link, e.g. for audit log
+func (gc *Groupchat) Label() string {
+	return gc.Title + " [" + strconv.FormatInt(gc.TelegramID, 10) + "]"
+}
+
 // GetGroupchats ...
 func GetGroupchats(db *sql.DB, states []string) (groupchats []*Groupchat, err error) {
 	if len(states) == 0 {
@@ -167,6 +172,78 @@ func UpdateGroupChatTitle(db *sql.DB, groupchat *Groupchat) (int64, error) {
 	return rows, nil
 }
 
+// UpdateGroupChatState ...
+func UpdateGroupChatState(db *sql.DB, groupchat *Groupchat) (int64, error) {
+	result, err := db.Exec(
+		"UPDATE groupchats SET state = ? WHERE telegram_id = ? AND state != ?;",
+		groupchat.State,
+		groupchat.TelegramID,
+		groupchat.State)
+	if err != nil {
+		return -1, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return -1, err
+	}
+
+	return rows, nil
+}
+
+// MigrateGroupChat moves groupchat with its group links and members to a new telegram ID
+func MigrateGroupChat(db *sql.DB, oldTelegramID, newTelegramID int64) (bool, error) {
+	oldGroupchat, err := GetGroupChatByTelegramID(db, &Groupchat{TelegramID: oldTelegramID})
+	if err != nil {
+		return false, err
+	}
+
+	newGroupchat, err := GetGroupChatByTelegramID(db, &Groupchat{TelegramID: newTelegramID})
+	if err != nil && err.Error() != GroupChatNotFound {
+		return false, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	if newGroupchat == nil {
+		_, err = tx.Exec("UPDATE groupchats SET telegram_id = ?, state = ? WHERE id = ?;", newTelegramID, Active, oldGroupchat.ID)
+		if err != nil {
+			_ = tx.Rollback()
+			return false, err
+		}
+
+		return true, tx.Commit()
+	}
+
+	queries := []string{
+		"INSERT INTO groups_groupchats (group_id, groupchat_id) SELECT group_id, ? FROM groups_groupchats WHERE groupchat_id = ?;",
+		"INSERT INTO groupchat_members (groupchat_id, telegram_id, first_name, last_name, user_name, status) SELECT ?, telegram_id, first_name, last_name, user_name, status FROM groupchat_members WHERE groupchat_id = ?;",
+	}
+	for _, query := range queries {
+		if _, err = tx.Exec(query, newGroupchat.ID, oldGroupchat.ID); err != nil {
+			_ = tx.Rollback()
+			return false, err
+		}
+	}
+
+	queries = []string{
+		"DELETE FROM groups_groupchats WHERE groupchat_id = ?;",
+		"DELETE FROM groupchat_members WHERE groupchat_id = ?;",
+		"DELETE FROM groupchats WHERE id = ?;",
+	}
+	for _, query := range queries {
+		if _, err = tx.Exec(query, oldGroupchat.ID); err != nil {
+			_ = tx.Rollback()
+			return false, err
+		}
+	}
+
+	return true, tx.Commit()
+}
+
 // GetGroupChatByTelegramID ...
 func GetGroupChatByTelegramID(db *sql.DB, groupchat *Groupchat) (*Groupchat, error) {
 	var returnModel Groupchat
@@ -0,0 +1,253 @@
+package db
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	sql "github.com/lazada/sqle"
+
+	_ "github.com/mattn/go-sqlite3" // Register some sql
+)
+
+// GroupchatMember ...
+type GroupchatMember struct {
+	ID          int64     `sql:"id"`
+	GroupchatID int64     `sql:"groupchat_id"`
+	TelegramID  int64     `sql:"telegram_id"`
+	FirstName   string    `sql:"first_name"`
+	LastName    string    `sql:"last_name"`
+	UserName    string    `sql:"user_name"`
+	Status      string    `sql:"status"`
+	CreatedAt   time.Time `sql:"created_at"`
+	UpdatedAt   time.Time `sql:"updated_at"`
+}
+
+// PresentMemberStatuses is a list of chat member statuses meaning that user is in the chat
+var PresentMemberStatuses = []string{Creator, Administrator, Member, Restricted}
+
+func (m *GroupchatMember) String() string {
+	var b strings.Builder
+	if m.UserName != "" {
+		b.WriteRune('@')
+		b.WriteString(m.UserName)
+		b.WriteRune(' ')
+	}
+	if m.FirstName != "" {
+		b.WriteString(m.FirstName)
+		b.WriteRune(' ')
+	}
+	if m.LastName != "" {
+		b.WriteString(m.LastName)
+		b.WriteRune(' ')
+	}
+	b.WriteRune('[')
+	b.WriteString(strconv.FormatInt(m.TelegramID, 10))
+	b.WriteRune(']')
+	b.WriteRune(' ')
+	b.WriteRune('(')
+	b.WriteString(m.Status)
+	b.WriteRune(')')
+
+	return b.String()
+}
+
+// IsPresent ...
+func (m *GroupchatMember) IsPresent() bool {
+	for _, status := range PresentMemberStatuses {
+		if m.Status == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UpdateGroupchatMember stores the last known status of user in groupchat
+func UpdateGroupchatMember(db *sql.DB, member *GroupchatMember) (int64, error) {
+	result, err := db.Exec(
+		"UPDATE groupchat_members SET first_name = ?, last_name = ?, user_name = ?, status = ? WHERE groupchat_id = ? AND telegram_id = ?;",
+		member.FirstName,
+		member.LastName,
+		member.UserName,
+		member.Status,
+		member.GroupchatID,
+		member.TelegramID)
+	if err != nil {
+		return -1, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return -1, err
+	}
+
+	if rows > 0 {
+		return rows, nil
+	}
+
+	result, err = db.Exec(
+		"INSERT INTO groupchat_members (groupchat_id, telegram_id, first_name, last_name, user_name, status) VALUES (?, ?, ?, ?, ?, ?);",
+		member.GroupchatID,
+		member.TelegramID,
+		member.FirstName,
+		member.LastName,
+		member.UserName,
+		member.Status,
+	)
+	if err != nil {
+		return -1, err
+	}
+
+	return result.RowsAffected()
+}
+
+// GetGroupchatMember ...
+func GetGroupchatMember(db *sql.DB, member *GroupchatMember) (*GroupchatMember, error) {
+	var returnModel GroupchatMember
+
+	result, err := QuerySQLObject(db, returnModel, `SELECT * FROM groupchat_members WHERE groupchat_id = ? AND telegram_id = ?;`, member.GroupchatID, member.TelegramID)
+	if err != nil {
+		return nil, err
+	}
+
+	if returnModel, ok := result.Interface().(*GroupchatMember); ok && returnModel.Status != "" {
+		return returnModel, nil
+	}
+
+	return nil, errors.New(GroupChatMemberNotFound)
+}
+
+// GetGroupchatMembers returns users currently present in groupchat
+func GetGroupchatMembers(db *sql.DB, groupchat *Groupchat) (members []*GroupchatMember, err error) {
+	args := []interface{}{groupchat.ID}
+	for _, status := range PresentMemberStatuses {
+		args = append(args, status)
+	}
+
+	var returnModel GroupchatMember
+	sql := `SELECT
+	*
+FROM
+	groupchat_members
+WHERE
+	groupchat_id = ?
+		AND
+	status IN (?` + strings.Repeat(",?", len(PresentMemberStatuses)-1) + `)
+ORDER BY
+	status, user_name, telegram_id;`
+
+	result, err := QuerySQLList(db, returnModel, sql, args...)
+	if err != nil {
+		return members, err
+	}
+
+	for _, item := range result {
+		if returnModel, ok := item.Interface().(*GroupchatMember); ok {
+			members = append(members, returnModel)
+		}
+	}
+
+	return members, err
+}
+
+// GetGroupchatUnregisteredMembers returns users present in groupchat who are not registered employees
+func GetGroupchatUnregisteredMembers(db *sql.DB, groupchat *Groupchat) (members []*GroupchatMember, err error) {
+	args := []interface{}{groupchat.ID}
+	for _, status := range PresentMemberStatuses {
+		args = append(args, status)
+	}
+	// custom roles are employees too
+	args = append(args, New, Blocked, Deleted)
+
+	var returnModel GroupchatMember
+	sql := `SELECT
+	*
+FROM
+	groupchat_members
+WHERE
+	groupchat_id = ?
+		AND
+	status IN (?` + strings.Repeat(",?", len(PresentMemberStatuses)-1) + `)
+		AND
+	telegram_id NOT IN (SELECT telegram_id FROM users WHERE role NOT IN (?, ?, ?))
+ORDER BY
+	status, user_name, telegram_id;`
+
+	result, err := QuerySQLList(db, returnModel, sql, args...)
+	if err != nil {
+		return members, err
+	}
+
+	for _, item := range result {
+		if returnModel, ok := item.Interface().(*GroupchatMember); ok {
+			members = append(members, returnModel)
+		}
+	}
+
+	return members, err
+}
+
+// GetGroupchatsByMemberTelegramID returns groupchats where user is present
+func GetGroupchatsByMemberTelegramID(db *sql.DB, telegramID int64) (groupchats []*Groupchat, err error) {
+	args := []interface{}{telegramID}
+	for _, status := range PresentMemberStatuses {
+		args = append(args, status)
+	}
+
+	var returnModel Groupchat
+	sql := `SELECT
+	*
+FROM
+	groupchats
+WHERE
+	id IN (SELECT groupchat_id FROM groupchat_members WHERE telegram_id = ? AND status IN (?` + strings.Repeat(",?", len(PresentMemberStatuses)-1) + `))
+ORDER BY
+	state, title;`
+
+	result, err := QuerySQLList(db, returnModel, sql, args...)
+	if err != nil {
+		return groupchats, err
+	}
+
+	for _, item := range result {
+		if returnModel, ok := item.Interface().(*Groupchat); ok {
+			groupchats = append(groupchats, returnModel)
+		}
+	}
+
+	return groupchats, err
+}
+
+// GetGroupchatExpectedMembers returns Telegram IDs of users present in groupchat and of users who should be there
+// through an active group linked to it or through a child group of such group
+func GetGroupchatExpectedMembers(db *sql.DB, groupchat *Groupchat) (telegramIDs []int64, err error) {
+	args := []interface{}{groupchat.ID, Active, groupchat.ID}
+	for _, status := range PresentMemberStatuses {
+		args = append(args, status)
+	}
+
+	rows, err := db.Query(`WITH RECURSIVE descendants(id) AS (
+	SELECT group_id FROM groups_groupchats WHERE groupchat_id = ? AND group_id IN (SELECT id FROM groups WHERE state = ?)
+		UNION
+	SELECT groups_groups.group_id FROM groups_groups JOIN descendants ON groups_groups.parent_id = descendants.id
+)
+SELECT telegram_id FROM groupchat_members WHERE groupchat_id = ? AND status IN (?`+strings.Repeat(",?", len(PresentMemberStatuses)-1)+`)
+	UNION
+SELECT telegram_id FROM users WHERE id IN (SELECT user_id FROM groups_users WHERE group_id IN (SELECT id FROM descendants));`, args...)
+	if err != nil {
+		return telegramIDs, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var telegramID int64
+		if err = rows.Scan(&telegramID); err != nil {
+			return telegramIDs, err
+		}
+		telegramIDs = append(telegramIDs, telegramID)
+	}
+
+	return telegramIDs, rows.Err()
+}
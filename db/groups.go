@@ -154,6 +154,22 @@ func GetGroupByName(db *sql.DB, group *Group) (*Group, error) {
 	return nil, errors.New(GroupNotFound)
 }
 
+// GetGroupByID ...
+func GetGroupByID(db *sql.DB, group *Group) (*Group, error) {
+	var returnModel Group
+
+	result, err := QuerySQLObject(db, returnModel, `SELECT * FROM groups WHERE id = ?;`, group.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if returnModel, ok := result.Interface().(*Group); ok && returnModel.Name != "" {
+		return returnModel, nil
+	}
+
+	return nil, errors.New(GroupNotFound)
+}
+
 // AddGroupGroupChatIfNotExist ...
 func AddGroupGroupChatIfNotExist(db *sql.DB, group *Group, groupchat *Groupchat) (bool, error) {
 	res, err := db.Exec(
@@ -189,8 +205,58 @@ func DeleteGroupGroupChat(db *sql.DB, group *Group, groupchat *Groupchat) (bool,
 
 // AddGroupUserIfNotExist ...
 func AddGroupUserIfNotExist(db *sql.DB, group *Group, user *User) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	if err = addGroupUser(tx, group.ID, user.ID); err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// addGroupUser adds user to group in transaction, an expiry left from a previous membership is removed, so the new
+// membership is permanent until an expiry is set
+func addGroupUser(tx *sql.Tx, groupID, userID int64) error {
+	if _, err := tx.Exec("INSERT INTO groups_users (group_id, user_id) VALUES (?, ?);", groupID, userID); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec("DELETE FROM expiries WHERE group_id = ? AND user_id = ?;", groupID, userID)
+
+	return err
+}
+
+// DeleteGroupUser ...
+func DeleteGroupUser(db *sql.DB, group *Group, user *User) (bool, error) {
+	_, err := db.Exec(
+		"DELETE FROM groups_Users WHERE group_id = ? AND user_id = ?;",
+		group.ID,
+		user.ID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = db.Exec("DELETE FROM expiries WHERE group_id = ? AND user_id = ?;", group.ID, user.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// AddGroupManagerIfNotExist ...
+func AddGroupManagerIfNotExist(db *sql.DB, group *Group, user *User) (bool, error) {
 	res, err := db.Exec(
-		"INSERT INTO groups_Users (group_id, user_id) VALUES (?, ?);",
+		"INSERT INTO groups_managers (group_id, user_id) VALUES (?, ?);",
 		group.ID,
 		user.ID,
 	)
@@ -206,10 +272,10 @@ func AddGroupUserIfNotExist(db *sql.DB, group *Group, user *User) (bool, error)
 	return true, nil
 }
 
-// DeleteGroupUser ...
-func DeleteGroupUser(db *sql.DB, group *Group, user *User) (bool, error) {
+// DeleteGroupManager ...
+func DeleteGroupManager(db *sql.DB, group *Group, user *User) (bool, error) {
 	_, err := db.Exec(
-		"DELETE FROM groups_Users WHERE group_id = ? AND user_id = ?;",
+		"DELETE FROM groups_managers WHERE group_id = ? AND user_id = ?;",
 		group.ID,
 		user.ID,
 	)
@@ -0,0 +1,291 @@
+package db
+
+import (
+	"errors"
+
+	sql "github.com/lazada/sqle"
+
+	_ "github.com/mattn/go-sqlite3" // Register some sql
+)
+
+// ancestorsCTE selects group given as the first query argument and all of its ancestors
+const ancestorsCTE = `WITH RECURSIVE ancestors(id) AS (
+	SELECT ?
+		UNION
+	SELECT groups_groups.parent_id FROM groups_groups JOIN ancestors ON groups_groups.group_id = ancestors.id
+)
+`
+
+// descendantsCTE selects group given as the first query argument and all of its descendants
+const descendantsCTE = `WITH RECURSIVE descendants(id) AS (
+	SELECT ?
+		UNION
+	SELECT groups_groups.group_id FROM groups_groups JOIN descendants ON groups_groups.parent_id = descendants.id
+)
+`
+
+// SetGroupParent makes group a child of parent, nil parent makes group a root one
+func SetGroupParent(db *sql.DB, group, parent *Group) (bool, error) {
+	if parent == nil {
+		_, err := db.Exec("DELETE FROM groups_groups WHERE group_id = ?;", group.ID)
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	descendants, err := GetGroupDescendants(db, group)
+	if err != nil {
+		return false, err
+	}
+
+	for _, d := range descendants {
+		if d.ID == parent.ID {
+			return false, errors.New(GroupCycle)
+		}
+	}
+
+	if group.ID == parent.ID {
+		return false, errors.New(GroupCycle)
+	}
+
+	_, err = db.Exec(
+		"INSERT INTO groups_groups (parent_id, group_id) VALUES (?, ?);",
+		parent.ID,
+		group.ID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetGroupParent ...
+func GetGroupParent(db *sql.DB, group *Group) (*Group, error) {
+	var returnModel Group
+
+	result, err := QuerySQLObject(db, returnModel, `SELECT * FROM groups WHERE id = (SELECT parent_id FROM groups_groups WHERE group_id = ?);`, group.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if returnModel, ok := result.Interface().(*Group); ok && returnModel.Name != "" {
+		return returnModel, nil
+	}
+
+	return nil, errors.New(GroupNotFound)
+}
+
+// GetGroupChildren ...
+func GetGroupChildren(db *sql.DB, group *Group) ([]*Group, error) {
+	var returnModel Group
+	sql := `SELECT
+	*
+FROM
+	groups
+WHERE
+	id IN (SELECT group_id FROM groups_groups WHERE parent_id = ?)
+ORDER BY
+	state, name;`
+
+	return queryGroups(db, returnModel, sql, group.ID)
+}
+
+// GetGroupAncestors returns parent, parent of parent and so on
+func GetGroupAncestors(db *sql.DB, group *Group) ([]*Group, error) {
+	var returnModel Group
+	sql := ancestorsCTE + `SELECT
+	*
+FROM
+	groups
+WHERE
+	id IN (SELECT id FROM ancestors) AND id != ?
+ORDER BY
+	state, name;`
+
+	return queryGroups(db, returnModel, sql, group.ID, group.ID)
+}
+
+// GetGroupDescendants returns children, children of children and so on
+func GetGroupDescendants(db *sql.DB, group *Group) ([]*Group, error) {
+	var returnModel Group
+	sql := descendantsCTE + `SELECT
+	*
+FROM
+	groups
+WHERE
+	id IN (SELECT id FROM descendants) AND id != ?
+ORDER BY
+	state, name;`
+
+	return queryGroups(db, returnModel, sql, group.ID, group.ID)
+}
+
+// GetGroupParents returns map of group ID to its parent group ID
+func GetGroupParents(db *sql.DB) (map[int64]int64, error) {
+	parents := make(map[int64]int64)
+
+	rows, err := db.Query("SELECT group_id, parent_id FROM groups_groups;")
+	if err != nil {
+		return parents, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var groupID, parentID int64
+		if err = rows.Scan(&groupID, &parentID); err != nil {
+			return parents, err
+		}
+		parents[groupID] = parentID
+	}
+
+	return parents, rows.Err()
+}
+
+// GetInheritedGroupchatsByGroupID returns groupchats of active group and all of its active ancestors
+func GetInheritedGroupchatsByGroupID(db *sql.DB, groupID int64) ([]*Groupchat, error) {
+	var returnModel Groupchat
+	sql := ancestorsCTE + `SELECT
+	*
+FROM
+	groupchats
+WHERE
+	id IN (
+		SELECT groupchat_id FROM groups_groupchats WHERE group_id IN (
+			SELECT id FROM groups WHERE id IN (SELECT id FROM ancestors) AND state = ?
+		)
+	)
+ORDER BY
+	state, title;`
+
+	return queryGroupchats(db, returnModel, sql, groupID, Active)
+}
+
+// GetGroupsByUserID returns groups where user is a member
+func GetGroupsByUserID(db *sql.DB, userID int64) ([]*Group, error) {
+	var returnModel Group
+	sql := `SELECT
+	*
+FROM
+	groups
+WHERE
+	id IN (SELECT group_id FROM groups_users WHERE user_id = ?)
+ORDER BY
+	state, name;`
+
+	return queryGroups(db, returnModel, sql, userID)
+}
+
+// GetEffectiveGroupchatsByUserID returns groupchats available to user through user's groups and their ancestors
+func GetEffectiveGroupchatsByUserID(db *sql.DB, userID int64) ([]*Groupchat, error) {
+	var returnModel Groupchat
+	sql := `WITH RECURSIVE ancestors(id) AS (
+	SELECT group_id FROM groups_users WHERE user_id = ?
+		UNION
+	SELECT groups_groups.parent_id FROM groups_groups JOIN ancestors ON groups_groups.group_id = ancestors.id
+)
+SELECT
+	*
+FROM
+	groupchats
+WHERE
+	id IN (
+		SELECT groupchat_id FROM groups_groupchats WHERE group_id IN (
+			SELECT id FROM groups WHERE id IN (SELECT id FROM ancestors) AND state = ?
+		)
+	)
+ORDER BY
+	state, title;`
+
+	return queryGroupchats(db, returnModel, sql, userID, Active)
+}
+
+func queryGroups(db *sql.DB, returnModel Group, sql string, args ...interface{}) (groups []*Group, err error) {
+	result, err := QuerySQLList(db, returnModel, sql, args...)
+	if err != nil {
+		return groups, err
+	}
+
+	for _, item := range result {
+		if returnModel, ok := item.Interface().(*Group); ok {
+			groups = append(groups, returnModel)
+		}
+	}
+
+	return groups, err
+}
+
+func queryGroupchats(db *sql.DB, returnModel Groupchat, sql string, args ...interface{}) (groupchats []*Groupchat, err error) {
+	result, err := QuerySQLList(db, returnModel, sql, args...)
+	if err != nil {
+		return groupchats, err
+	}
+
+	for _, item := range result {
+		if returnModel, ok := item.Interface().(*Groupchat); ok {
+			groupchats = append(groupchats, returnModel)
+		}
+	}
+
+	return groupchats, err
+}
+
+// IsGroupManager checks if user manages group or any of its ancestors
+func IsGroupManager(db *sql.DB, group *Group, user *User) (bool, error) {
+	var count int64
+
+	err := db.QueryRow(ancestorsCTE+`SELECT COUNT(*) FROM groups_managers WHERE user_id = ? AND group_id IN (SELECT id FROM ancestors);`, group.ID, user.ID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// GetManagedGroupsByUserID returns groups managed by user directly or through their ancestors
+func GetManagedGroupsByUserID(db *sql.DB, userID int64) ([]*Group, error) {
+	var returnModel Group
+	sql := `WITH RECURSIVE descendants(id) AS (
+	SELECT group_id FROM groups_managers WHERE user_id = ?
+		UNION
+	SELECT groups_groups.group_id FROM groups_groups JOIN descendants ON groups_groups.parent_id = descendants.id
+)
+SELECT
+	*
+FROM
+	groups
+WHERE
+	id IN (SELECT id FROM descendants) AND state = ?
+ORDER BY
+	state, name;`
+
+	return queryGroups(db, returnModel, sql, userID, Active)
+}
+
+// GetInheritedManagersByGroupID returns managers of group and of all its ancestors
+func GetInheritedManagersByGroupID(db *sql.DB, groupID int64) (users []*User, err error) {
+	var returnModel User
+	sql := ancestorsCTE + `SELECT
+	*
+FROM
+	users
+WHERE
+	id IN (SELECT user_id FROM groups_managers WHERE group_id IN (SELECT id FROM ancestors))
+ORDER BY
+	role, id;`
+
+	result, err := QuerySQLList(db, returnModel, sql, groupID)
+	if err != nil {
+		return users, err
+	}
+
+	for _, item := range result {
+		if returnModel, ok := item.Interface().(*User); ok {
+			users = append(users, returnModel)
+		}
+	}
+
+	return users, err
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	sql "github.com/lazada/sqle"
+	_ "github.com/mattn/go-sqlite3" // Register some sql
+)
+
+// UserImport is a user created (ID is 0) or changed by bulk import, user is added to Groups
+type UserImport struct {
+	User   *User
+	Groups []*Group
+}
+
+// ImportUsers applies all imported users in one transaction, nothing is changed if any of them fails
+func ImportUsers(db *sql.DB, imports []*UserImport) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, i := range imports {
+		u := i.User
+
+		if u.ID == 0 {
+			res, err := tx.Exec(
+				"INSERT INTO users (first_name, last_name, user_name, telegram_id, is_bot, role) VALUES (?, ?, ?, ?, False, ?);",
+				u.FirstName,
+				u.LastName,
+				u.UserName,
+				u.TelegramID,
+				u.Role,
+			)
+			if err != nil {
+				_ = tx.Rollback()
+				return err
+			}
+
+			if u.ID, err = res.LastInsertId(); err != nil {
+				_ = tx.Rollback()
+				return err
+			}
+		} else if _, err = tx.Exec("UPDATE users SET role = ? WHERE id = ? AND role != 'owner';", u.Role, u.ID); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+
+		if !u.Birthday.IsZero() {
+			if _, err = tx.Exec("UPDATE users SET birthday = ? WHERE id = ?;", u.Birthday, u.ID); err != nil {
+				_ = tx.Rollback()
+				return err
+			}
+		}
+
+		for _, g := range i.Groups {
+			if err = addGroupUser(tx, g.ID, u.ID); err != nil {
+				_ = tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"time"
+
+	sql "github.com/lazada/sqle"
+	_ "github.com/mattn/go-sqlite3" // Register some sql
+)
+
+// UserName is a previous username of user
+type UserName struct {
+	ID        int64     `sql:"id"`
+	UserID    int64     `sql:"user_id"`
+	UserName  string    `sql:"user_name"`
+	CreatedAt time.Time `sql:"created_at"`
+}
+
+// SyncUserProfile writes names and language of user as Telegram reports them now, a replaced username is kept in
+// history. Empty language code means that client didn't report it, so the stored one is kept
+func SyncUserProfile(db *sql.DB, stored, actual *User) (bool, error) {
+	languageCode := actual.LanguageCode
+	if languageCode == "" {
+		languageCode = stored.LanguageCode
+	}
+
+	if stored.FirstName == actual.FirstName && stored.LastName == actual.LastName && stored.UserName == actual.UserName && stored.LanguageCode == languageCode {
+		return false, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	if stored.UserName != "" && stored.UserName != actual.UserName {
+		if _, err = tx.Exec("INSERT INTO users_usernames (user_id, user_name) VALUES (?, ?);", stored.ID, stored.UserName); err != nil {
+			_ = tx.Rollback()
+			return false, err
+		}
+	}
+
+	_, err = tx.Exec(
+		"UPDATE users SET first_name = ?, last_name = ?, user_name = ?, language_code = ? WHERE id = ?;",
+		actual.FirstName,
+		actual.LastName,
+		actual.UserName,
+		languageCode,
+		stored.ID,
+	)
+	if err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+
+	stored.FirstName, stored.LastName, stored.UserName, stored.LanguageCode = actual.FirstName, actual.LastName, actual.UserName, languageCode
+
+	return true, nil
+}
+
+// GetUserNames returns previous usernames of user, newest first
+func GetUserNames(db *sql.DB, user *User) (names []*UserName, err error) {
+	var returnModel UserName
+
+	result, err := QuerySQLList(db, returnModel, `SELECT * FROM users_usernames WHERE user_id = ? ORDER BY id DESC;`, user.ID)
+	if err != nil {
+		return names, err
+	}
+
+	for _, item := range result {
+		if returnModel, ok := item.Interface().(*UserName); ok {
+			names = append(names, returnModel)
+		}
+	}
+
+	return names, err
+}
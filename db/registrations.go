@@ -0,0 +1,178 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	sql "github.com/lazada/sqle"
+
+	_ "github.com/mattn/go-sqlite3" // Register some sql
+)
+
+// Registration is an application of a new user waiting for admin decision
+type Registration struct {
+	ID         int64     `sql:"id"`
+	TelegramID int64     `sql:"telegram_id"`
+	Name       string    `sql:"name"`
+	Department string    `sql:"department"`
+	Reason     string    `sql:"reason"`
+	State      string    `sql:"state"`
+	Role       string    `sql:"role"`
+	HandledBy  int64     `sql:"handled_by"`
+	CreatedAt  time.Time `sql:"created_at"`
+	UpdatedAt  time.Time `sql:"updated_at"`
+}
+
+// RegistrationMessage is a message about registration sent to admin
+type RegistrationMessage struct {
+	ID             int64 `sql:"id"`
+	RegistrationID int64 `sql:"registration_id"`
+	ChatID         int64 `sql:"chat_id"`
+	MessageID      int   `sql:"message_id"`
+}
+
+func (r *Registration) String() string {
+	var b strings.Builder
+	b.WriteString("Name: ")
+	b.WriteString(r.Name)
+	b.WriteString("\nDepartment: ")
+	b.WriteString(r.Department)
+	b.WriteString("\nReason: ")
+	b.WriteString(r.Reason)
+
+	return b.String()
+}
+
+// AddRegistration ...
+func AddRegistration(db *sql.DB, registration *Registration) (*Registration, error) {
+	if registration.State == "" {
+		registration.State = Pending
+	}
+
+	res, err := db.Exec(
+		"INSERT INTO registrations (telegram_id, name, department, reason, state) VALUES (?, ?, ?, ?, ?);",
+		registration.TelegramID,
+		registration.Name,
+		registration.Department,
+		registration.Reason,
+		registration.State,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	registration.ID, err = res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	registration.CreatedAt = time.Now()
+
+	return registration, nil
+}
+
+// GetRegistration ...
+func GetRegistration(db *sql.DB, id int64) (*Registration, error) {
+	var returnModel Registration
+
+	result, err := QuerySQLObject(db, returnModel, `SELECT * FROM registrations WHERE id = ?;`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if returnModel, ok := result.Interface().(*Registration); ok && returnModel.ID != 0 {
+		return returnModel, nil
+	}
+
+	return nil, errors.New(RegistrationNotFound)
+}
+
+// GetLastRegistrationByTelegramID ...
+func GetLastRegistrationByTelegramID(db *sql.DB, telegramID int64) (*Registration, error) {
+	var returnModel Registration
+
+	result, err := QuerySQLObject(db, returnModel, `SELECT * FROM registrations WHERE telegram_id = ? ORDER BY id DESC LIMIT 1;`, telegramID)
+	if err != nil {
+		return nil, err
+	}
+
+	if returnModel, ok := result.Interface().(*Registration); ok && returnModel.ID != 0 {
+		return returnModel, nil
+	}
+
+	return nil, errors.New(RegistrationNotFound)
+}
+
+// GetRegistrations ...
+func GetRegistrations(db *sql.DB, state string) (registrations []*Registration, err error) {
+	var returnModel Registration
+
+	result, err := QuerySQLList(db, returnModel, `SELECT * FROM registrations WHERE state = ? ORDER BY id;`, state)
+	if err != nil {
+		return registrations, err
+	}
+
+	for _, item := range result {
+		if returnModel, ok := item.Interface().(*Registration); ok {
+			registrations = append(registrations, returnModel)
+		}
+	}
+
+	return registrations, err
+}
+
+// HandleRegistration stores decision on pending registration, only the first decision is stored
+func HandleRegistration(db *sql.DB, registration *Registration) error {
+	result, err := db.Exec(
+		"UPDATE registrations SET state = ?, role = ?, handled_by = ? WHERE id = ? AND state = ?;",
+		registration.State,
+		registration.Role,
+		registration.HandledBy,
+		registration.ID,
+		Pending)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return errors.New(RegistrationHandled)
+	}
+
+	return nil
+}
+
+// AddRegistrationMessage ...
+func AddRegistrationMessage(db *sql.DB, message *RegistrationMessage) error {
+	_, err := db.Exec(
+		"INSERT INTO registration_messages (registration_id, chat_id, message_id) VALUES (?, ?, ?);",
+		message.RegistrationID,
+		message.ChatID,
+		message.MessageID,
+	)
+
+	return err
+}
+
+// GetRegistrationMessages ...
+func GetRegistrationMessages(db *sql.DB, registrationID int64) (messages []*RegistrationMessage, err error) {
+	var returnModel RegistrationMessage
+
+	result, err := QuerySQLList(db, returnModel, `SELECT * FROM registration_messages WHERE registration_id = ?;`, registrationID)
+	if err != nil {
+		return messages, err
+	}
+
+	for _, item := range result {
+		if returnModel, ok := item.Interface().(*RegistrationMessage); ok {
+			messages = append(messages, returnModel)
+		}
+	}
+
+	return messages, err
+}
@@ -0,0 +1,326 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	dlog "github.com/amoghe/distillog"
+	sql "github.com/lazada/sqle"
+
+	_ "github.com/mattn/go-sqlite3" // Register some sql
+)
+
+// Role is a named set of permissions, system roles are created on start and can't be deleted
+type Role struct {
+	ID        int64     `sql:"id"`
+	Name      string    `sql:"name"`
+	IsSystem  bool      `sql:"is_system"`
+	CreatedAt time.Time `sql:"created_at"`
+}
+
+func (r *Role) String() string {
+	if r.IsSystem {
+		return r.Name + " (system)"
+	}
+
+	return r.Name
+}
+
+// Permission ...
+type Permission struct {
+	ID          int64     `sql:"id"`
+	Name        string    `sql:"name"`
+	Description string    `sql:"description"`
+	CreatedAt   time.Time `sql:"created_at"`
+}
+
+func (p *Permission) String() string {
+	if p.Description == "" {
+		return p.Name
+	}
+
+	return p.Name + " — " + p.Description
+}
+
+// AddRoleIfNotExist ...
+func AddRoleIfNotExist(db *sql.DB, role *Role) (*Role, error) {
+	var returnModel Role
+
+	result, err := QuerySQLObject(db, returnModel, `SELECT * FROM roles WHERE name = ?;`, role.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if returnModel, ok := result.Interface().(*Role); ok && returnModel.Name != "" {
+		return returnModel, errors.New(RoleAlreadyExists)
+	}
+
+	res, err := db.Exec(
+		"INSERT INTO roles (name, is_system) VALUES (?, ?);",
+		role.Name,
+		role.IsSystem,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	role.ID, err = res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	role.CreatedAt = time.Now()
+
+	dlog.Debugf("role %s (%d) added at %s\n", role.Name, role.ID, role.CreatedAt)
+
+	return role, nil
+}
+
+// GetRole ...
+func GetRole(db *sql.DB, name string) (*Role, error) {
+	var returnModel Role
+
+	result, err := QuerySQLObject(db, returnModel, `SELECT * FROM roles WHERE name = ?;`, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if returnModel, ok := result.Interface().(*Role); ok && returnModel.Name != "" {
+		return returnModel, nil
+	}
+
+	return nil, errors.New(RoleNotFound)
+}
+
+// GetRoles ...
+func GetRoles(db *sql.DB) (roles []*Role, err error) {
+	var returnModel Role
+
+	result, err := QuerySQLList(db, returnModel, `SELECT * FROM roles ORDER BY is_system DESC, id;`)
+	if err != nil {
+		return roles, err
+	}
+
+	for _, item := range result {
+		if returnModel, ok := item.Interface().(*Role); ok {
+			roles = append(roles, returnModel)
+		}
+	}
+
+	return roles, err
+}
+
+// DeleteRole deletes custom role which is not assigned to any user
+func DeleteRole(db *sql.DB, name string) error {
+	role, err := GetRole(db, name)
+	if err != nil {
+		return err
+	}
+
+	if role.IsSystem {
+		return errors.New(RoleIsSystem)
+	}
+
+	var count int64
+	if err = db.QueryRow("SELECT COUNT(*) FROM users WHERE role = ?;", role.Name).Scan(&count); err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return errors.New(RoleInUse)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM roles_permissions WHERE role = ?;", role.Name); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM roles WHERE name = ?;", role.Name); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AddPermissionIfNotExist stores permission, roles are granted the permission only when it's seen for the first time,
+// so permissions revoked at runtime stay revoked after restart
+func AddPermissionIfNotExist(db *sql.DB, permission *Permission, roles []string) (*Permission, error) {
+	var returnModel Permission
+
+	result, err := QuerySQLObject(db, returnModel, `SELECT * FROM permissions WHERE name = ?;`, permission.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if returnModel, ok := result.Interface().(*Permission); ok && returnModel.Name != "" {
+		if returnModel.Description != permission.Description {
+			_, err = db.Exec("UPDATE permissions SET description = ? WHERE name = ?;", permission.Description, permission.Name)
+			returnModel.Description = permission.Description
+		}
+
+		return returnModel, err
+	}
+
+	res, err := db.Exec(
+		"INSERT INTO permissions (name, description) VALUES (?, ?);",
+		permission.Name,
+		permission.Description,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	permission.ID, err = res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	permission.CreatedAt = time.Now()
+
+	for _, role := range roles {
+		if _, err = GrantPermission(db, role, permission.Name); err != nil {
+			return permission, err
+		}
+	}
+
+	dlog.Debugf("permission %s granted to %s\n", permission.Name, strings.Join(roles, ", "))
+
+	return permission, nil
+}
+
+// GetPermissions ...
+func GetPermissions(db *sql.DB) (permissions []*Permission, err error) {
+	var returnModel Permission
+
+	result, err := QuerySQLList(db, returnModel, `SELECT * FROM permissions ORDER BY name;`)
+	if err != nil {
+		return permissions, err
+	}
+
+	for _, item := range result {
+		if returnModel, ok := item.Interface().(*Permission); ok {
+			permissions = append(permissions, returnModel)
+		}
+	}
+
+	return permissions, err
+}
+
+// GetRolePermissions returns names of permissions granted to role
+func GetRolePermissions(db *sql.DB, role string) (permissions []string, err error) {
+	rows, err := db.Query("SELECT permission FROM roles_permissions WHERE role = ? ORDER BY permission;", role)
+	if err != nil {
+		return permissions, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var permission string
+		if err = rows.Scan(&permission); err != nil {
+			return permissions, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	return permissions, rows.Err()
+}
+
+// HasPermission checks if role is granted the permission
+func HasPermission(db *sql.DB, role, permission string) (bool, error) {
+	var count int64
+
+	err := db.QueryRow("SELECT COUNT(*) FROM roles_permissions WHERE role = ? AND permission = ?;", role, permission).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// GrantPermission ...
+func GrantPermission(db *sql.DB, role, permission string) (int64, error) {
+	if _, err := GetRole(db, role); err != nil {
+		return -1, err
+	}
+
+	var count int64
+	if err := db.QueryRow("SELECT COUNT(*) FROM permissions WHERE name = ?;", permission).Scan(&count); err != nil {
+		return -1, err
+	}
+
+	if count == 0 {
+		return -1, errors.New(PermissionNotFound)
+	}
+
+	result, err := db.Exec("INSERT INTO roles_permissions (role, permission) VALUES (?, ?);", role, permission)
+	if err != nil {
+		return -1, err
+	}
+
+	return result.RowsAffected()
+}
+
+// RevokePermission ...
+func RevokePermission(db *sql.DB, role, permission string) (int64, error) {
+	result, err := db.Exec("DELETE FROM roles_permissions WHERE role = ? AND permission = ?;", role, permission)
+	if err != nil {
+		return -1, err
+	}
+
+	return result.RowsAffected()
+}
+
+// DeletePermission deletes permission with its grants, e.g. a permission of a deleted command
+func DeletePermission(db *sql.DB, permission string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM roles_permissions WHERE permission = ?;", permission); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM permissions WHERE name = ?;", permission); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetUsersByPermission returns users whose role is granted the permission, owners are always included
+func GetUsersByPermission(db *sql.DB, permission string) (users []*User, err error) {
+	var returnModel User
+	sql := `SELECT
+	*
+FROM
+	users
+WHERE
+	(role = ? OR role IN (SELECT role FROM roles_permissions WHERE permission = ?))
+		AND
+	is_bot = False
+ORDER BY
+	role, id;`
+
+	result, err := QuerySQLList(db, returnModel, sql, Owner, permission)
+	if err != nil {
+		return users, err
+	}
+
+	for _, item := range result {
+		if returnModel, ok := item.Interface().(*User); ok {
+			users = append(users, returnModel)
+		}
+	}
+
+	return users, err
+}
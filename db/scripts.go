@@ -0,0 +1,129 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	sql "github.com/lazada/sqle"
+	_ "github.com/mattn/go-sqlite3" // Register some sql
+)
+
+// Script is a Lua script run by the scripts plugin, state is "enabled" or "disabled"
+type Script struct {
+	ID        int64     `sql:"id"`
+	Name      string    `sql:"name"`
+	Source    string    `sql:"source"`
+	State     string    `sql:"state"`
+	CreatedBy int64     `sql:"created_by"`
+	CreatedAt time.Time `sql:"created_at"`
+	UpdatedAt time.Time `sql:"updated_at"`
+}
+
+func (s *Script) String() string {
+	return s.Name + " — " + s.State
+}
+
+// IsEnabled ...
+func (s *Script) IsEnabled() bool {
+	return s.State == "enabled"
+}
+
+// GetScripts ...
+func GetScripts(db *sql.DB) (scripts []*Script, err error) {
+	var returnModel Script
+
+	result, err := QuerySQLList(db, returnModel, `SELECT * FROM scripts ORDER BY name;`)
+	if err != nil {
+		return scripts, err
+	}
+
+	for _, item := range result {
+		if returnModel, ok := item.Interface().(*Script); ok {
+			scripts = append(scripts, returnModel)
+		}
+	}
+
+	return scripts, nil
+}
+
+// GetScript ...
+func GetScript(db *sql.DB, name string) (*Script, error) {
+	var returnModel Script
+
+	result, err := QuerySQLObject(db, returnModel, `SELECT * FROM scripts WHERE name = ?;`, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if returnModel, ok := result.Interface().(*Script); ok && returnModel.Name != "" {
+		return returnModel, nil
+	}
+
+	return nil, errors.New(ScriptNotFound)
+}
+
+// SetScript creates script or changes the source of the one with the same name, its state is kept
+func SetScript(db *sql.DB, script *Script) error {
+	result, err := db.Exec(
+		"UPDATE scripts SET source = ?, updated_at = CURRENT_TIMESTAMP WHERE name = ?;",
+		script.Source,
+		script.Name)
+	if err != nil {
+		return err
+	}
+
+	if rows, err := result.RowsAffected(); err != nil || rows > 0 {
+		return err
+	}
+
+	if script.State == "" {
+		script.State = "enabled"
+	}
+
+	res, err := db.Exec(
+		"INSERT INTO scripts (name, source, state, created_by) VALUES (?, ?, ?, ?);",
+		script.Name,
+		script.Source,
+		script.State,
+		script.CreatedBy)
+	if err != nil {
+		return err
+	}
+
+	script.ID, err = res.LastInsertId()
+
+	return err
+}
+
+// UpdateScriptState ...
+func UpdateScriptState(db *sql.DB, script *Script) (int64, error) {
+	result, err := db.Exec(
+		"UPDATE scripts SET state = ? WHERE name = ? AND state != ?;",
+		script.State,
+		script.Name,
+		script.State)
+	if err != nil {
+		return -1, err
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteScript ...
+func DeleteScript(db *sql.DB, name string) error {
+	result, err := db.Exec("DELETE FROM scripts WHERE name = ?;", name)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return errors.New(ScriptNotFound)
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"time"
+
+	sql "github.com/lazada/sqle"
+	_ "github.com/mattn/go-sqlite3" // Register some sql
+)
+
+// PluginSetting is a value of setting declared by plugin, settings without a stored value use their defaults
+type PluginSetting struct {
+	ID        int64     `sql:"id"`
+	Plugin    string    `sql:"plugin"`
+	Name      string    `sql:"name"`
+	Value     string    `sql:"value"`
+	UpdatedAt time.Time `sql:"updated_at"`
+}
+
+// GetPluginSettings ...
+func GetPluginSettings(db *sql.DB, plugin string) (settings []*PluginSetting, err error) {
+	var returnModel PluginSetting
+
+	result, err := QuerySQLList(db, returnModel, `SELECT * FROM plugins_settings WHERE plugin = ? ORDER BY name;`, plugin)
+	if err != nil {
+		return settings, err
+	}
+
+	for _, item := range result {
+		if returnModel, ok := item.Interface().(*PluginSetting); ok {
+			settings = append(settings, returnModel)
+		}
+	}
+
+	return settings, nil
+}
+
+// SetPluginSetting stores value of setting, the previous one is replaced
+func SetPluginSetting(db *sql.DB, setting *PluginSetting) error {
+	_, err := db.Exec(
+		"INSERT INTO plugins_settings (plugin, name, value) VALUES (?, ?, ?);",
+		setting.Plugin,
+		setting.Name,
+		setting.Value)
+
+	return err
+}
+
+// DeletePluginSetting makes setting use its default value again
+func DeletePluginSetting(db *sql.DB, plugin, name string) (int64, error) {
+	result, err := db.Exec("DELETE FROM plugins_settings WHERE plugin = ? AND name = ?;", plugin, name)
+	if err != nil {
+		return -1, err
+	}
+
+	return result.RowsAffected()
+}
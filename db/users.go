@@ -13,15 +13,17 @@ import (
 
 // User ...
 type User struct {
-	ID         int64     `sql:"id"`
-	FirstName  string    `sql:"first_name"`
-	LastName   string    `sql:"last_name"`
-	UserName   string    `sql:"user_name"`
-	TelegramID int64     `sql:"telegram_id"`
-	IsBot      bool      `sql:"is_bot"`
-	Role       string    `sql:"role"`
-	Birthday   time.Time `sql:"birthday"`
-	CreatedAt  time.Time `sql:"created_at"`
+	ID           int64     `sql:"id"`
+	FirstName    string    `sql:"first_name"`
+	LastName     string    `sql:"last_name"`
+	UserName     string    `sql:"user_name"`
+	TelegramID   int64     `sql:"telegram_id"`
+	IsBot        bool      `sql:"is_bot"`
+	Role         string    `sql:"role"`
+	Birthday     time.Time `sql:"birthday"`
+	LanguageCode string    `sql:"language_code"`
+	Language     string    `sql:"language"`
+	CreatedAt    time.Time `sql:"created_at"`
 }
 
 func (u *User) String() string {
@@ -78,6 +80,12 @@ func (u *User) Paragraph() string {
 	b.WriteString(u.Role)
 	b.WriteString("\n")
 
+	if u.LanguageCode != "" {
+		b.WriteString("Language: ")
+		b.WriteString(u.LanguageCode)
+		b.WriteString("\n")
+	}
+
 	if !u.Birthday.IsZero() {
 		b.WriteString("Birthday: ")
 		b.WriteString(u.Birthday.Format("2006.01.02"))
@@ -107,13 +115,14 @@ func AddUserIfNotExist(db *sql.DB, user *User) (*User, error) {
 	}
 
 	res, err := db.Exec(
-		"INSERT INTO users (first_name, last_name, user_name, telegram_id, is_bot, role) VALUES (?, ?, ?, ?, ?, ?);",
+		"INSERT INTO users (first_name, last_name, user_name, telegram_id, is_bot, role, language_code) VALUES (?, ?, ?, ?, ?, ?, ?);",
 		user.FirstName,
 		user.LastName,
 		user.UserName,
 		user.TelegramID,
 		user.IsBot,
 		user.Role,
+		user.LanguageCode,
 	)
 	if err != nil {
 		return nil, err
@@ -131,10 +140,19 @@ func AddUserIfNotExist(db *sql.DB, user *User) (*User, error) {
 	return user, nil
 }
 
-// GetUsers ...
+// GetUsers returns users with given roles, empty roles mean all roles except blocked and deleted
 func GetUsers(db *sql.DB, roles []string) (users []*User, err error) {
 	if len(roles) == 0 {
-		roles = []string{Owner, Admin, Member, New}
+		allRoles, err := GetRoles(db)
+		if err != nil {
+			return users, err
+		}
+
+		for _, role := range allRoles {
+			if role.Name != Blocked && role.Name != Deleted {
+				roles = append(roles, role.Name)
+			}
+		}
 	}
 
 	args := make([]interface{}, len(roles))
@@ -187,6 +205,21 @@ func UpdateUserRole(db *sql.DB, user *User) (int64, error) {
 	return rows, nil
 }
 
+// UpdateUserLanguage stores language chosen by user, empty one means the language of Telegram client
+func UpdateUserLanguage(db *sql.DB, user *User) (int64, error) {
+	result, err := db.Exec("UPDATE users SET language = ? WHERE telegram_id = ?;", user.Language, user.TelegramID)
+	if err != nil {
+		return -1, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return -1, err
+	}
+
+	return rows, nil
+}
+
 // UpdateUserBirthday ...
 func UpdateUserBirthday(db *sql.DB, user *User) (int64, error) {
 	result, err := db.Exec("UPDATE users SET birthday = ? WHERE telegram_id = ?;", user.Birthday, user.TelegramID)
@@ -217,3 +250,90 @@ func GetUserByTelegramID(db *sql.DB, user *User) (*User, error) {
 
 	return nil, errors.New(UserNotFound)
 }
+
+// GetUserByID finds user by database ID
+func GetUserByID(db *sql.DB, id int64) (*User, error) {
+	var returnModel User
+
+	result, err := QuerySQLObject(db, returnModel, `SELECT * FROM users WHERE id = ?;`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if returnModel, ok := result.Interface().(*User); ok && returnModel.Role != "" {
+		return returnModel, nil
+	}
+
+	return nil, errors.New(UserNotFound)
+}
+
+// GetUsersByUserName finds users by Telegram username, case insensitive and with or without leading @, usernames
+// may repeat when someone took a username another user had before
+func GetUsersByUserName(db *sql.DB, userName string) (users []*User, err error) {
+	var returnModel User
+
+	result, err := QuerySQLList(db, returnModel, `SELECT * FROM users WHERE user_name = ? COLLATE NOCASE ORDER BY id;`, strings.TrimPrefix(userName, "@"))
+	if err != nil {
+		return users, err
+	}
+
+	for _, item := range result {
+		if returnModel, ok := item.Interface().(*User); ok {
+			users = append(users, returnModel)
+		}
+	}
+
+	return users, err
+}
+
+// GetUsersByGroupID ...
+func GetUsersByGroupID(db *sql.DB, groupID int64) (users []*User, err error) {
+	var returnModel User
+	sql := `SELECT
+	*
+FROM
+	users
+WHERE
+	id IN (SELECT user_id FROM groups_users WHERE group_id = ?)
+ORDER BY
+	role, id;`
+
+	result, err := QuerySQLList(db, returnModel, sql, groupID)
+	if err != nil {
+		return users, err
+	}
+
+	for _, item := range result {
+		if returnModel, ok := item.Interface().(*User); ok {
+			users = append(users, returnModel)
+		}
+	}
+
+	return users, err
+}
+
+// GetManagersByGroupID ...
+func GetManagersByGroupID(db *sql.DB, groupID int64) (users []*User, err error) {
+	var returnModel User
+	sql := `SELECT
+	*
+FROM
+	users
+WHERE
+	id IN (SELECT user_id FROM groups_managers WHERE group_id = ?)
+ORDER BY
+	role, id;`
+
+	result, err := QuerySQLList(db, returnModel, sql, groupID)
+	if err != nil {
+		return users, err
+	}
+
+	for _, item := range result {
+		if returnModel, ok := item.Interface().(*User); ok {
+			users = append(users, returnModel)
+		}
+	}
+
+	return users, err
+}
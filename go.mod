@@ -6,6 +6,7 @@ require (
 	github.com/amoghe/distillog v0.0.0-20180726233512-ae382b35b717
 	github.com/lazada/sqle v0.0.0-20171211164427-f1ca64d42ef4
 	github.com/mattn/go-sqlite3 v1.14.24
+	github.com/yuin/gopher-lua v1.1.1
 	golang.org/x/net v0.33.0
 	gopkg.in/telegram-bot-api.v4 v4.6.4
 )
@@ -0,0 +1,213 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	database "github.com/ad/corpobot/db"
+
+	dlog "github.com/amoghe/distillog"
+)
+
+// DefaultLanguage is used for users whose language has no locale, and for keys missing in a locale
+const DefaultLanguage = "en"
+
+//go:embed locales/*.json
+var files embed.FS
+
+// message is a plain text or plural forms ("one", "few", "many", "other") chosen by "count" argument
+type message struct {
+	Text   string
+	Plural map[string]string
+}
+
+func (m *message) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &m.Text); err == nil {
+		return nil
+	}
+
+	return json.Unmarshal(data, &m.Plural)
+}
+
+var locales = load()
+
+func load() map[string]map[string]message {
+	result := make(map[string]map[string]message)
+
+	entries, err := files.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+
+	for _, e := range entries {
+		data, err := files.ReadFile(path.Join("locales", e.Name()))
+		if err != nil {
+			panic(err)
+		}
+
+		locale := make(map[string]message)
+		if err := json.Unmarshal(data, &locale); err != nil {
+			panic(e.Name() + ": " + err.Error())
+		}
+
+		result[strings.TrimSuffix(e.Name(), ".json")] = locale
+	}
+
+	return result
+}
+
+// Languages returns codes of available locales
+func Languages() []string {
+	languages := make([]string, 0, len(locales))
+	for lang := range locales {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+
+	return languages
+}
+
+// Has checks if key is in the catalogue
+func Has(key string) bool {
+	_, ok := locales[DefaultLanguage][key]
+	return ok
+}
+
+// Lang is a language of user: the one chosen with /language, otherwise the one of Telegram client if there is a
+// locale for it
+func Lang(user *database.User) string {
+	if user == nil {
+		return DefaultLanguage
+	}
+
+	for _, code := range []string{user.Language, user.LanguageCode} {
+		code = strings.ToLower(code)
+		if i := strings.IndexAny(code, "-_"); i > 0 {
+			code = code[:i]
+		}
+
+		if _, ok := locales[code]; ok {
+			return code
+		}
+	}
+
+	return DefaultLanguage
+}
+
+// T translates key to the language of user, args are pairs of placeholder name and value: T(user, "key", "name", x)
+// fills "{name}" with x. A plural message is chosen by "count" argument
+func T(user *database.User, key string, args ...interface{}) string {
+	return Translate(Lang(user), key, args...)
+}
+
+// Translate is T for a language code
+func Translate(lang, key string, args ...interface{}) string {
+	msg, ok := locales[lang][key]
+	if !ok {
+		msg, ok = locales[DefaultLanguage][key]
+	}
+	if !ok {
+		dlog.Errorln("i18n: unknown key " + key)
+		return key
+	}
+
+	text := msg.Text
+	if msg.Plural != nil {
+		text = msg.Plural[pluralForm(lang, count(args))]
+		if text == "" {
+			text = msg.Plural["other"]
+		}
+	}
+
+	for i := 0; i+1 < len(args); i += 2 {
+		value := args[i+1]
+		if err, ok := value.(error); ok {
+			value = errText(lang, err)
+		}
+
+		text = strings.Replace(text, "{"+fmt.Sprint(args[i])+"}", fmt.Sprint(value), -1)
+	}
+
+	return text
+}
+
+func count(args []interface{}) int {
+	for i := 0; i+1 < len(args); i += 2 {
+		if args[i] == "count" {
+			if n, ok := args[i+1].(int); ok {
+				return n
+			}
+		}
+	}
+
+	return 0
+}
+
+// pluralForm follows CLDR rules for supported languages, others use English ones
+func pluralForm(lang string, n int) string {
+	if n < 0 {
+		n = -n
+	}
+
+	switch lang {
+	case "ru":
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return "one"
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return "few"
+		default:
+			return "many"
+		}
+	default:
+		if n == 1 {
+			return "one"
+		}
+		return "other"
+	}
+}
+
+// Error is an error with a translatable message
+type Error struct {
+	Key  string
+	Args []interface{}
+}
+
+// NewError makes an error which Err shows in the language of user
+func NewError(key string, args ...interface{}) *Error {
+	return &Error{Key: key, Args: args}
+}
+
+func (e *Error) Error() string {
+	return Translate(DefaultLanguage, e.Key, e.Args...)
+}
+
+// Err translates error for user: errors made by NewError and errors of package db have keys, others (e.g. from
+// Telegram API) are shown as is
+func Err(user *database.User, err error) string {
+	return errText(Lang(user), err)
+}
+
+func errText(lang string, err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return Translate(lang, e.Key, e.Args...)
+	}
+
+	key := "err." + err.Error()
+	if Has(key) {
+		return Translate(lang, key)
+	}
+
+	return err.Error()
+}
+
+// Failed is a common reply to a failed command
+func Failed(user *database.User, err error) string {
+	return T(user, "common.failed_error", "error", Err(user, err))
+}
@@ -0,0 +1,165 @@
+package i18n
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	database "github.com/ad/corpobot/db"
+)
+
+func TestLocalesHaveSameKeys(t *testing.T) {
+	base := locales[DefaultLanguage]
+
+	for lang, locale := range locales {
+		for key, msg := range base {
+			other, ok := locale[key]
+			if !ok {
+				t.Errorf("%s: missing key %s", lang, key)
+				continue
+			}
+
+			if (msg.Plural == nil) != (other.Plural == nil) {
+				t.Errorf("%s: key %s is plural in one locale only", lang, key)
+			}
+
+			if other.Plural != nil && other.Plural["other"] == "" {
+				t.Errorf("%s: key %s has no \"other\" form", lang, key)
+			}
+
+			if got, want := placeholders(other), placeholders(msg); got != want {
+				t.Errorf("%s: key %s has placeholders %s, %s expected", lang, key, got, want)
+			}
+		}
+
+		for key := range locale {
+			if _, ok := base[key]; !ok {
+				t.Errorf("%s: key %s is not in %s locale", lang, key, DefaultLanguage)
+			}
+		}
+	}
+}
+
+var placeholderRe = regexp.MustCompile(`\{[a-z_]+\}`)
+
+func placeholders(msg message) string {
+	texts := []string{msg.Text}
+	for _, text := range msg.Plural {
+		texts = append(texts, text)
+	}
+
+	seen := make(map[string]bool)
+	for _, text := range texts {
+		for _, p := range placeholderRe.FindAllString(text, -1) {
+			seen[p] = true
+		}
+	}
+
+	result := make([]string, 0, len(seen))
+	for p := range seen {
+		result = append(result, p)
+	}
+	sort.Strings(result)
+
+	return strings.Join(result, ",")
+}
+
+// keyRe finds literal keys passed to T, Translate and NewError in the sources
+var keyRe = regexp.MustCompile(`i18n\.(?:T\([^,]+|Translate\([^,]+|NewError\()\s*,?\s*"([a-z_]+\.[a-z_]+)"`)
+
+func TestUsedKeysExist(t *testing.T) {
+	found := 0
+
+	err := filepath.Walk("..", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() && (info.Name() == "vendor" || strings.HasPrefix(info.Name(), ".")) && path != ".." {
+			return filepath.SkipDir
+		}
+
+		if info.IsDir() || !strings.HasSuffix(path, ".go") {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range keyRe.FindAllStringSubmatch(string(data), -1) {
+			found++
+			if !Has(m[1]) {
+				t.Errorf("%s: unknown key %s", path, m[1])
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found == 0 {
+		t.Fatal("no keys found in the sources")
+	}
+}
+
+func TestPluralForm(t *testing.T) {
+	tests := []struct {
+		lang string
+		n    int
+		want string
+	}{
+		{"ru", 1, "one"},
+		{"ru", 2, "few"},
+		{"ru", 5, "many"},
+		{"ru", 11, "many"},
+		{"ru", 14, "many"},
+		{"ru", 21, "one"},
+		{"ru", 22, "few"},
+		{"en", 1, "one"},
+		{"en", 2, "other"},
+		{"en", 0, "other"},
+	}
+
+	for _, tt := range tests {
+		if got := pluralForm(tt.lang, tt.n); got != tt.want {
+			t.Errorf("pluralForm(%s, %d) = %s, %s expected", tt.lang, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestLang(t *testing.T) {
+	tests := []struct {
+		user *database.User
+		want string
+	}{
+		{nil, DefaultLanguage},
+		{&database.User{}, DefaultLanguage},
+		{&database.User{LanguageCode: "ru-RU"}, "ru"},
+		{&database.User{LanguageCode: "de"}, DefaultLanguage},
+		{&database.User{LanguageCode: "ru", Language: "en"}, "en"},
+	}
+
+	for _, tt := range tests {
+		if got := Lang(tt.user); got != tt.want {
+			t.Errorf("Lang(%+v) = %s, %s expected", tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	if got := Translate("ru", "expiry.extend", "count", 3); got != "продлить на 3 дня" {
+		t.Errorf("unexpected plural %q", got)
+	}
+
+	err := NewError("userref.not_found", "ref", "@alice")
+	if got := Translate("ru", "common.failed_error", "error", err); got != "не удалось: пользователь не найден: @alice" {
+		t.Errorf("nested error is not translated: %q", got)
+	}
+}
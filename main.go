@@ -2,18 +2,28 @@ package main
 
 import (
 	"log"
-	"sync"
 	"time"
 
 	config "github.com/ad/corpobot/config"
 	database "github.com/ad/corpobot/db"
 	"github.com/ad/corpobot/plugins"
 	_ "github.com/ad/corpobot/plugins/admin"
+	_ "github.com/ad/corpobot/plugins/audit"
+	_ "github.com/ad/corpobot/plugins/bulk"
+	_ "github.com/ad/corpobot/plugins/custom"
 	_ "github.com/ad/corpobot/plugins/echo"
+	_ "github.com/ad/corpobot/plugins/expiry"
+	"github.com/ad/corpobot/plugins/external"
 	_ "github.com/ad/corpobot/plugins/groupchats"
 	_ "github.com/ad/corpobot/plugins/groups"
+	_ "github.com/ad/corpobot/plugins/language"
 	_ "github.com/ad/corpobot/plugins/me"
+	_ "github.com/ad/corpobot/plugins/menu"
 	_ "github.com/ad/corpobot/plugins/messages"
+	_ "github.com/ad/corpobot/plugins/registration"
+	_ "github.com/ad/corpobot/plugins/requests"
+	_ "github.com/ad/corpobot/plugins/roles"
+	_ "github.com/ad/corpobot/plugins/scripts"
 	_ "github.com/ad/corpobot/plugins/starthelp"
 	_ "github.com/ad/corpobot/plugins/users"
 	telegram "github.com/ad/corpobot/telegram"
@@ -38,6 +48,8 @@ func main() {
 	config := config.InitConfig()
 	plugins.Config = config
 
+	external.Register(config.ExternalPlugins)
+
 	log.SetFlags(0)
 
 	// Init DB
@@ -55,30 +67,17 @@ func main() {
 		log.Printf("fail on telegram login: %v", err)
 		return
 	}
+	plugins.Bot = bot
 
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
-	updates, err := bot.GetUpdatesChan(u)
-	if err != nil {
-		log.Printf("[INIT] [Failed to init Telegram updates chan: %v]", err)
-		return
-	}
+	updates := telegram.GetUpdatesChan(bot, u)
 
 	dlog.Debugln("Waiting for plugins...")
 	for {
 		if plugins.DB != nil && plugins.DB.Ping() == nil {
-			var wg sync.WaitGroup
-
 			// Bootstrapper for plugins
-			plugins.Plugins.Range(func(k, v interface{}) bool {
-				wg.Add(1)
-				go func() {
-					defer wg.Done()
-					v.(plugins.TelegramPlugin).OnStart()
-				}()
-				return true
-			})
-			wg.Wait()
+			plugins.StartAll()
 			break
 		}
 		time.Sleep(time.Second)
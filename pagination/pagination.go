@@ -0,0 +1,204 @@
+package pagination
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
+	"github.com/ad/corpobot/plugins"
+	"github.com/ad/corpobot/telegram"
+
+	dlog "github.com/amoghe/distillog"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// PageSize is a number of items on one page
+const PageSize = 10
+
+// maxDataLength is a Telegram limit for callback data
+const maxDataLength = 64
+
+// counterData is callback data of page counter button, it only stops the spinner of client
+const counterData = "pagination.counter"
+
+func init() {
+	plugins.RegisterHandler(plugins.Handler{
+		UpdateTypes: []string{plugins.CallbackQueryUpdate},
+		Pattern:     regexp.MustCompile(`^` + regexp.QuoteMeta(counterData) + `$`),
+		Callback:    answerCounter,
+	})
+}
+
+func answerCounter(update *tgbotapi.Update, user *database.User) error {
+	_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, ""))
+
+	return err
+}
+
+// State of a list is carried in callback data on the last line of args: "p<page>" or "p<page>:<query>",
+// "s" asks for a search query
+type State struct {
+	Page   int
+	Query  string
+	Search bool
+}
+
+// Item ...
+type Item struct {
+	Text string
+	Data string
+}
+
+func (s State) String() string {
+	if s.Search {
+		return "s"
+	}
+
+	token := "p" + strconv.Itoa(s.Page)
+	if s.Query != "" {
+		token += ":" + s.Query
+	}
+
+	return token
+}
+
+// Parse extracts state from the last line of args, returns the rest of args
+func Parse(args string) (State, string) {
+	lines := strings.Split(args, "\n")
+	last := strings.TrimSpace(lines[len(lines)-1])
+	rest := strings.TrimSpace(strings.Join(lines[:len(lines)-1], "\n"))
+
+	if last == "s" {
+		return State{Search: true}, rest
+	}
+
+	if !strings.HasPrefix(last, "p") {
+		return State{}, strings.TrimSpace(args)
+	}
+
+	token, query := last[1:], ""
+	if i := strings.Index(token, ":"); i >= 0 {
+		token, query = token[:i], strings.TrimSpace(token[i+1:])
+	}
+
+	page, err := strconv.Atoi(token)
+	if err != nil || page < 0 {
+		return State{}, strings.TrimSpace(args)
+	}
+
+	return State{Page: page, Query: query}, rest
+}
+
+// Prefix is a callback data of list without state, args are separated from state with a new line
+func Prefix(command, args string) string {
+	if args == "" {
+		return command + " "
+	}
+
+	return command + " " + args + "\n"
+}
+
+// QueryArgs are args of list waiting for search query typed by user, see plugins.AwaitInput
+func QueryArgs(args string) string {
+	if args == "" {
+		return State{}.String() + ":"
+	}
+
+	return args + "\n" + State{}.String() + ":"
+}
+
+// Data appends state to prefix, search query is cut to fit callback data limit
+func Data(prefix string, state State) string {
+	data := prefix + state.String()
+	for len(data) > maxDataLength && state.Query != "" {
+		_, size := utf8.DecodeLastRuneInString(state.Query)
+		state.Query = state.Query[:len(state.Query)-size]
+		data = prefix + state.String()
+	}
+
+	return data
+}
+
+// Filter leaves items containing query
+func Filter(items []Item, query string) []Item {
+	if query == "" {
+		return items
+	}
+
+	query = strings.ToLower(query)
+
+	var result []Item
+	for _, item := range items {
+		if strings.Contains(strings.ToLower(item.Text), query) {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
+// Rows returns a page of items filtered by state query with a navigation row
+func Rows(items []Item, state State, prefix string, searchable bool) [][]tgbotapi.InlineKeyboardButton {
+	items = Filter(items, state.Query)
+
+	pages := (len(items) + PageSize - 1) / PageSize
+	if pages == 0 {
+		pages = 1
+	}
+
+	page := state.Page
+	if page >= pages {
+		page = pages - 1
+	}
+
+	end := (page + 1) * PageSize
+	if end > len(items) {
+		end = len(items)
+	}
+
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0)
+	for _, item := range items[page*PageSize : end] {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(item.Text, item.Data)))
+	}
+
+	var nav []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("<", Data(prefix, State{Page: page - 1, Query: state.Query})))
+	}
+	if pages > 1 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(strconv.Itoa(page+1)+"/"+strconv.Itoa(pages), counterData))
+	}
+	if page < pages-1 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(">", Data(prefix, State{Page: page + 1, Query: state.Query})))
+	}
+	if searchable {
+		if state.Query != "" {
+			nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("✕ "+state.Query, Data(prefix, State{})))
+		} else {
+			nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("🔍", Data(prefix, State{Search: true})))
+		}
+	}
+
+	if len(nav) > 0 {
+		rows = append(rows, nav)
+	}
+
+	return rows
+}
+
+// AskQuery asks user for a search query, typed text is passed back to command as a state of list
+func AskQuery(update *tgbotapi.Update, user *database.User, command, args string) error {
+	if update.CallbackQuery != nil {
+		_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, ""))
+		if err != nil {
+			dlog.Errorln(err.Error())
+		}
+	}
+
+	plugins.AwaitInput(user.TelegramID, command, QueryArgs(args))
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "common.send_search"))
+}
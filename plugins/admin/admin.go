@@ -1,7 +1,13 @@
 package admin
 
 import (
+	"sort"
+	"strconv"
+	"strings"
+
 	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
+	"github.com/ad/corpobot/pagination"
 	"github.com/ad/corpobot/plugins"
 	"github.com/ad/corpobot/telegram"
 
@@ -15,14 +21,21 @@ func init() {
 	plugins.RegisterPlugin(&Plugin{})
 }
 
-func (m *Plugin) OnStart() {
-	if !plugins.CheckIfPluginDisabled("admin.Plugin", "enabled") {
-		return
+// Meta ...
+func (m *Plugin) Meta() plugins.Meta {
+	return plugins.Meta{
+		Version: "1.0",
 	}
+}
 
-	plugins.RegisterCommand("pluginlist", "List of plugins", []string{database.Owner}, pluginList)
-	plugins.RegisterCommand("pluginenable", "Enable plugin", []string{database.Owner}, pluginEnable)
-	plugins.RegisterCommand("plugindisable", "Disable plugin", []string{database.Owner}, pluginDisable)
+func (m *Plugin) OnStart() {
+	plugins.RegisterPermission("plugins.manage", "Enable and disable plugins", database.Owner)
+	plugins.RegisterPermission("plugins.configure", "Change plugin settings", database.Owner)
+
+	plugins.RegisterCommand("pluginlist", "List of plugins", "plugins.manage", pluginList)
+	plugins.RegisterCommand("pluginenable", "Enable plugin", "plugins.manage", pluginEnable)
+	plugins.RegisterCommand("plugindisable", "Disable plugin", "plugins.manage", pluginDisable)
+	plugins.RegisterCommand("pluginconfig", "Plugin settings", "plugins.configure", pluginConfig)
 }
 
 func (m *Plugin) OnStop() {
@@ -31,14 +44,28 @@ func (m *Plugin) OnStop() {
 	plugins.UnregisterCommand("pluginlist")
 	plugins.UnregisterCommand("pluginenable")
 	plugins.UnregisterCommand("plugindisable")
+	plugins.UnregisterCommand("pluginconfig")
 }
 
 var pluginList plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
-	replyKeyboard := listPlugins()
-	return telegram.SendCustom(user.TelegramID, 0, "Choose action", false, &replyKeyboard)
+	state, _ := pagination.Parse(args)
+	if state.Search {
+		return pagination.AskQuery(update, user, command, "")
+	}
+
+	answer(update, "")
+
+	return reply(update, user, pluginsText(user), listPlugins(user, state))
 }
 
 var pluginEnable plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	state, args := pagination.Parse(args)
+
+	if err := plugins.EnablePlugin(args); err != nil {
+		answer(update, i18n.Failed(user, err))
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
 	plugin := &database.Plugin{
 		Name:  args,
 		State: "enabled",
@@ -46,36 +73,27 @@ var pluginEnable plugins.CommandCallback = func(update *tgbotapi.Update, command
 
 	_, err := database.UpdatePluginState(plugins.DB, plugin)
 	if err != nil {
-		return telegram.Send(user.TelegramID, "failed: "+err.Error())
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
 	}
-	if plugins.EnablePlugin(args) {
-		if update.CallbackQuery != nil {
-			_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, args+" enabled"))
-			if err != nil {
-				dlog.Errorln(err.Error())
-			}
-
-			replyKeyboard := listPlugins()
-			edit := tgbotapi.EditMessageReplyMarkupConfig{
-				BaseEdit: tgbotapi.BaseEdit{
-					ChatID:      update.CallbackQuery.Message.Chat.ID,
-					MessageID:   update.CallbackQuery.Message.MessageID,
-					ReplyMarkup: &replyKeyboard,
-				},
-			}
 
-			_, err = plugins.Bot.Send(edit)
-			return err
-		}
+	plugins.Audit(user, command, args, "disabled", "enabled")
 
-		return telegram.Send(user.TelegramID, args+" enabled")
+	if update.CallbackQuery != nil {
+		answer(update, i18n.T(user, "admin.enabled", "plugin", args))
+		return reply(update, user, pluginsText(user), listPlugins(user, state))
 	}
 
-	replyKeyboard := listPlugins()
-	return telegram.SendCustom(user.TelegramID, 0, "Choose action", false, &replyKeyboard)
+	return telegram.Send(user.TelegramID, i18n.T(user, "admin.enabled", "plugin", args))
 }
 
 var pluginDisable plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	state, args := pagination.Parse(args)
+
+	if err := plugins.DisablePlugin(args); err != nil {
+		answer(update, i18n.Failed(user, err))
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
 	plugin := &database.Plugin{
 		Name:  args,
 		State: "disabled",
@@ -83,49 +101,252 @@ var pluginDisable plugins.CommandCallback = func(update *tgbotapi.Update, comman
 
 	_, err := database.UpdatePluginState(plugins.DB, plugin)
 	if err != nil {
-		return telegram.Send(user.TelegramID, "failed: "+err.Error())
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
 	}
-	if plugins.DisablePlugin(args) {
-		if update.CallbackQuery != nil {
-			_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, args+" disabled"))
-			if err != nil {
-				dlog.Errorln(err.Error())
-			}
-			replyKeyboard := listPlugins()
-			edit := tgbotapi.EditMessageReplyMarkupConfig{
-				BaseEdit: tgbotapi.BaseEdit{
-					ChatID:      update.CallbackQuery.Message.Chat.ID,
-					MessageID:   update.CallbackQuery.Message.MessageID,
-					ReplyMarkup: &replyKeyboard,
-				},
-			}
 
-			_, err = plugins.Bot.Send(edit)
-			return err
+	plugins.Audit(user, command, args, "enabled", "disabled")
+
+	if update.CallbackQuery != nil {
+		answer(update, i18n.T(user, "admin.disabled", "plugin", args))
+		return reply(update, user, pluginsText(user), listPlugins(user, state))
+	}
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "admin.disabled", "plugin", args))
+}
+
+// pluginsText lists plugins with version, lifecycle state and dependencies
+func pluginsText(user *database.User) string {
+	allPlugins, err := database.GetPlugins(plugins.DB)
+	if err != nil {
+		dlog.Errorln(err.Error())
+		return i18n.Failed(user, err)
+	}
+
+	var b strings.Builder
+	for _, plugin := range allPlugins {
+		meta := pluginMeta(plugin.Name)
+		pluginState := plugins.PluginState(plugin.Name)
+
+		b.WriteString("• " + plugin.Name)
+		if meta.Version != "" {
+			b.WriteString(" " + meta.Version)
+		}
+		status := "admin.state_" + string(pluginState.Status)
+		b.WriteString(": " + i18n.T(user, status))
+		if pluginState.Error != nil {
+			b.WriteString(" (" + i18n.Err(user, pluginState.Error) + ")")
+		}
+		if len(meta.Dependencies) > 0 {
+			b.WriteString("\n  " + i18n.T(user, "admin.dependencies", "plugins", strings.Join(meta.Dependencies, ", ")))
 		}
+		b.WriteString("\n")
+	}
 
-		return telegram.Send(user.TelegramID, args+" disabled")
+	if b.Len() == 0 {
+		return i18n.T(user, "common.choose_action")
 	}
-	replyKeyboard := listPlugins()
-	return telegram.SendCustom(user.TelegramID, 0, "Choose action", false, &replyKeyboard)
+
+	return b.String()
 }
 
-func listPlugins() tgbotapi.InlineKeyboardMarkup {
-	buttons := make([][]tgbotapi.InlineKeyboardButton, 0)
+func pluginMeta(name string) plugins.Meta {
+	v, ok := plugins.Plugins.Load(name)
+	if !ok {
+		if v, ok = plugins.DisabledPlugins.Load(name); !ok {
+			return plugins.Meta{}
+		}
+	}
 
+	return plugins.MetaOf(v.(plugins.TelegramPlugin))
+}
+
+func listPlugins(user *database.User, state pagination.State) *tgbotapi.InlineKeyboardMarkup {
 	allPlugins, err := database.GetPlugins(plugins.DB)
 	if err != nil {
 		dlog.Errorln(err.Error())
-		return tgbotapi.NewInlineKeyboardMarkup(buttons...)
+		replyKeyboard := tgbotapi.NewInlineKeyboardMarkup()
+		return &replyKeyboard
 	}
 
+	items := make([]pagination.Item, 0, len(allPlugins))
 	for _, plugin := range allPlugins {
-		if plugin.IsEnabled() {
-			buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("disable "+plugin.Name, "/plugindisable "+plugin.Name)))
+		// failed plugins may be started again
+		if plugins.IsRunning(plugin.Name) {
+			items = append(items, pagination.Item{Text: i18n.T(user, "admin.disable", "plugin", plugin.Name), Data: pagination.Data("/plugindisable "+plugin.Name+"\n", state)})
 		} else {
-			buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("enable "+plugin.Name, "/pluginenable "+plugin.Name)))
+			items = append(items, pagination.Item{Text: i18n.T(user, "admin.enable", "plugin", plugin.Name), Data: pagination.Data("/pluginenable "+plugin.Name+"\n", state)})
+		}
+	}
+
+	replyKeyboard := tgbotapi.NewInlineKeyboardMarkup(pagination.Rows(items, state, pagination.Prefix("/pluginlist", ""), true)...)
+
+	return &replyKeyboard
+}
+
+// pluginConfig shows settings of plugin, "plugin\nsetting" asks for a new value, "plugin\nsetting\nvalue" stores it and
+// "-" as a value resets setting to default
+var pluginConfig plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	params := strings.SplitN(args, "\n", 3)
+	for i := range params {
+		params[i] = strings.TrimSpace(params[i])
+	}
+
+	if len(params) == 3 && params[2] == "" {
+		params = params[:2]
+	}
+
+	if params[0] == "" {
+		answer(update, "")
+
+		replyKeyboard := configurablePlugins()
+		if len(replyKeyboard.InlineKeyboard) == 0 {
+			return reply(update, user, i18n.T(user, "admin.no_configurable"), nil)
+		}
+
+		return reply(update, user, i18n.T(user, "admin.choose_plugin"), replyKeyboard)
+	}
+
+	settings, ok := plugins.GetSettings(params[0])
+	if !ok {
+		return telegram.Send(user.TelegramID, i18n.T(user, "admin.no_settings", "plugin", params[0]))
+	}
+
+	plugin := params[0]
+
+	if len(params) == 2 {
+		s, ok := plugins.FindSetting(plugin, params[1])
+		if !ok {
+			return telegram.Send(user.TelegramID, i18n.Failed(user, i18n.NewError("settings.unknown", "setting", params[1], "plugin", plugin)))
+		}
+
+		answer(update, "")
+		plugins.AwaitInput(user.TelegramID, command, plugin+"\n"+s.Name+"\n")
+
+		return telegram.Send(user.TelegramID, i18n.T(user, "admin.send_value", "setting", s.Name, "type", s.Type, "value", plugins.SettingValue(plugin, s.Name), "default", s.Default))
+	}
+
+	if len(params) == 3 {
+		value := params[2]
+		if value == "-" {
+			value = ""
+		}
+
+		before, err := plugins.SetSetting(plugin, params[1], value)
+		if err != nil {
+			answer(update, "")
+			return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+		}
+
+		if after := plugins.SettingValue(plugin, params[1]); after != before {
+			plugins.Audit(user, command, plugin+" "+params[1], before, after)
+		}
+
+		answer(update, i18n.T(user, "common.success"))
+	} else {
+		answer(update, "")
+	}
+
+	return reply(update, user, settingsText(user, plugin, settings), settingsKeyboard(user, plugin, settings))
+}
+
+func settingsText(user *database.User, plugin string, settings []plugins.Setting) string {
+	var b strings.Builder
+	b.WriteString(plugin + "\n")
+
+	for _, s := range settings {
+		value := plugins.SettingValue(plugin, s.Name)
+		if value == "" {
+			value = "—"
+		}
+
+		b.WriteString("\n• " + s.Name + " = " + value)
+		if !plugins.IsDefault(plugin, s.Name) {
+			b.WriteString(" " + i18n.T(user, "admin.setting_default", "default", s.Default))
 		}
+		b.WriteString("\n  " + plugins.SettingDescription(user, plugin, s) + " [" + string(s.Type) + "]")
+	}
+
+	return b.String()
+}
+
+func settingsKeyboard(user *database.User, plugin string, settings []plugins.Setting) *tgbotapi.InlineKeyboardMarkup {
+	buttons := make([][]tgbotapi.InlineKeyboardButton, 0, len(settings)+1)
+
+	for _, s := range settings {
+		data := "/pluginconfig " + plugin + "\n" + s.Name
+		text := s.Name
+
+		// bools are toggled right away
+		if s.Type == plugins.BoolSetting {
+			value := plugins.SettingBool(plugin, s.Name)
+			data += "\n" + strconv.FormatBool(!value)
+			if value {
+				text = "✓ " + text
+			} else {
+				text = "✕ " + text
+			}
+		}
+
+		row := tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(text, data))
+		if !plugins.IsDefault(plugin, s.Name) {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "admin.reset"), "/pluginconfig "+plugin+"\n"+s.Name+"\n-"))
+		}
+
+		buttons = append(buttons, row)
+	}
+
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "admin.plugins"), "/pluginconfig")))
+
+	replyKeyboard := tgbotapi.NewInlineKeyboardMarkup(buttons...)
+
+	return &replyKeyboard
+}
+
+// configurablePlugins lists plugins which declared settings
+func configurablePlugins() *tgbotapi.InlineKeyboardMarkup {
+	var names []string
+	plugins.Settings.Range(func(k, v interface{}) bool {
+		names = append(names, k.(string))
+		return true
+	})
+	sort.Strings(names)
+
+	buttons := make([][]tgbotapi.InlineKeyboardButton, 0, len(names))
+	for _, name := range names {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(name, "/pluginconfig "+name)))
+	}
+
+	replyKeyboard := tgbotapi.NewInlineKeyboardMarkup(buttons...)
+
+	return &replyKeyboard
+}
+
+func answer(update *tgbotapi.Update, text string) {
+	if update.CallbackQuery == nil {
+		return
+	}
+
+	_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, text))
+	if err != nil {
+		dlog.Errorln(err.Error())
+	}
+}
+
+// reply edits message with pressed button or sends a new one, callback query must be answered separately
+func reply(update *tgbotapi.Update, user *database.User, text string, replyKeyboard *tgbotapi.InlineKeyboardMarkup) error {
+	if update.CallbackQuery != nil {
+		editKeyboard := tgbotapi.EditMessageTextConfig{
+			BaseEdit: tgbotapi.BaseEdit{
+				ChatID:      update.CallbackQuery.Message.Chat.ID,
+				MessageID:   update.CallbackQuery.Message.MessageID,
+				ReplyMarkup: replyKeyboard,
+			},
+			Text: text,
+		}
+
+		_, err := plugins.Bot.Send(editKeyboard)
+		return err
 	}
 
-	return tgbotapi.NewInlineKeyboardMarkup(buttons...)
+	return telegram.SendCustom(user.TelegramID, 0, text, false, replyKeyboard)
 }
@@ -0,0 +1,29 @@
+package plugins
+
+import (
+	database "github.com/ad/corpobot/db"
+
+	dlog "github.com/amoghe/distillog"
+)
+
+// Audit records administrative action of user, action is usually a command name and target is a readable object of it
+func Audit(user *database.User, action, target, before, after string) {
+	entry := &database.AuditEntry{
+		ActorID: user.TelegramID,
+		Actor:   user.String(),
+		Action:  action,
+		Target:  target,
+		Before:  before,
+		After:   after,
+	}
+
+	if err := database.AddAuditEntry(DB, entry); err != nil {
+		dlog.Errorln("audit failed: " + err.Error())
+		return
+	}
+
+	Publish(&AuditRecorded{Entry: entry})
+}
+
+// SystemUser is an actor of actions made by the bot itself, like revoking expired access
+var SystemUser = &database.User{FirstName: "corpobot", Role: "system"}
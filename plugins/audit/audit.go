@@ -0,0 +1,183 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"strings"
+	"time"
+
+	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
+	"github.com/ad/corpobot/plugins"
+	"github.com/ad/corpobot/telegram"
+
+	dlog "github.com/amoghe/distillog"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+type Plugin struct{}
+
+// defaultLimit is a number of entries shown by /audit
+const defaultLimit = 30
+
+// maxMessageLength is a bit less than Telegram message limit
+const maxMessageLength = 4000
+
+func init() {
+	plugins.RegisterPlugin(&Plugin{})
+}
+
+// Meta ...
+func (m *Plugin) Meta() plugins.Meta {
+	return plugins.Meta{
+		Version: "1.0",
+	}
+}
+
+func (m *Plugin) OnStart() {
+	plugins.RegisterPermission("audit.view", "View and export audit log", database.Admin, database.Owner)
+
+	plugins.RegisterCommand("audit", "Audit log of administrative actions", "audit.view", audit)
+	plugins.RegisterCommand("auditexport", "Export audit log to CSV", "audit.view", auditExport)
+}
+
+func (m *Plugin) OnStop() {
+	dlog.Debugln("[audit.Plugin] Stopped")
+
+	plugins.UnregisterCommand("audit")
+	plugins.UnregisterCommand("auditexport")
+}
+
+var audit plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	filter, err := parseFilter(args)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err)+"\n\n"+i18n.T(user, "audit.filter_help"))
+	}
+
+	if filter.Limit == 0 {
+		filter.Limit = defaultLimit
+	}
+
+	entries, err := database.GetAuditEntries(plugins.DB, filter)
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		return telegram.Send(user.TelegramID, i18n.T(user, "audit.empty")+"\n\n"+i18n.T(user, "audit.filter_help"))
+	}
+
+	var b strings.Builder
+	for _, e := range entries {
+		line := "* " + e.String() + "\n"
+		if b.Len()+len(line) > maxMessageLength {
+			b.WriteString(i18n.T(user, "audit.truncated"))
+			break
+		}
+		b.WriteString(line)
+	}
+
+	return telegram.Send(user.TelegramID, b.String())
+}
+
+var auditExport plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	filter, err := parseFilter(args)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err)+"\n\n"+i18n.T(user, "audit.filter_help"))
+	}
+
+	entries, err := database.GetAuditEntries(plugins.DB, filter)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err = w.Write([]string{"id", "created_at", "actor_id", "actor", "action", "target", "before", "after"}); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		record := []string{
+			strconv.FormatInt(e.ID, 10),
+			e.CreatedAt.UTC().Format(time.RFC3339),
+			strconv.FormatInt(e.ActorID, 10),
+			e.Actor,
+			e.Action,
+			e.Target,
+			e.Before,
+			e.After,
+		}
+		if err = w.Write(record); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	if err = w.Error(); err != nil {
+		return err
+	}
+
+	doc := tgbotapi.NewDocumentUpload(user.TelegramID, tgbotapi.FileBytes{
+		Name:  "audit-" + time.Now().Format("2006-01-02") + ".csv",
+		Bytes: buf.Bytes(),
+	})
+
+	_, err = plugins.Bot.Send(doc)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	return nil
+}
+
+// parseFilter reads "key=value" pairs, dates are inclusive
+func parseFilter(args string) (database.AuditFilter, error) {
+	var filter database.AuditFilter
+
+	for _, token := range strings.Fields(args) {
+		kv := strings.SplitN(token, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return filter, i18n.NewError("audit.unknown_filter", "filter", token)
+		}
+
+		key, value := strings.ToLower(kv[0]), kv[1]
+
+		switch key {
+		case "user":
+			if id, err := strconv.ParseInt(value, 10, 64); err == nil {
+				filter.ActorID = id
+			} else {
+				filter.Actor = value
+			}
+		case "action":
+			filter.Action = strings.TrimPrefix(value, "/")
+		case "target":
+			filter.Target = value
+		case "from":
+			t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+			if err != nil {
+				return filter, err
+			}
+			filter.From = t
+		case "to":
+			t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+			if err != nil {
+				return filter, err
+			}
+			filter.To = t.AddDate(0, 0, 1)
+		case "limit":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return filter, i18n.NewError("audit.unknown_filter", "filter", token)
+			}
+			filter.Limit = n
+		default:
+			return filter, i18n.NewError("audit.unknown_filter", "filter", token)
+		}
+	}
+
+	return filter, nil
+}
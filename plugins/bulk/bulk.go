@@ -0,0 +1,638 @@
+package bulk
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
+	"github.com/ad/corpobot/plugins"
+	"github.com/ad/corpobot/telegram"
+
+	dlog "github.com/amoghe/distillog"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+type Plugin struct{}
+
+// maxDocumentSize limits imported documents
+const maxDocumentSize = 1 << 20
+
+// maxMessageLength is a bit less than Telegram message limit
+const maxMessageLength = 4000
+
+// columns of CSV document, the same keys are used in JSON
+var columns = []string{"telegram_id", "username", "first_name", "last_name", "role", "groups", "birthday"}
+
+// pendingImports are previewed records waiting for confirmation, by Telegram ID of admin
+var pendingImports sync.Map
+
+// record is a user row of imported or exported document
+type record struct {
+	TelegramID int64    `json:"telegram_id,omitempty"`
+	UserName   string   `json:"username,omitempty"`
+	FirstName  string   `json:"first_name,omitempty"`
+	LastName   string   `json:"last_name,omitempty"`
+	Role       string   `json:"role,omitempty"`
+	Groups     []string `json:"groups,omitempty"`
+	Birthday   string   `json:"birthday,omitempty"`
+}
+
+// groupRecord is a group of exported document, it's skipped on import
+type groupRecord struct {
+	Name       string   `json:"name"`
+	Parent     string   `json:"parent,omitempty"`
+	Groupchats []string `json:"groupchats,omitempty"`
+}
+
+type document struct {
+	Users  []*record      `json:"users"`
+	Groups []*groupRecord `json:"groups,omitempty"`
+}
+
+// change is a validated record
+type change struct {
+	target string
+	diff   []difference
+	user   *database.UserImport
+
+	// created is a new user, oldRole is a role of existing user replaced by import
+	created bool
+	oldRole string
+}
+
+// difference is a translatable part of change, shown to importing user and written to audit log
+type difference struct {
+	key  string
+	args []interface{}
+}
+
+// describe joins differences of change in language
+func (c *change) describe(lang, separator string) string {
+	parts := make([]string, 0, len(c.diff))
+	for _, d := range c.diff {
+		parts = append(parts, i18n.Translate(lang, d.key, d.args...))
+	}
+
+	return strings.Join(parts, separator)
+}
+
+func init() {
+	plugins.RegisterPlugin(&Plugin{})
+}
+
+// Meta ...
+func (m *Plugin) Meta() plugins.Meta {
+	return plugins.Meta{
+		Version: "1.0",
+	}
+}
+
+func (m *Plugin) OnStart() {
+	plugins.RegisterPermission("users.import", "Import users from documents", database.Admin, database.Owner)
+	plugins.RegisterPermission("users.export", "Export users and groups", database.Admin, database.Owner)
+
+	plugins.RegisterCommand("import", "Import users from CSV/JSON document", "users.import", importUsers)
+	plugins.RegisterCommand("export", "Export users, groups and memberships (json or csv)", "users.export", exportUsers)
+}
+
+func (m *Plugin) OnStop() {
+	dlog.Debugln("[bulk.Plugin] Stopped")
+
+	plugins.UnregisterCommand("import")
+	plugins.UnregisterCommand("export")
+}
+
+var importUsers plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	switch args {
+	case "apply":
+		return applyImport(update, user)
+	case "cancel":
+		pendingImports.Delete(user.TelegramID)
+		answer(update, "")
+
+		return reply(update, user, i18n.T(user, "bulk.cancelled"), nil)
+	}
+
+	if update.Message == nil || update.Message.Document == nil {
+		plugins.AwaitInput(user.TelegramID, command, "")
+
+		return telegram.Send(user.TelegramID, i18n.T(user, "bulk.help"))
+	}
+
+	records, err := readDocument(update.Message.Document)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	changes, errs := plan(user, records)
+	if len(errs) > 0 {
+		return telegram.Send(user.TelegramID, cut(i18n.T(user, "bulk.failed", "error", "\n"+strings.Join(errs, "\n"))))
+	}
+
+	if len(changes) == 0 {
+		return telegram.Send(user.TelegramID, i18n.T(user, "bulk.no_changes"))
+	}
+
+	pendingImports.Store(user.TelegramID, records)
+
+	replyKeyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "common.apply"), "/import apply"),
+		tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "common.cancel"), "/import cancel"),
+	))
+
+	return telegram.SendCustom(user.TelegramID, 0, cut(preview(user, changes)), false, &replyKeyboard)
+}
+
+// applyImport validates pending records again, the database may have changed since preview
+func applyImport(update *tgbotapi.Update, user *database.User) error {
+	v, ok := pendingImports.Load(user.TelegramID)
+	if !ok {
+		answer(update, "")
+		return reply(update, user, i18n.T(user, "bulk.nothing"), nil)
+	}
+
+	pendingImports.Delete(user.TelegramID)
+
+	changes, errs := plan(user, v.([]*record))
+	if len(errs) > 0 {
+		answer(update, "")
+		return reply(update, user, cut(i18n.T(user, "bulk.failed", "error", "\n"+strings.Join(errs, "\n"))), nil)
+	}
+
+	imports := make([]*database.UserImport, 0, len(changes))
+	for _, c := range changes {
+		imports = append(imports, c.user)
+	}
+
+	if err := database.ImportUsers(plugins.DB, imports); err != nil {
+		answer(update, "")
+		return reply(update, user, i18n.T(user, "bulk.failed", "error", i18n.Err(user, err)), nil)
+	}
+
+	for _, c := range changes {
+		plugins.Audit(user, "import", c.target, "", c.describe(i18n.DefaultLanguage, "; "))
+		publish(user, c)
+	}
+
+	answer(update, i18n.T(user, "common.success"))
+
+	return reply(update, user, cut(i18n.T(user, "bulk.imported", "count", len(changes))+"\n\n"+preview(user, changes)), nil)
+}
+
+// publish tells subscribers about applied change as the commands making the same changes do
+func publish(actor *database.User, c *change) {
+	u := c.user.User
+
+	switch {
+	case c.created:
+		plugins.Publish(&plugins.UserRegistered{User: u, Imported: true})
+	case c.oldRole != "":
+		plugins.Publish(&plugins.RoleChanged{Actor: actor, User: u, Action: "import", OldRole: c.oldRole, NewRole: u.Role})
+	}
+
+	for _, g := range c.user.Groups {
+		plugins.Publish(&plugins.GroupMembershipChanged{Actor: actor, User: u, Group: g, Action: "import", Joined: true})
+	}
+}
+
+var exportUsers plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	format := strings.ToLower(strings.TrimSpace(args))
+	if format == "" {
+		format = "json"
+	}
+
+	if format != "json" && format != "csv" {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, i18n.NewError("bulk.format")))
+	}
+
+	doc, err := collect(format == "json")
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	var data []byte
+	if format == "json" {
+		data, err = json.MarshalIndent(doc, "", "  ")
+	} else {
+		data, err = writeCSV(doc.Users)
+	}
+	if err != nil {
+		return err
+	}
+
+	upload := tgbotapi.NewDocumentUpload(user.TelegramID, tgbotapi.FileBytes{
+		Name:  "corpobot-" + time.Now().Format("2006-01-02") + "." + format,
+		Bytes: data,
+	})
+
+	_, err = plugins.Bot.Send(upload)
+
+	return err
+}
+
+func readDocument(doc *tgbotapi.Document) ([]*record, error) {
+	if doc.FileSize > maxDocumentSize {
+		return nil, i18n.NewError("bulk.too_large")
+	}
+
+	fileURL, err := plugins.Bot.GetFileDirectURL(doc.FileID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.Get(fileURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDocumentSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(strings.ToLower(doc.FileName), ".json") || doc.MimeType == "application/json" {
+		return parseJSON(data)
+	}
+
+	return parseCSV(data)
+}
+
+func parseJSON(data []byte) ([]*record, error) {
+	var doc document
+	if err := json.Unmarshal(data, &doc); err == nil {
+		return doc.Users, nil
+	}
+
+	var records []*record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func parseCSV(data []byte) ([]*record, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.TrimLeadingSpace = true
+
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, i18n.NewError("bulk.empty")
+	}
+
+	index := make(map[string]int)
+	for i, name := range rows[0] {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !contains(columns, name) {
+			return nil, i18n.NewError("bulk.unknown_column", "column", name)
+		}
+		index[name] = i
+	}
+
+	if _, ok := index["telegram_id"]; !ok {
+		if _, ok := index["username"]; !ok {
+			return nil, i18n.NewError("bulk.id_column")
+		}
+	}
+
+	records := make([]*record, 0, len(rows)-1)
+	for n, row := range rows[1:] {
+		value := func(column string) string {
+			if i, ok := index[column]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+
+		rec := &record{
+			UserName:  value("username"),
+			FirstName: value("first_name"),
+			LastName:  value("last_name"),
+			Role:      value("role"),
+			Birthday:  value("birthday"),
+		}
+
+		if id := value("telegram_id"); id != "" {
+			if rec.TelegramID, err = strconv.ParseInt(id, 10, 64); err != nil {
+				return nil, i18n.NewError("bulk.wrong_id", "line", n+2, "id", id)
+			}
+		}
+
+		for _, g := range strings.Split(value("groups"), ";") {
+			if g = strings.TrimSpace(g); g != "" {
+				rec.Groups = append(rec.Groups, g)
+			}
+		}
+
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
+
+// plan validates records and compares them with the database, records without changes are skipped
+func plan(user *database.User, records []*record) (changes []*change, errs []string) {
+	seen := make(map[int64]bool)
+
+	for n, rec := range records {
+		c, err := planRecord(rec)
+		if err != nil {
+			errs = append(errs, i18n.T(user, "bulk.row", "row", n+1, "error", i18n.Err(user, err)))
+			continue
+		}
+
+		if seen[c.user.User.TelegramID] {
+			errs = append(errs, i18n.T(user, "bulk.row", "row", n+1, "error", i18n.NewError("bulk.duplicate", "user", c.target)))
+			continue
+		}
+		seen[c.user.User.TelegramID] = true
+
+		if len(c.diff) > 0 {
+			changes = append(changes, c)
+		}
+	}
+
+	return changes, errs
+}
+
+func planRecord(rec *record) (*change, error) {
+	var existing *database.User
+	var err error
+
+	switch {
+	case rec.TelegramID != 0:
+		existing, err = database.GetUserByTelegramID(plugins.DB, &database.User{TelegramID: rec.TelegramID})
+	case rec.UserName != "":
+		existing, err = telegram.ResolveUser(nil, "@"+strings.TrimPrefix(rec.UserName, "@"))
+		if err != nil {
+			return nil, i18n.NewError("bulk.id_required", "error", err)
+		}
+	default:
+		return nil, i18n.NewError("bulk.id_or_username")
+	}
+	if err != nil && err.Error() != database.UserNotFound {
+		return nil, err
+	}
+
+	u := &database.User{
+		TelegramID: rec.TelegramID,
+		UserName:   strings.TrimPrefix(rec.UserName, "@"),
+		FirstName:  rec.FirstName,
+		LastName:   rec.LastName,
+		Role:       database.Member,
+	}
+	if existing != nil {
+		copied := *existing
+		u = &copied
+	}
+
+	c := &change{user: &database.UserImport{User: u}}
+
+	if rec.Role != "" && rec.Role != u.Role {
+		if u.Role == database.Owner {
+			return nil, i18n.NewError("bulk.owner_changed")
+		}
+
+		role, err := database.GetRole(plugins.DB, rec.Role)
+		if err != nil {
+			return nil, i18n.NewError("bulk.value_error", "error", err, "value", rec.Role)
+		}
+
+		if role.Name == database.Owner {
+			return nil, i18n.NewError("bulk.owner_imported")
+		}
+
+		if existing != nil {
+			c.diff = append(c.diff, difference{"bulk.diff_role", []interface{}{"old", u.Role, "new", role.Name}})
+			c.oldRole = u.Role
+		}
+		u.Role = role.Name
+	}
+
+	if existing == nil {
+		c.diff = append(c.diff, difference{"bulk.diff_new", []interface{}{"role", u.Role}})
+		c.created = true
+	}
+
+	if rec.Birthday != "" {
+		birthday, err := parseBirthday(rec.Birthday)
+		if err != nil {
+			return nil, i18n.NewError("bulk.wrong_birthday", "birthday", rec.Birthday)
+		}
+
+		if !birthday.Equal(u.Birthday) {
+			c.diff = append(c.diff, difference{"bulk.diff_birthday", []interface{}{"date", birthday.Format("2006-01-02")}})
+			u.Birthday = birthday
+		} else {
+			u.Birthday = time.Time{}
+		}
+	} else {
+		u.Birthday = time.Time{}
+	}
+
+	inGroup := make(map[int64]bool)
+	if existing != nil {
+		groups, err := database.GetGroupsByUserID(plugins.DB, existing.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, g := range groups {
+			inGroup[g.ID] = true
+		}
+	}
+
+	for _, name := range rec.Groups {
+		g, err := database.GetGroupByName(plugins.DB, &database.Group{Name: name})
+		if err != nil {
+			return nil, i18n.NewError("bulk.value_error", "error", err, "value", name)
+		}
+
+		if g.State != database.Active {
+			return nil, i18n.NewError("bulk.group_state", "group", name, "state", g.State)
+		}
+
+		if inGroup[g.ID] {
+			continue
+		}
+		inGroup[g.ID] = true
+
+		c.user.Groups = append(c.user.Groups, g)
+		c.diff = append(c.diff, difference{"bulk.diff_group", []interface{}{"group", g.Name}})
+	}
+
+	c.target = u.String()
+
+	return c, nil
+}
+
+func parseBirthday(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+
+	return time.Parse("2006.01.02", s)
+}
+
+func preview(user *database.User, changes []*change) string {
+	var b strings.Builder
+
+	for _, c := range changes {
+		b.WriteString("• " + c.target + ": " + c.describe(i18n.Lang(user), ", ") + "\n")
+	}
+
+	return b.String()
+}
+
+// collect reads users with their groups, and groups themselves if needed
+func collect(withGroups bool) (*document, error) {
+	doc := &document{}
+
+	users, err := database.GetUsers(plugins.DB, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, u := range users {
+		rec := &record{
+			TelegramID: u.TelegramID,
+			UserName:   u.UserName,
+			FirstName:  u.FirstName,
+			LastName:   u.LastName,
+			Role:       u.Role,
+		}
+
+		if !u.Birthday.IsZero() {
+			rec.Birthday = u.Birthday.Format("2006-01-02")
+		}
+
+		groups, err := database.GetGroupsByUserID(plugins.DB, u.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, g := range groups {
+			if g.State == database.Active {
+				rec.Groups = append(rec.Groups, g.Name)
+			}
+		}
+
+		doc.Users = append(doc.Users, rec)
+	}
+
+	if !withGroups {
+		return doc, nil
+	}
+
+	groups, err := database.GetGroups(plugins.DB, []string{database.Active})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, g := range groups {
+		rec := &groupRecord{Name: g.Name}
+
+		if parent, err := database.GetGroupParent(plugins.DB, g); err == nil {
+			rec.Parent = parent.Name
+		}
+
+		groupchats, err := database.GetGroupchatsByGroupID(plugins.DB, g.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range groupchats {
+			rec.Groupchats = append(rec.Groupchats, c.Title)
+		}
+
+		doc.Groups = append(doc.Groups, rec)
+	}
+
+	return doc, nil
+}
+
+func writeCSV(records []*record) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write(columns); err != nil {
+		return nil, err
+	}
+
+	for _, rec := range records {
+		row := []string{
+			strconv.FormatInt(rec.TelegramID, 10),
+			rec.UserName,
+			rec.FirstName,
+			rec.LastName,
+			rec.Role,
+			strings.Join(rec.Groups, ";"),
+			rec.Birthday,
+		}
+
+		if err := w.Write(row); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+
+	return buf.Bytes(), w.Error()
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func cut(text string) string {
+	if len(text) > maxMessageLength {
+		return text[:maxMessageLength] + "\n…"
+	}
+
+	return text
+}
+
+func answer(update *tgbotapi.Update, text string) {
+	if update.CallbackQuery == nil {
+		return
+	}
+
+	_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, text))
+	if err != nil {
+		dlog.Errorln(err.Error())
+	}
+}
+
+// reply edits message with buttons on callback, otherwise sends a new one
+func reply(update *tgbotapi.Update, user *database.User, text string, replyKeyboard *tgbotapi.InlineKeyboardMarkup) error {
+	if update.CallbackQuery == nil {
+		return telegram.SendCustom(user.TelegramID, 0, text, false, replyKeyboard)
+	}
+
+	edit := tgbotapi.NewEditMessageText(update.CallbackQuery.Message.Chat.ID, update.CallbackQuery.Message.MessageID, text)
+	edit.ReplyMarkup = replyKeyboard
+
+	_, err := plugins.Bot.Send(edit)
+
+	return err
+}
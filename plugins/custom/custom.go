@@ -0,0 +1,396 @@
+package custom
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
+	"github.com/ad/corpobot/plugins"
+	"github.com/ad/corpobot/telegram"
+
+	dlog "github.com/amoghe/distillog"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+type Plugin struct{}
+
+const name = "custom.Plugin"
+
+var (
+	nameRe   = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+	headerRe = regexp.MustCompile(`^(description|roles|markdown|button):\s*(.*)$`)
+)
+
+func init() {
+	plugins.RegisterPlugin(&Plugin{})
+}
+
+// Meta ...
+func (m *Plugin) Meta() plugins.Meta {
+	return plugins.Meta{
+		Version: "1.0",
+	}
+}
+
+func (m *Plugin) OnStart() {
+	plugins.RegisterPermission("commands.manage", "Define custom commands", database.Admin, database.Owner)
+	plugins.RegisterPermission("commands.use", "Use custom commands without roles", database.Member, database.Admin, database.Owner)
+
+	plugins.RegisterCommand("commandlist", "List custom commands", "commands.manage", commandList)
+	plugins.RegisterCommand("commandshow", "Show custom command", "commands.manage", commandShow)
+	plugins.RegisterCommand("commandset", "Create or change custom command", "commands.manage", commandSet)
+	plugins.RegisterCommand("commanddelete", "Delete custom command", "commands.manage", commandDelete)
+
+	commands, err := database.GetCustomCommands(plugins.DB)
+	if err != nil {
+		dlog.Errorln("failed: " + err.Error())
+		return
+	}
+
+	// a custom command conflicting with a command of another plugin is skipped, not the whole plugin
+	for _, c := range commands {
+		if err := register(c); err != nil {
+			dlog.Errorln("[" + name + "] " + err.Error())
+		}
+	}
+}
+
+func (m *Plugin) OnStop() {
+	dlog.Debugln("[custom.Plugin] Stopped")
+
+	plugins.Commands.Range(func(k, v interface{}) bool {
+		if v.(plugins.Command).Plugin == name {
+			plugins.UnregisterPluginCommand(name, k.(string))
+		}
+		return true
+	})
+}
+
+// register makes custom command available, the definition is read on each call, so a changed command needs no
+// registration unless its roles or description change
+func register(c *database.CustomCommand) error {
+	return plugins.RegisterPluginCommand(name, c.Name, c.Description, commandPermission(c), run)
+}
+
+// commandPermission of a command with roles is its own, a command without roles is for members, not for new users
+func commandPermission(c *database.CustomCommand) string {
+	if c.Roles == "" {
+		return "commands.use"
+	}
+
+	return "custom." + c.Name
+}
+
+var run plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	c, err := database.GetCustomCommand(plugins.DB, command)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	if update.CallbackQuery != nil {
+		_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, ""))
+		if err != nil {
+			dlog.Errorln(err.Error())
+		}
+	}
+
+	return telegram.SendCustom(user.TelegramID, 0, render(c, user, args), c.Markdown, buttons(c))
+}
+
+// render fills variables of template: {first_name}, {last_name}, {username}, {name}, {id}, {role}, {args}, {date}
+func render(c *database.CustomCommand, user *database.User, args string) string {
+	fullName := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	if fullName == "" {
+		fullName = user.UserName
+	}
+
+	values := []string{
+		"{first_name}", user.FirstName,
+		"{last_name}", user.LastName,
+		"{username}", user.UserName,
+		"{name}", fullName,
+		"{id}", strconv.FormatInt(user.TelegramID, 10),
+		"{role}", user.Role,
+		"{args}", args,
+		"{date}", time.Now().Format("2006-01-02"),
+	}
+
+	// values are not markup
+	if c.Markdown {
+		for i := 1; i < len(values); i += 2 {
+			values[i] = markdownEscaper.Replace(values[i])
+		}
+	}
+
+	return strings.NewReplacer(values...).Replace(c.Template)
+}
+
+var markdownEscaper = strings.NewReplacer("_", `\_`, "*", `\*`, "`", "\\`", "[", `\[`)
+
+func buttons(c *database.CustomCommand) *tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	for _, line := range strings.Split(c.Buttons, "\n") {
+		if text, url, ok := parseButton(line); ok {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonURL(text, url)))
+		}
+	}
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	replyKeyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+	return &replyKeyboard
+}
+
+// parseButton parses "text | url"
+func parseButton(line string) (text, url string, ok bool) {
+	parts := strings.SplitN(line, "|", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+
+	text, url = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	if text == "" || !(strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "tg://")) {
+		return "", "", false
+	}
+
+	return text, url, true
+}
+
+var commandList plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	commands, err := database.GetCustomCommands(plugins.DB)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	if len(commands) == 0 {
+		return telegram.Send(user.TelegramID, i18n.T(user, "custom.empty"))
+	}
+
+	var b strings.Builder
+	for _, c := range commands {
+		b.WriteString("* " + c.String())
+		if c.Description != "" {
+			b.WriteString(" - " + c.Description)
+		}
+		if c.Roles != "" {
+			b.WriteString(" [" + c.Roles + "]")
+		}
+		if v, ok := plugins.Commands.Load(c.Name); !ok || v.(plugins.Command).Plugin != name {
+			b.WriteString(" " + i18n.T(user, "custom.not_registered"))
+		}
+		b.WriteString("\n")
+	}
+
+	return telegram.Send(user.TelegramID, b.String())
+}
+
+var commandShow plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	c, err := database.GetCustomCommand(plugins.DB, strings.TrimPrefix(strings.TrimSpace(args), "/"))
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	return telegram.Send(user.TelegramID, "/commandset "+definition(c))
+}
+
+var commandSet plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	c, err := parse(args)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err)+"\n\n"+i18n.T(user, "custom.usage"))
+	}
+
+	for _, role := range splitRoles(c.Roles) {
+		if _, err = database.GetRole(plugins.DB, role); err != nil {
+			return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+		}
+	}
+
+	before := ""
+	old, err := database.GetCustomCommand(plugins.DB, c.Name)
+	if err == nil {
+		before = definition(old)
+	}
+
+	// a command of another plugin is not replaced
+	if err = register(c); err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	c.CreatedBy = user.TelegramID
+	if err = database.SetCustomCommand(plugins.DB, c); err != nil {
+		if old == nil {
+			plugins.UnregisterPluginCommand(name, c.Name)
+		}
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	if err = grantRoles(c); err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	plugins.Audit(user, command, c.Name, before, definition(c))
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "custom.saved", "command", c.String()))
+}
+
+var commandDelete plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	c, err := database.GetCustomCommand(plugins.DB, strings.TrimPrefix(strings.TrimSpace(args), "/"))
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	if err = database.DeleteCustomCommand(plugins.DB, c.Name); err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	plugins.UnregisterPluginCommand(name, c.Name)
+
+	if err = database.DeletePermission(plugins.DB, "custom."+c.Name); err != nil {
+		dlog.Errorln(err.Error())
+	}
+
+	plugins.Audit(user, command, c.Name, definition(c), "")
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "common.success"))
+}
+
+// parse reads a definition: name on the first line, then optional "description:", "roles:", "markdown:" and
+// "button:" lines and the template after them
+func parse(args string) (*database.CustomCommand, error) {
+	lines := strings.Split(strings.TrimSpace(args), "\n")
+
+	c := &database.CustomCommand{Name: strings.ToLower(strings.TrimPrefix(strings.TrimSpace(lines[0]), "/"))}
+	if !nameRe.MatchString(c.Name) {
+		return nil, i18n.NewError("custom.wrong_name", "command", c.Name)
+	}
+
+	var buttons []string
+
+	i := 1
+	for ; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			i++
+			break
+		}
+
+		m := headerRe.FindStringSubmatch(line)
+		if m == nil {
+			break
+		}
+
+		switch m[1] {
+		case "description":
+			c.Description = m[2]
+		case "roles":
+			c.Roles = strings.Join(splitRoles(m[2]), ",")
+		case "markdown":
+			markdown, err := strconv.ParseBool(m[2])
+			if err != nil {
+				return nil, i18n.NewError("settings.wrong_value", "value", m[2], "type", "bool")
+			}
+			c.Markdown = markdown
+		case "button":
+			if _, _, ok := parseButton(m[2]); !ok {
+				return nil, i18n.NewError("custom.wrong_button", "button", m[2])
+			}
+			buttons = append(buttons, m[2])
+		}
+	}
+
+	c.Buttons = strings.Join(buttons, "\n")
+	c.Template = strings.TrimSpace(strings.Join(lines[i:], "\n"))
+	if c.Template == "" {
+		return nil, i18n.NewError("custom.empty_template")
+	}
+
+	return c, nil
+}
+
+// definition is a command in the format of /commandset
+func definition(c *database.CustomCommand) string {
+	var b strings.Builder
+	b.WriteString(c.Name + "\n")
+
+	if c.Description != "" {
+		b.WriteString("description: " + c.Description + "\n")
+	}
+	if c.Roles != "" {
+		b.WriteString("roles: " + c.Roles + "\n")
+	}
+	if c.Markdown {
+		b.WriteString("markdown: true\n")
+	}
+	for _, button := range strings.Split(c.Buttons, "\n") {
+		if button != "" {
+			b.WriteString("button: " + button + "\n")
+		}
+	}
+
+	b.WriteString("\n" + c.Template)
+
+	return b.String()
+}
+
+func splitRoles(roles string) []string {
+	var result []string
+	for _, role := range strings.Split(roles, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			result = append(result, role)
+		}
+	}
+
+	return result
+}
+
+// grantRoles makes permission of command granted to its roles only, the permission of a command which lost its roles
+// is deleted
+func grantRoles(c *database.CustomCommand) error {
+	roles := splitRoles(c.Roles)
+	if len(roles) == 0 {
+		return database.DeletePermission(plugins.DB, "custom."+c.Name)
+	}
+
+	permission := commandPermission(c)
+	plugins.RegisterPermission(permission, "Use /"+c.Name)
+
+	all, err := database.GetRoles(plugins.DB)
+	if err != nil {
+		return err
+	}
+
+	for _, role := range all {
+		if role.Name == database.Owner {
+			continue
+		}
+
+		wanted := false
+		for _, r := range roles {
+			wanted = wanted || r == role.Name
+		}
+
+		granted, err := database.HasPermission(plugins.DB, role.Name, permission)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case wanted && !granted:
+			_, err = database.GrantPermission(plugins.DB, role.Name, permission)
+		case !wanted && granted:
+			_, err = database.RevokePermission(plugins.DB, role.Name, permission)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -15,12 +15,17 @@ func init() {
 	plugins.RegisterPlugin(&Plugin{})
 }
 
-func (m *Plugin) OnStart() {
-	if !plugins.CheckIfPluginDisabled("echo.Plugin", "enabled") {
-		return
+// Meta ...
+func (m *Plugin) Meta() plugins.Meta {
+	return plugins.Meta{
+		Version: "1.0",
 	}
+}
+
+func (m *Plugin) OnStart() {
+	plugins.RegisterPermission("echo.use", "Use echo example", database.New, database.Member, database.Admin, database.Owner)
 
-	plugins.RegisterCommand("echo", "example plugin", []string{database.New, database.Member, database.Admin, database.Owner}, echo)
+	plugins.RegisterCommand("echo", "example plugin", "echo.use", echo)
 }
 
 func (m *Plugin) OnStop() {
@@ -0,0 +1,281 @@
+package plugins
+
+import (
+	"fmt"
+	"sync"
+
+	database "github.com/ad/corpobot/db"
+
+	dlog "github.com/amoghe/distillog"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// Event is published by core code and plugins and delivered to subscribers
+type Event interface {
+	// EventName tells events apart, subscribers choose events by it
+	EventName() string
+	// Fields are plain values of event, e.g. for scripts
+	Fields() map[string]interface{}
+}
+
+// EventHandler gets published events, a handler is called by publisher, so a long work must go to a goroutine
+type EventHandler func(e Event)
+
+// Names of events
+const (
+	UserRegisteredEvent         = "user_registered"
+	RoleChangedEvent            = "role_changed"
+	GroupMembershipChangedEvent = "group_membership_changed"
+	GroupchatAddedEvent         = "groupchat_added"
+	BotRemovedFromChatEvent     = "bot_removed_from_chat"
+	AuditEvent                  = "audit"
+	CommandsChangedEvent        = "commands_changed"
+
+	// AllEvents subscribes to every event
+	AllEvents = "*"
+)
+
+// UserRegistered is published when user writes to bot for the first time or is created by import
+type UserRegistered struct {
+	User *database.User
+	// Applying user is asked to apply for registration, admins are told about it by the registration plugin
+	Applying bool
+	// Imported user is created by bulk import and known to the admin who imported it
+	Imported bool
+}
+
+// RoleChanged is published when user gets another role, action is a command or another reason as in audit log
+type RoleChanged struct {
+	Actor   *database.User
+	User    *database.User
+	Action  string
+	OldRole string
+	NewRole string
+}
+
+// GroupMembershipChanged is published when user joins or leaves group
+type GroupMembershipChanged struct {
+	Actor  *database.User
+	User   *database.User
+	Group  *database.Group
+	Action string
+	Joined bool
+}
+
+// GroupchatAdded is published when bot is added to groupchat, by is a user who added it and may be nil
+type GroupchatAdded struct {
+	Groupchat *database.Groupchat
+	By        *tgbotapi.User
+	// Returned is true when bot was added back to a known groupchat
+	Returned bool
+}
+
+// BotRemovedFromChat is published when bot leaves or is kicked from groupchat, by may be nil
+type BotRemovedFromChat struct {
+	Groupchat *database.Groupchat
+	By        *tgbotapi.User
+}
+
+// AuditRecorded is published after an administrative action is written to audit log
+type AuditRecorded struct {
+	Entry *database.AuditEntry
+}
+
+// CommandsChanged is published when command is registered or unregistered
+type CommandsChanged struct {
+	Command    string
+	Registered bool
+}
+
+// EventName ...
+func (e *UserRegistered) EventName() string { return UserRegisteredEvent }
+
+// EventName ...
+func (e *RoleChanged) EventName() string { return RoleChangedEvent }
+
+// EventName ...
+func (e *GroupMembershipChanged) EventName() string { return GroupMembershipChangedEvent }
+
+// EventName ...
+func (e *GroupchatAdded) EventName() string { return GroupchatAddedEvent }
+
+// EventName ...
+func (e *BotRemovedFromChat) EventName() string { return BotRemovedFromChatEvent }
+
+// EventName ...
+func (e *AuditRecorded) EventName() string { return AuditEvent }
+
+// EventName ...
+func (e *CommandsChanged) EventName() string { return CommandsChangedEvent }
+
+// Fields ...
+func (e *UserRegistered) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"user":     userFields(e.User),
+		"applying": e.Applying,
+		"imported": e.Imported,
+	}
+}
+
+// Fields ...
+func (e *RoleChanged) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"actor":    userFields(e.Actor),
+		"user":     userFields(e.User),
+		"action":   e.Action,
+		"old_role": e.OldRole,
+		"new_role": e.NewRole,
+	}
+}
+
+// Fields ...
+func (e *GroupMembershipChanged) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"actor":  userFields(e.Actor),
+		"user":   userFields(e.User),
+		"group":  e.Group.Name,
+		"action": e.Action,
+		"joined": e.Joined,
+	}
+}
+
+// Fields ...
+func (e *GroupchatAdded) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"groupchat": groupchatFields(e.Groupchat),
+		"by":        telegramUserFields(e.By),
+		"returned":  e.Returned,
+	}
+}
+
+// Fields ...
+func (e *BotRemovedFromChat) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"groupchat": groupchatFields(e.Groupchat),
+		"by":        telegramUserFields(e.By),
+	}
+}
+
+// Fields ...
+func (e *AuditRecorded) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"actor_id": e.Entry.ActorID,
+		"actor":    e.Entry.Actor,
+		"action":   e.Entry.Action,
+		"target":   e.Entry.Target,
+		"before":   e.Entry.Before,
+		"after":    e.Entry.After,
+	}
+}
+
+// Fields ...
+func (e *CommandsChanged) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"command":    e.Command,
+		"registered": e.Registered,
+	}
+}
+
+func userFields(u *database.User) map[string]interface{} {
+	if u == nil {
+		return nil
+	}
+
+	return map[string]interface{}{
+		"id":          u.ID,
+		"telegram_id": u.TelegramID,
+		"user_name":   u.UserName,
+		"first_name":  u.FirstName,
+		"last_name":   u.LastName,
+		"role":        u.Role,
+		"name":        u.String(),
+	}
+}
+
+func telegramUserFields(u *tgbotapi.User) map[string]interface{} {
+	if u == nil {
+		return nil
+	}
+
+	return map[string]interface{}{
+		"telegram_id": int64(u.ID),
+		"user_name":   u.UserName,
+		"first_name":  u.FirstName,
+		"last_name":   u.LastName,
+		"name":        u.String(),
+	}
+}
+
+func groupchatFields(gc *database.Groupchat) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          gc.ID,
+		"telegram_id": gc.TelegramID,
+		"title":       gc.Title,
+	}
+}
+
+type subscription struct {
+	plugin  string
+	event   string
+	handler EventHandler
+}
+
+var (
+	subscriptionsMu sync.RWMutex
+	subscriptions   []subscription
+)
+
+// Subscribe calls handler for events with the name or for all events, a subscription made in OnStart belongs to the
+// starting plugin and is removed when the plugin stops
+func Subscribe(event string, handler EventHandler) {
+	subscriptionsMu.Lock()
+	defer subscriptionsMu.Unlock()
+
+	subscriptions = append(subscriptions, subscription{plugin: current, event: event, handler: handler})
+}
+
+// Unsubscribe removes subscriptions of plugin
+func Unsubscribe(plugin string) {
+	subscriptionsMu.Lock()
+	defer subscriptionsMu.Unlock()
+
+	kept := subscriptions[:0]
+	for _, s := range subscriptions {
+		if s.plugin != plugin {
+			kept = append(kept, s)
+		}
+	}
+
+	// forget removed handlers
+	for i := len(kept); i < len(subscriptions); i++ {
+		subscriptions[i] = subscription{}
+	}
+
+	subscriptions = kept
+}
+
+// Publish calls handlers of event in the order of subscription, a panicking handler is logged and skipped
+func Publish(e Event) {
+	subscriptionsMu.RLock()
+	var matching []subscription
+	for _, s := range subscriptions {
+		if s.event == e.EventName() || s.event == AllEvents {
+			matching = append(matching, s)
+		}
+	}
+	subscriptionsMu.RUnlock()
+
+	for _, s := range matching {
+		deliver(s, e)
+	}
+}
+
+func deliver(s subscription, e Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			dlog.Errorf("[%s] %s handler failed: %s", s.plugin, e.EventName(), fmt.Sprint(r))
+		}
+	}()
+
+	s.handler(e)
+}
@@ -0,0 +1,324 @@
+package expiry
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
+	"github.com/ad/corpobot/plugins"
+	"github.com/ad/corpobot/telegram"
+
+	dlog "github.com/amoghe/distillog"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+type Plugin struct{}
+
+const name = "expiry.Plugin"
+
+var (
+	stopExpiryCheck chan struct{}
+	checkMu         sync.Mutex
+)
+
+func init() {
+	plugins.RegisterPlugin(&Plugin{})
+}
+
+// Meta ...
+func (m *Plugin) Meta() plugins.Meta {
+	return plugins.Meta{
+		Version:      "1.0",
+		Dependencies: []string{"groups.Plugin"},
+		// defaults come from config, settings may be changed while the plugin is disabled
+		Settings: []plugins.Setting{
+			{
+				Name:        "check_interval",
+				Type:        plugins.DurationSetting,
+				Default:     (time.Duration(plugins.Config.ExpiryCheckInterval) * time.Minute).String(),
+				Description: "How often expiries are checked, 0 disables the check",
+			},
+			{
+				Name:        "reminder_days",
+				Type:        plugins.IntSetting,
+				Default:     strconv.Itoa(plugins.Config.ExpiryReminderDays),
+				Description: "Days before expiry to remind user and approvers",
+			},
+			{
+				Name:        "extend_days",
+				Type:        plugins.IntSetting,
+				Default:     strconv.Itoa(plugins.Config.ExpiryExtendDays),
+				Description: "Days added by the extend button of reminder",
+			},
+		},
+	}
+}
+
+func (m *Plugin) OnStart() {
+	startCheck()
+}
+
+func (m *Plugin) OnStop() {
+	dlog.Debugln("[expiry.Plugin] Stopped")
+
+	stopCheck()
+}
+
+// OnSettingChange restarts the check with a new interval
+func (m *Plugin) OnSettingChange(setting, value string) {
+	if setting == "check_interval" {
+		stopCheck()
+		startCheck()
+	}
+}
+
+func startCheck() {
+	checkMu.Lock()
+	defer checkMu.Unlock()
+
+	stopExpiryCheck = make(chan struct{})
+	go expiryCheck(stopExpiryCheck)
+}
+
+func stopCheck() {
+	checkMu.Lock()
+	defer checkMu.Unlock()
+
+	if stopExpiryCheck != nil {
+		close(stopExpiryCheck)
+		stopExpiryCheck = nil
+	}
+}
+
+// expiryCheck periodically revokes expired group memberships and roles and reminds about the ones expiring soon
+func expiryCheck(stop chan struct{}) {
+	interval := plugins.SettingDuration(name, "check_interval")
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if plugins.Bot == nil {
+				continue
+			}
+			checkExpiries()
+		}
+	}
+}
+
+func checkExpiries() {
+	now := time.Now()
+
+	expiries, err := database.GetExpiries(plugins.DB, now.AddDate(0, 0, plugins.SettingInt(name, "reminder_days")))
+	if err != nil {
+		dlog.Errorln(err)
+		return
+	}
+
+	for _, e := range expiries {
+		switch {
+		case !e.ExpiresAt.After(now) && e.IsRole():
+			expireRole(e)
+		case !e.ExpiresAt.After(now):
+			expireMembership(e)
+		case !e.Reminded:
+			remind(e)
+		}
+	}
+}
+
+func expireMembership(e *database.Expiry) {
+	group, err := database.GetGroupByID(plugins.DB, &database.Group{ID: e.GroupID})
+	if err != nil {
+		dlog.Errorln(err)
+		return
+	}
+
+	user, err := database.GetUserByTelegramID(plugins.DB, &database.User{TelegramID: e.TelegramID})
+	if err != nil {
+		dlog.Errorln(err)
+		return
+	}
+
+	before, err := database.GetEffectiveGroupchatsByUserID(plugins.DB, user.ID)
+	if err != nil {
+		dlog.Errorln(err)
+		return
+	}
+
+	if _, err = database.DeleteGroupUser(plugins.DB, group, user); err != nil {
+		dlog.Errorln(err)
+		return
+	}
+
+	after, err := database.GetEffectiveGroupchatsByUserID(plugins.DB, user.ID)
+	if err != nil {
+		dlog.Errorln(err)
+		return
+	}
+
+	stays := make(map[int64]bool)
+	for _, c := range after {
+		stays[c.ID] = true
+	}
+
+	for _, c := range before {
+		if stays[c.ID] {
+			continue
+		}
+
+		if err := telegram.KickChatMember(c, user.TelegramID); err != nil {
+			dlog.Errorf("kick [%d] from %s failed: %s", user.TelegramID, c.Title, err)
+		}
+	}
+
+	plugins.Audit(plugins.SystemUser, "groupuserexpired", user.String()+" in "+group.Name, e.Date(), "")
+	plugins.Publish(&plugins.GroupMembershipChanged{Actor: plugins.SystemUser, User: user, Group: group, Action: "groupuserexpired", Joined: false})
+
+	notify(user, nil, "expiry.access_expired", "group", group.Name)
+
+	for _, approver := range groupApprovers(group) {
+		notify(approver, nil, "expiry.membership_expired", "user", user.String(), "group", group.Name)
+	}
+}
+
+func expireRole(e *database.Expiry) {
+	user, err := database.GetUserByTelegramID(plugins.DB, &database.User{TelegramID: e.TelegramID})
+	if err != nil {
+		dlog.Errorln(err)
+		return
+	}
+
+	// role was changed after expiry had been set, nothing to take back
+	if user.Role != e.Role {
+		if err = database.DeleteExpiry(plugins.DB, e); err != nil {
+			dlog.Errorln(err)
+		}
+		return
+	}
+
+	fallback := database.FallbackRole(e.Role)
+
+	if _, err = database.UpdateUserRole(plugins.DB, &database.User{TelegramID: user.TelegramID, Role: fallback}); err != nil {
+		dlog.Errorln(err)
+		return
+	}
+
+	if err = database.DeleteExpiry(plugins.DB, e); err != nil {
+		dlog.Errorln(err)
+	}
+
+	plugins.Audit(plugins.SystemUser, "userroleexpired", user.String(), e.Role, fallback)
+
+	changed := *user
+	changed.Role = fallback
+	plugins.Publish(&plugins.RoleChanged{Actor: plugins.SystemUser, User: &changed, Action: "userroleexpired", OldRole: e.Role, NewRole: fallback})
+
+	notify(user, nil, "expiry.your_role_expired", "role", e.Role, "fallback", fallback)
+
+	approvers, err := database.GetUsersByPermission(plugins.DB, "users.promote")
+	if err != nil {
+		dlog.Errorln(err)
+	}
+
+	for _, approver := range approvers {
+		notify(approver, nil, "expiry.role_expired", "role", e.Role, "user", user.String(), "fallback", fallback)
+	}
+}
+
+func remind(e *database.Expiry) {
+	user, err := database.GetUserByTelegramID(plugins.DB, &database.User{TelegramID: e.TelegramID})
+	if err != nil {
+		dlog.Errorln(err)
+		return
+	}
+
+	days := plugins.SettingInt(name, "extend_days")
+	telegramID := strconv.FormatInt(user.TelegramID, 10)
+
+	var notice, text, extend string
+	var args []interface{}
+	var approvers []*database.User
+
+	if e.IsRole() {
+		if user.Role != e.Role {
+			return
+		}
+
+		notice, text = "expiry.your_role_reminder", "expiry.role_reminder"
+		args = []interface{}{"role", e.Role, "user", user.String(), "date", e.Date()}
+		extend = "/userroleexpire " + telegramID + " +" + strconv.Itoa(days)
+
+		approvers, err = database.GetUsersByPermission(plugins.DB, "users.promote")
+		if err != nil {
+			dlog.Errorln(err)
+		}
+	} else {
+		group, err := database.GetGroupByID(plugins.DB, &database.Group{ID: e.GroupID})
+		if err != nil {
+			dlog.Errorln(err)
+			return
+		}
+
+		notice, text = "expiry.access_reminder", "expiry.membership_reminder"
+		args = []interface{}{"group", group.Name, "user", user.String(), "date", e.Date()}
+		extend = "/groupuserexpire #" + strconv.FormatInt(group.ID, 10) + "\n" + telegramID + " +" + strconv.Itoa(days)
+
+		approvers = groupApprovers(group)
+	}
+
+	if err = database.MarkExpiryReminded(plugins.DB, e); err != nil {
+		dlog.Errorln(err)
+		return
+	}
+
+	notify(user, nil, notice, args...)
+
+	for _, approver := range approvers {
+		replyKeyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(i18n.T(approver, "expiry.extend", "count", days), extend),
+		))
+
+		notify(approver, &replyKeyboard, text, args...)
+	}
+}
+
+// groupApprovers are managers of group and its ancestors and users who edit groups
+func groupApprovers(group *database.Group) []*database.User {
+	managers, err := database.GetInheritedManagersByGroupID(plugins.DB, group.ID)
+	if err != nil {
+		dlog.Errorln(err)
+	}
+
+	editors, err := database.GetUsersByPermission(plugins.DB, "groups.edit")
+	if err != nil {
+		dlog.Errorln(err)
+	}
+
+	seen := make(map[int64]bool)
+	approvers := make([]*database.User, 0, len(managers)+len(editors))
+	for _, u := range append(managers, editors...) {
+		if !seen[u.TelegramID] {
+			seen[u.TelegramID] = true
+			approvers = append(approvers, u)
+		}
+	}
+
+	return approvers
+}
+
+// notify sends message of key in the language of recipient
+func notify(to *database.User, replyKeyboard *tgbotapi.InlineKeyboardMarkup, key string, args ...interface{}) {
+	if err := telegram.SendCustom(to.TelegramID, 0, i18n.T(to, key, args...), false, replyKeyboard); err != nil {
+		dlog.Errorln(err)
+	}
+}
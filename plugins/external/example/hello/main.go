@@ -0,0 +1,88 @@
+// Hello is an example of external plugin: /hello greets you, /hello @username greets another user of the bot.
+//
+// Build it and pass the executable to the bot: -external_plugins=/path/to/hello
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ad/corpobot/plugins/external/rpc"
+)
+
+type user struct {
+	TelegramID int64  `json:"telegram_id"`
+	UserName   string `json:"user_name"`
+	FirstName  string `json:"first_name"`
+	Language   string `json:"language"`
+}
+
+type command struct {
+	Command string `json:"command"`
+	Args    string `json:"args"`
+	ChatID  int64  `json:"chat_id"`
+	User    user   `json:"user"`
+}
+
+var conn *rpc.Conn
+
+func main() {
+	// stdout is the protocol, logs go to stderr and then to the bot log
+	log.SetOutput(os.Stderr)
+	log.SetFlags(0)
+
+	conn = rpc.NewConn(os.Stdin, os.Stdout, handle)
+	<-conn.Done()
+}
+
+func handle(method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "initialize":
+		return map[string]interface{}{
+			"name":    "hello",
+			"version": "1.0",
+			"commands": []map[string]interface{}{
+				{"name": "hello", "description": "Say hello", "roles": []string{"member", "admin", "owner"}},
+			},
+		}, nil
+	case "command":
+		var cmd command
+		if err := json.Unmarshal(params, &cmd); err != nil {
+			return nil, &rpc.Error{Code: rpc.InvalidParams, Message: err.Error()}
+		}
+
+		return nil, hello(cmd)
+	case "shutdown":
+		log.Println("bye")
+		os.Exit(0)
+	}
+
+	return nil, &rpc.Error{Code: rpc.MethodNotFound, Message: "method not found: " + method}
+}
+
+func hello(cmd command) error {
+	to, greeting := cmd.ChatID, greet(cmd.User.Language, cmd.User.FirstName)
+
+	if ref := strings.TrimSpace(cmd.Args); ref != "" {
+		var target user
+		if err := conn.Call("get_user", map[string]string{"ref": ref}, &target, 10*time.Second); err != nil {
+			return err
+		}
+
+		to, greeting = target.TelegramID, greet(target.Language, target.FirstName)+fmt.Sprintf(" (from @%s)", cmd.User.UserName)
+	}
+
+	return conn.Call("send_message", map[string]interface{}{"chat_id": to, "text": greeting}, nil, 10*time.Second)
+}
+
+func greet(language, name string) string {
+	if language == "ru" {
+		return "Привет, " + name + "!"
+	}
+
+	return "Hello, " + name + "!"
+}
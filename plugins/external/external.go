@@ -0,0 +1,422 @@
+package external
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
+	"github.com/ad/corpobot/plugins"
+	"github.com/ad/corpobot/plugins/external/rpc"
+	"github.com/ad/corpobot/telegram"
+
+	dlog "github.com/amoghe/distillog"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// ProtocolVersion is sent in "initialize", it changes only with incompatible changes of the methods.
+//
+// Methods of plugin:
+//
+//	initialize {"protocol": 1} -> {"name", "version", "commands": [{"name", "description", "roles": ["member"]}]}
+//	command {"command", "args", "chat_id", "message_id", "user": User} -> null, an error is shown to user, commands
+//	    run concurrently and may take up to 30 seconds
+//	shutdown, a notification, the process has a few seconds to exit
+//
+// Methods of bot:
+//
+//	send_message {"chat_id", "text"} -> {"message_id"}, only to registered users of the bot,
+//	    not blocked and not deleted
+//	get_user {"ref"} -> User, ref is a Telegram ID, @username, "tg:" + Telegram ID or "id:" + database ID
+const ProtocolVersion = 1
+
+// Error codes of bot methods
+const (
+	InvalidChat  = 1
+	UserNotFound = 2
+)
+
+var (
+	initTimeout    = 10 * time.Second
+	commandTimeout = 30 * time.Second
+	stopTimeout    = 5 * time.Second
+
+	commandRe = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+)
+
+// Info is a result of "initialize"
+type Info struct {
+	Name     string        `json:"name"`
+	Version  string        `json:"version"`
+	Commands []CommandInfo `json:"commands"`
+}
+
+// CommandInfo is a command of external plugin, roles get a permission "<plugin>.<command>", a command without roles is
+// allowed for everyone
+type CommandInfo struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Roles       []string `json:"roles"`
+}
+
+// User is a user as plugins see it
+type User struct {
+	ID         int64  `json:"id"`
+	TelegramID int64  `json:"telegram_id"`
+	UserName   string `json:"user_name,omitempty"`
+	FirstName  string `json:"first_name,omitempty"`
+	LastName   string `json:"last_name,omitempty"`
+	Role       string `json:"role"`
+	Language   string `json:"language"`
+}
+
+// CommandParams are params of "command"
+type CommandParams struct {
+	Command   string `json:"command"`
+	Args      string `json:"args"`
+	ChatID    int64  `json:"chat_id"`
+	MessageID int    `json:"message_id,omitempty"`
+	User      User   `json:"user"`
+}
+
+// SendMessageParams are params of "send_message"
+type SendMessageParams struct {
+	ChatID int64  `json:"chat_id"`
+	Text   string `json:"text"`
+}
+
+// GetUserParams are params of "get_user"
+type GetUserParams struct {
+	Ref string `json:"ref"`
+}
+
+// API is a part of the bot available to external plugins
+type API interface {
+	SendMessage(chatID int64, text string) (int, error)
+	GetUser(ref string) (*database.User, error)
+}
+
+type botAPI struct{}
+
+func (botAPI) SendMessage(chatID int64, text string) (int, error) {
+	sent, err := telegram.SendCustomMessage(chatID, 0, text, false, nil)
+	return sent.MessageID, err
+}
+
+func (botAPI) GetUser(ref string) (*database.User, error) {
+	return telegram.ResolveUser(nil, ref)
+}
+
+// Plugin is an executable speaking the protocol, the bot starts it with the plugin and stops it with the plugin
+type Plugin struct {
+	name string
+	path string
+	api  API
+
+	// calls are running commands
+	calls sync.WaitGroup
+
+	mu    sync.Mutex
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	conn  *rpc.Conn
+	info  Info
+}
+
+// Register adds plugins for executables from a comma separated list, a plugin is named "external.<file name>"
+func Register(paths string) {
+	for _, path := range strings.Split(paths, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			plugins.RegisterPlugin(New(path))
+		}
+	}
+}
+
+// New ...
+func New(path string) *Plugin {
+	base := filepath.Base(path)
+
+	return &Plugin{
+		name: "external." + strings.TrimSuffix(base, filepath.Ext(base)),
+		path: path,
+		api:  botAPI{},
+	}
+}
+
+// Name ...
+func (p *Plugin) Name() string {
+	return p.name
+}
+
+// Meta is known after the process is started
+func (p *Plugin) Meta() plugins.Meta {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return plugins.Meta{
+		Version: p.info.Version,
+	}
+}
+
+func (p *Plugin) OnStart() {
+	info, err := p.start()
+	if err != nil {
+		plugins.Fail(p.name, err)
+		return
+	}
+
+	for _, c := range info.Commands {
+		permission := ""
+		if len(c.Roles) > 0 {
+			permission = p.name + "." + c.Name
+			plugins.RegisterPermission(permission, c.Description, c.Roles...)
+		}
+
+		plugins.RegisterCommand(c.Name, c.Description, permission, p.command)
+	}
+}
+
+func (p *Plugin) OnStop() {
+	dlog.Debugln("[" + p.name + "] Stopped")
+
+	p.mu.Lock()
+	info := p.info
+	p.mu.Unlock()
+
+	for _, c := range info.Commands {
+		plugins.UnregisterCommand(c.Name)
+	}
+
+	p.stop()
+}
+
+// start runs the process and asks it for commands, a process left from the previous start is stopped
+func (p *Plugin) start() (Info, error) {
+	p.stop()
+
+	cmd := exec.Command(p.path)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return Info{}, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return Info{}, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return Info{}, err
+	}
+
+	if err = cmd.Start(); err != nil {
+		return Info{}, err
+	}
+
+	go p.log(stderr)
+
+	conn := rpc.NewConn(stdout, stdin, p.handle)
+
+	var info Info
+	err = conn.Call("initialize", map[string]int{"protocol": ProtocolVersion}, &info, initTimeout)
+	if err == nil {
+		err = checkInfo(info)
+	}
+	if err != nil {
+		_ = stdin.Close()
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return Info{}, err
+	}
+
+	p.mu.Lock()
+	p.cmd, p.stdin, p.conn, p.info = cmd, stdin, conn, info
+	p.mu.Unlock()
+
+	go p.watch(conn)
+
+	dlog.Debugf("[%s] started %s %s", p.name, info.Name, info.Version)
+
+	return info, nil
+}
+
+func checkInfo(info Info) error {
+	for _, c := range info.Commands {
+		if !commandRe.MatchString(c.Name) {
+			return i18n.NewError("external.wrong_command", "command", c.Name)
+		}
+	}
+
+	return nil
+}
+
+// stop asks the process to exit and kills it if it doesn't
+func (p *Plugin) stop() {
+	p.mu.Lock()
+	cmd, stdin, conn := p.cmd, p.stdin, p.conn
+	p.cmd, p.stdin, p.conn = nil, nil, nil
+	p.mu.Unlock()
+
+	if cmd == nil {
+		return
+	}
+
+	_ = conn.Notify("shutdown", nil)
+	_ = stdin.Close()
+
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+
+	select {
+	case <-exited:
+	case <-time.After(stopTimeout):
+		dlog.Errorf("[%s] killed after %s", p.name, stopTimeout)
+		_ = cmd.Process.Kill()
+		<-exited
+	}
+
+	// commands end with the process, their errors are reported before plugin stops
+	p.calls.Wait()
+}
+
+// watch fails the plugin if its process exits while the plugin is running
+func (p *Plugin) watch(conn *rpc.Conn) {
+	<-conn.Done()
+
+	p.mu.Lock()
+	cmd := p.cmd
+	if p.conn != conn {
+		cmd = nil
+	} else {
+		p.cmd, p.stdin, p.conn = nil, nil, nil
+	}
+	p.mu.Unlock()
+
+	if cmd != nil {
+		_ = cmd.Wait()
+		plugins.Fail(p.name, i18n.NewError("external.exited"))
+	}
+}
+
+func (p *Plugin) log(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		dlog.Infof("[%s] %s", p.name, scanner.Text())
+	}
+}
+
+func (p *Plugin) connection() *rpc.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.conn
+}
+
+func (p *Plugin) command(update *tgbotapi.Update, command, args string, user *database.User) error {
+	params := CommandParams{
+		Command: command,
+		Args:    args,
+		ChatID:  user.TelegramID,
+		User:    userOf(user),
+	}
+
+	if update.Message != nil {
+		params.ChatID = update.Message.Chat.ID
+		params.MessageID = update.Message.MessageID
+	}
+
+	if update.CallbackQuery != nil {
+		_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, ""))
+		if err != nil {
+			dlog.Errorln(err.Error())
+		}
+	}
+
+	conn := p.connection()
+	if conn == nil {
+		_, err := p.api.SendMessage(user.TelegramID, i18n.Failed(user, i18n.NewError("external.not_running", "plugin", p.name)))
+		return err
+	}
+
+	// updates are processed one by one, a slow plugin must not hold the others
+	p.calls.Add(1)
+	go func() {
+		defer p.calls.Done()
+
+		if err := conn.Call("command", params, nil, commandTimeout); err != nil {
+			dlog.Errorf("[%s] /%s failed: %s", p.name, command, err)
+
+			if _, err = p.api.SendMessage(user.TelegramID, i18n.Failed(user, err)); err != nil {
+				dlog.Errorln(err.Error())
+			}
+		}
+	}()
+
+	return nil
+}
+
+// handle serves calls of the process
+func (p *Plugin) handle(method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "send_message":
+		var args SendMessageParams
+		if err := json.Unmarshal(params, &args); err != nil || args.Text == "" {
+			return nil, &rpc.Error{Code: rpc.InvalidParams, Message: "chat_id and text are required"}
+		}
+
+		// plugins write only to registered users of the bot, not blocked and not deleted
+		recipient, err := p.api.GetUser("tg:" + strconv.FormatInt(args.ChatID, 10))
+		if err != nil {
+			return nil, &rpc.Error{Code: InvalidChat, Message: "chat is not allowed: " + err.Error()}
+		}
+
+		switch recipient.Role {
+		case database.New, database.Blocked, database.Deleted:
+			return nil, &rpc.Error{Code: InvalidChat, Message: "chat is not allowed: user is " + recipient.Role}
+		}
+
+		messageID, err := p.api.SendMessage(args.ChatID, args.Text)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]int{"message_id": messageID}, nil
+	case "get_user":
+		var args GetUserParams
+		if err := json.Unmarshal(params, &args); err != nil || args.Ref == "" {
+			return nil, &rpc.Error{Code: rpc.InvalidParams, Message: "ref is required"}
+		}
+
+		user, err := p.api.GetUser(args.Ref)
+		if err != nil {
+			return nil, &rpc.Error{Code: UserNotFound, Message: err.Error()}
+		}
+
+		return userOf(user), nil
+	}
+
+	return nil, &rpc.Error{Code: rpc.MethodNotFound, Message: "method not found: " + method}
+}
+
+func userOf(user *database.User) User {
+	return User{
+		ID:         user.ID,
+		TelegramID: user.TelegramID,
+		UserName:   user.UserName,
+		FirstName:  user.FirstName,
+		LastName:   user.LastName,
+		Role:       user.Role,
+		Language:   i18n.Lang(user),
+	}
+}
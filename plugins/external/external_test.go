@@ -0,0 +1,272 @@
+package external
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/plugins/external/rpc"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// the test binary is a stub plugin when the variable is set, its value is a mode of the stub
+const stubEnv = "CORPOBOT_STUB_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(stubEnv); mode != "" {
+		runStub(mode)
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+func runStub(mode string) {
+	var conn *rpc.Conn
+
+	conn = rpc.NewConn(os.Stdin, os.Stdout, func(method string, params json.RawMessage) (interface{}, error) {
+		switch method {
+		case "initialize":
+			name := "stub"
+			if mode == "bad" {
+				name = "Not a command"
+			}
+			return Info{Name: "stub", Version: "0.1", Commands: []CommandInfo{{Name: name, Description: "stub command"}}}, nil
+		case "command":
+			var cmd CommandParams
+			if err := json.Unmarshal(params, &cmd); err != nil {
+				return nil, err
+			}
+
+			switch cmd.Args {
+			case "fail":
+				return nil, errors.New("boom")
+			case "exit":
+				os.Exit(1)
+			case "spam":
+				return nil, conn.Call("send_message", SendMessageParams{ChatID: 999, Text: "spam"}, nil, time.Second)
+			case "slow":
+				time.Sleep(300 * time.Millisecond)
+			case "blocked":
+				return nil, conn.Call("send_message", SendMessageParams{ChatID: 200, Text: "spam"}, nil, time.Second)
+			}
+
+			var target User
+			if err := conn.Call("get_user", GetUserParams{Ref: "@alice"}, &target, time.Second); err != nil {
+				return nil, err
+			}
+
+			text := cmd.Args + " " + target.UserName + " from " + cmd.User.FirstName
+			return nil, conn.Call("send_message", SendMessageParams{ChatID: cmd.ChatID, Text: text}, nil, time.Second)
+		case "shutdown":
+			os.Exit(0)
+		}
+
+		return nil, &rpc.Error{Code: rpc.MethodNotFound, Message: method}
+	})
+
+	<-conn.Done()
+}
+
+type sent struct {
+	chatID int64
+	text   string
+}
+
+type fakeAPI struct {
+	mu   sync.Mutex
+	sent []sent
+}
+
+func (a *fakeAPI) SendMessage(chatID int64, text string) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.sent = append(a.sent, sent{chatID, text})
+
+	return len(a.sent), nil
+}
+
+func (a *fakeAPI) GetUser(ref string) (*database.User, error) {
+	switch ref {
+	case "@alice", "tg:100":
+		return &database.User{ID: 1, TelegramID: 100, UserName: "alice", FirstName: "Alice", Role: database.Member}, nil
+	case "tg:200":
+		return &database.User{ID: 2, TelegramID: 200, UserName: "mallory", Role: database.Blocked}, nil
+	}
+
+	return nil, errors.New(database.UserNotFound)
+}
+
+func (a *fakeAPI) messages() []sent {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]sent(nil), a.sent...)
+}
+
+func startStub(t *testing.T, mode string) (*Plugin, *fakeAPI) {
+	t.Helper()
+
+	if err := os.Setenv(stubEnv, mode); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv(stubEnv)
+
+	api := &fakeAPI{}
+	p := New(os.Args[0])
+	p.api = api
+
+	info, err := p.start()
+	if err != nil {
+		t.Fatalf("start: %s", err)
+	}
+	t.Cleanup(p.stop)
+
+	if len(info.Commands) != 1 || info.Commands[0].Name != "stub" || info.Version != "0.1" {
+		t.Fatalf("unexpected info %+v", info)
+	}
+
+	return p, api
+}
+
+func runCommand(t *testing.T, p *Plugin, args string) {
+	t.Helper()
+
+	update := &tgbotapi.Update{Message: &tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: 100}}}
+	user := &database.User{TelegramID: 100, FirstName: "Bob", Role: database.Member, Language: "en"}
+
+	if err := p.command(update, "stub", args, user); err != nil {
+		t.Fatalf("command: %s", err)
+	}
+
+	// commands run in background
+	p.calls.Wait()
+}
+
+func TestCommand(t *testing.T) {
+	p, api := startStub(t, "ok")
+
+	runCommand(t, p, "hi")
+
+	got := api.messages()
+	if len(got) != 1 || got[0].chatID != 100 || got[0].text != "hi alice from Bob" {
+		t.Errorf("unexpected messages %+v", got)
+	}
+}
+
+func TestCommandError(t *testing.T) {
+	p, api := startStub(t, "ok")
+
+	runCommand(t, p, "fail")
+
+	got := api.messages()
+	if len(got) != 1 || !strings.Contains(got[0].text, "boom") {
+		t.Errorf("error is not shown to user: %+v", got)
+	}
+}
+
+func TestSendMessageOnlyToUsers(t *testing.T) {
+	p, api := startStub(t, "ok")
+
+	runCommand(t, p, "spam")
+
+	got := api.messages()
+	if len(got) != 1 || got[0].chatID != 100 || !strings.Contains(got[0].text, "chat is not allowed") {
+		t.Errorf("message to unknown chat is not refused: %+v", got)
+	}
+}
+
+func TestSendMessageNotToBlocked(t *testing.T) {
+	p, api := startStub(t, "ok")
+
+	runCommand(t, p, "blocked")
+
+	got := api.messages()
+	if len(got) != 1 || got[0].chatID != 100 || !strings.Contains(got[0].text, "user is blocked") {
+		t.Errorf("message to blocked user is not refused: %+v", got)
+	}
+}
+
+func TestSlowCommandDoesNotBlock(t *testing.T) {
+	p, api := startStub(t, "ok")
+
+	update := &tgbotapi.Update{Message: &tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: 100}}}
+	user := &database.User{TelegramID: 100, FirstName: "Bob", Role: database.Member, Language: "en"}
+
+	started := time.Now()
+	if err := p.command(update, "stub", "slow", user); err != nil {
+		t.Fatalf("command: %s", err)
+	}
+	if elapsed := time.Since(started); elapsed > 100*time.Millisecond {
+		t.Errorf("command waits for plugin: %s", elapsed)
+	}
+
+	p.calls.Wait()
+
+	if got := api.messages(); len(got) != 1 || got[0].text != "slow alice from Bob" {
+		t.Errorf("unexpected messages %+v", got)
+	}
+}
+
+func TestWrongCommandName(t *testing.T) {
+	if err := os.Setenv(stubEnv, "bad"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv(stubEnv)
+
+	p := New(os.Args[0])
+	if _, err := p.start(); err == nil {
+		p.stop()
+		t.Fatal("plugin with a wrong command name is started")
+	}
+
+	if p.connection() != nil {
+		t.Error("failed plugin keeps connection")
+	}
+}
+
+func TestProcessExit(t *testing.T) {
+	p, api := startStub(t, "ok")
+
+	runCommand(t, p, "exit")
+
+	got := api.messages()
+	if len(got) != 1 || !strings.Contains(got[0].text, "connection closed") {
+		t.Errorf("exit is not shown to user: %+v", got)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for p.connection() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("exited process is not forgotten")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	runCommand(t, p, "hi")
+
+	if got := api.messages(); len(got) != 2 || !strings.Contains(got[1].text, "is not running") {
+		t.Errorf("command of exited plugin: %+v", got)
+	}
+}
+
+func TestStop(t *testing.T) {
+	p, _ := startStub(t, "ok")
+
+	p.mu.Lock()
+	cmd := p.cmd
+	p.mu.Unlock()
+
+	p.stop()
+
+	if p.connection() != nil || cmd.ProcessState == nil || !cmd.ProcessState.Success() {
+		t.Errorf("process is not stopped by shutdown: %v", cmd.ProcessState)
+	}
+}
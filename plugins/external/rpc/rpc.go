@@ -0,0 +1,253 @@
+// Package rpc is JSON-RPC 2.0 over a pair of streams with one message per line, external plugins speak it over
+// stdin and stdout. It depends only on the standard library, so plugins written in Go may use it too
+package rpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Request is a call or a notification (without ID)
+type Request struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+// Response ...
+type Response struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *Error           `json:"error,omitempty"`
+}
+
+// message is a request or a response read from the other side
+type message struct {
+	Request
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *Error          `json:"error,omitempty"`
+}
+
+// Error is a JSON-RPC error
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// JSON-RPC error codes
+const (
+	ParseError     = -32700
+	MethodNotFound = -32601
+	InvalidParams  = -32602
+	InternalError  = -32603
+)
+
+// Handler serves requests of the other side, a returned *Error is sent as is, other errors as InternalError
+type Handler func(method string, params json.RawMessage) (interface{}, error)
+
+// Conn is a JSON-RPC connection over a pair of streams
+type Conn struct {
+	w       io.Writer
+	writeMu sync.Mutex
+
+	handler Handler
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[string]chan *message
+	closed  error
+
+	done chan struct{}
+}
+
+var errClosed = errors.New("connection closed")
+
+// NewConn starts reading r, requests are served by handler in their own goroutines
+func NewConn(r io.Reader, w io.Writer, handler Handler) *Conn {
+	c := &Conn{
+		w:       w,
+		handler: handler,
+		pending: make(map[string]chan *message),
+		done:    make(chan struct{}),
+	}
+
+	go c.read(r)
+
+	return c
+}
+
+// Done is closed when the other side closes its stream
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Call sends a request and waits for its result, a zero timeout waits until the connection is closed
+func (c *Conn) Call(method string, params, result interface{}, timeout time.Duration) error {
+	c.mu.Lock()
+	if c.closed != nil {
+		c.mu.Unlock()
+		return c.closed
+	}
+	c.nextID++
+	id := strconv.FormatInt(c.nextID, 10)
+	ch := make(chan *message, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	raw := json.RawMessage(id)
+	if err := c.send(method, &raw, params); err != nil {
+		return err
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case msg := <-ch:
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result != nil && len(msg.Result) > 0 {
+			return json.Unmarshal(msg.Result, result)
+		}
+		return nil
+	case <-c.done:
+		return c.closed
+	case <-expired:
+		return errors.New(method + ": timeout")
+	}
+}
+
+// Notify sends a request without waiting for a result
+func (c *Conn) Notify(method string, params interface{}) error {
+	return c.send(method, nil, params)
+}
+
+func (c *Conn) send(method string, id *json.RawMessage, params interface{}) error {
+	req := Request{JSONRPC: "2.0", ID: id, Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = data
+	}
+
+	return c.write(req)
+}
+
+func (c *Conn) write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	_, err = c.w.Write(append(data, '\n'))
+
+	return err
+}
+
+func (c *Conn) read(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		var msg message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			_ = c.write(Response{JSONRPC: "2.0", Error: &Error{Code: ParseError, Message: err.Error()}})
+			continue
+		}
+
+		if msg.Method == "" {
+			c.resolve(&msg)
+			continue
+		}
+
+		go c.serve(msg.Request)
+	}
+
+	err := scanner.Err()
+	if err == nil {
+		err = errClosed
+	}
+
+	c.mu.Lock()
+	c.closed = err
+	c.mu.Unlock()
+
+	close(c.done)
+}
+
+func (c *Conn) resolve(msg *message) {
+	if msg.ID == nil {
+		return
+	}
+
+	c.mu.Lock()
+	ch, ok := c.pending[string(*msg.ID)]
+	c.mu.Unlock()
+
+	if ok {
+		ch <- msg
+	}
+}
+
+func (c *Conn) serve(req Request) {
+	var (
+		result interface{}
+		err    error
+	)
+
+	if c.handler == nil {
+		err = &Error{Code: MethodNotFound, Message: "method not found: " + req.Method}
+	} else {
+		result, err = c.handler(req.Method, req.Params)
+	}
+
+	// notifications have no response
+	if req.ID == nil {
+		return
+	}
+
+	resp := Response{JSONRPC: "2.0", ID: req.ID}
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &Error{Code: InternalError, Message: err.Error()}
+		}
+		resp.Error = rpcErr
+	} else {
+		data, errMarshal := json.Marshal(result)
+		if errMarshal != nil {
+			resp.Error = &Error{Code: InternalError, Message: errMarshal.Error()}
+		} else {
+			resp.Result = data
+		}
+	}
+
+	_ = c.write(resp)
+}
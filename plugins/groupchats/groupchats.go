@@ -1,10 +1,13 @@
 package groupchats
 
 import (
+	"errors"
 	"strconv"
 	"strings"
+	"time"
 
 	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
 	"github.com/ad/corpobot/plugins"
 	"github.com/ad/corpobot/telegram"
 
@@ -14,36 +17,113 @@ import (
 
 type Plugin struct{}
 
+var stopMembersCheck chan struct{}
+
+// inviteLinkTTL is a lifetime of personal invite links given by /mychats
+const inviteLinkTTL = 24 * time.Hour
+
 func init() {
 	plugins.RegisterPlugin(&Plugin{})
 }
 
-func (m *Plugin) OnStart() {
-	if !plugins.CheckIfPluginDisabled("groupchats.Plugin", "enabled") {
-		return
+// Meta ...
+func (m *Plugin) Meta() plugins.Meta {
+	return plugins.Meta{
+		Version: "1.0",
 	}
+}
 
-	plugins.RegisterCommand("groupchatlist", "Groupchat list", []string{database.Member, database.Admin, database.Owner}, groupChatList)
-	plugins.RegisterCommand("groupchatinvitegenerate", "Generate groupchat invite link", []string{database.Admin, database.Owner}, groupChatInviteGenerate)
-	plugins.RegisterCommand("groupchatuserban", "Ban user in groupchat", []string{database.Admin, database.Owner}, groupChatUserBan)
-	plugins.RegisterCommand("groupchatuserunban", "Unban user in groupchat", []string{database.Admin, database.Owner}, groupChatUserUnban)
-	plugins.RegisterCommand("groupchatmembers", "List groupchat members", []string{database.Admin, database.Owner}, groupChatMembers)
-	plugins.RegisterCommand("groupchatdelete", "Delete groupchat", []string{database.Admin, database.Owner}, groupChatDelete)
+func (m *Plugin) OnStart() {
+	plugins.RegisterPermission("groupchats.list", "List groupchats", database.Member, database.Admin, database.Owner)
+	plugins.RegisterPermission("groupchats.listall", "List all groupchats with invite links", database.Admin, database.Owner)
+	plugins.RegisterPermission("groupchats.my", "List own groupchats with join links", database.Member, database.Admin, database.Owner)
+	plugins.RegisterPermission("groupchats.invite", "Generate groupchat invite links", database.Admin, database.Owner)
+	plugins.RegisterPermission("groupchats.ban", "Ban and unban users in groupchats", database.Admin, database.Owner)
+	plugins.RegisterPermission("groupchats.members", "View groupchat members", database.Admin, database.Owner)
+	plugins.RegisterPermission("groupchats.delete", "Delete groupchats", database.Admin, database.Owner)
+
+	plugins.RegisterCommand("groupchatlist", "Groupchat list", "groupchats.list", groupChatList)
+	plugins.RegisterChatCommand("mychats", "Your groups and groupchats with join links", "groupchats.my", plugins.AnyChats, myChats)
+	plugins.RegisterCommand("groupchatinvitegenerate", "Generate groupchat invite link", "groupchats.invite", groupChatInviteGenerate)
+	plugins.RegisterCommand("groupchatuserban", "Ban user in groupchat", "groupchats.ban", groupChatUserBan)
+	plugins.RegisterCommand("groupchatuserunban", "Unban user in groupchat", "groupchats.ban", groupChatUserUnban)
+	plugins.RegisterCommand("groupchatmembers", "List groupchat members", "groupchats.members", groupChatMembers)
+	plugins.RegisterCommand("groupchatdelete", "Delete groupchat", "groupchats.delete", groupChatDelete)
+	plugins.RegisterCommand("groupchatusers", "List known groupchat users", "groupchats.members", groupChatUsers)
+	plugins.RegisterCommand("groupchatstrangers", "List groupchat users who are not employees", "groupchats.members", groupChatUsers)
+	plugins.RegisterCommand("usergroupchats", "List groupchats where user is", "groupchats.members", userGroupChats)
+
+	plugins.Subscribe(plugins.GroupchatAddedEvent, botAdded)
+	plugins.Subscribe(plugins.BotRemovedFromChatEvent, botRemoved)
+
+	stopMembersCheck = make(chan struct{})
+	go membersCheck(stopMembersCheck)
 }
 
 func (m *Plugin) OnStop() {
 	dlog.Debugln("[groupchats.Plugin] Stopped")
 
 	plugins.UnregisterCommand("groupchatlist")
+	plugins.UnregisterCommand("mychats")
 	plugins.UnregisterCommand("groupchatinvitegenerate")
 	plugins.UnregisterCommand("groupchatuserban")
 	plugins.UnregisterCommand("groupchatuserunban")
 	plugins.UnregisterCommand("groupchatmembers")
 	plugins.UnregisterCommand("groupchatdelete")
+	plugins.UnregisterCommand("groupchatusers")
+	plugins.UnregisterCommand("groupchatstrangers")
+	plugins.UnregisterCommand("usergroupchats")
+
+	if stopMembersCheck != nil {
+		close(stopMembersCheck)
+		stopMembersCheck = nil
+	}
+}
+
+// botAdded tells admins that bot was added back to a known groupchat
+func botAdded(e plugins.Event) {
+	event := e.(*plugins.GroupchatAdded)
+	if event.Returned {
+		notifyAdmins(event.By, "groupchats.bot_added", "groupchat", event.Groupchat.String())
+	}
+}
+
+func botRemoved(e plugins.Event) {
+	event := e.(*plugins.BotRemovedFromChat)
+	notifyAdmins(event.By, "groupchats.bot_removed", "groupchat", event.Groupchat.Title+" ["+strconv.FormatInt(event.Groupchat.TelegramID, 10)+"]")
+}
+
+// notifyAdmins sends message of key to admins in their languages, from is a user who made the change
+func notifyAdmins(from *tgbotapi.User, key string, args ...interface{}) {
+	users, err := database.GetUsers(plugins.DB, []string{database.Admin, database.Owner})
+	if err != nil {
+		dlog.Errorln(err)
+		return
+	}
+
+	for _, u := range users {
+		message := i18n.T(u, key, args...)
+		if from != nil && from.ID != plugins.Bot.Self.ID {
+			message += " " + i18n.T(u, "groupchats.by_user", "user", from.String()+" ["+strconv.Itoa(from.ID)+"]")
+		}
+
+		if err := telegram.Send(u.TelegramID, message); err != nil {
+			dlog.Errorln(err.Error())
+		}
+	}
 }
 
 var groupChatList plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
-	groupchats, err := database.GetGroupchats(plugins.DB, strings.Fields(args))
+	listAll := plugins.HasPermission(user, "groupchats.listall")
+
+	var groupchats []*database.Groupchat
+	var err error
+
+	if listAll {
+		groupchats, err = database.GetGroupchats(plugins.DB, strings.Fields(args))
+	} else {
+		groupchats, err = database.GetEffectiveGroupchatsByUserID(plugins.DB, user.ID)
+	}
 	if err != nil {
 		return err
 	}
@@ -52,23 +132,101 @@ var groupChatList plugins.CommandCallback = func(update *tgbotapi.Update, comman
 		var groupchatsList []string
 
 		for _, u := range groupchats {
-			groupchatsList = append(groupchatsList, "• "+u.String())
+			if listAll {
+				groupchatsList = append(groupchatsList, "• "+u.String())
+			} else if u.State == database.Active {
+				groupchatsList = append(groupchatsList, "• "+u.Title)
+			}
+		}
+
+		if !listAll {
+			groupchatsList = append(groupchatsList, "\n"+i18n.T(user, "groupchats.mychats_hint"))
 		}
 
 		return telegram.Send(user.TelegramID, strings.Join(groupchatsList, "\n"))
 	}
 
-	return telegram.Send(user.TelegramID, "groupchat list is empty")
+	return telegram.Send(user.TelegramID, i18n.T(user, "groupchats.empty"))
+}
+
+// myChats sends personal join links to private chat of user, in groupchat the command is answered with a hint
+var myChats plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	err := sendMyChats(user)
+	if update.Message == nil || update.Message.Chat.IsPrivate() {
+		return err
+	}
+
+	if err != nil {
+		dlog.Errorln(err)
+		return telegram.Reply(update, user, i18n.T(user, "groupchats.start_private", "bot", plugins.Bot.Self.UserName))
+	}
+
+	return telegram.Reply(update, user, i18n.T(user, "groupchats.sent_private"))
+}
+
+func sendMyChats(user *database.User) error {
+	groups, err := database.GetGroupsByUserID(plugins.DB, user.ID)
+	if err != nil {
+		return err
+	}
+
+	groupchats, err := database.GetEffectiveGroupchatsByUserID(plugins.DB, user.ID)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, g := range groups {
+		if g.State == database.Active {
+			names = append(names, g.Name)
+		}
+	}
+
+	if len(names) == 0 {
+		return telegram.Send(user.TelegramID, i18n.T(user, "groupchats.no_groups"))
+	}
+
+	text := i18n.T(user, "groupchats.your_groups", "groups", strings.Join(names, ", "))
+
+	// personal single-use links, they don't revoke links given to others
+	expire := time.Now().Add(inviteLinkTTL)
+
+	buttons := make([][]tgbotapi.InlineKeyboardButton, 0)
+	for _, c := range groupchats {
+		if c.State != database.Active {
+			continue
+		}
+
+		link, err := telegram.CreateInviteLink(c, 1, expire)
+		if err != nil {
+			dlog.Errorf("create invite link to %s failed: %s", c.Title, err)
+
+			if link, err = telegram.GetInviteLink(c); err != nil {
+				dlog.Errorln(err.Error())
+				continue
+			}
+		}
+
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonURL(c.Title, link)))
+	}
+
+	if len(buttons) == 0 {
+		return telegram.Send(user.TelegramID, text+"\n\n"+i18n.T(user, "groupchats.no_chats"))
+	}
+
+	replyKeyboard := tgbotapi.NewInlineKeyboardMarkup(buttons...)
+
+	return telegram.SendCustom(user.TelegramID, 0, text+"\n\n"+i18n.T(user, "groupchats.links_valid"), false, &replyKeyboard)
 }
 
 var groupChatInviteGenerate plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
 	if args == "" {
-		return telegram.Send(user.TelegramID, "failed: empty groupchat ID")
+		return telegram.Send(user.TelegramID, i18n.T(user, "groupchats.provide_chat"))
 	}
 
 	telegramID, err := strconv.ParseInt(args, 10, 64)
 	if err != nil {
-		return telegram.Send(user.TelegramID, "failed: "+err.Error())
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
 	}
 
 	groupchat := &database.Groupchat{
@@ -77,22 +235,24 @@ var groupChatInviteGenerate plugins.CommandCallback = func(update *tgbotapi.Upda
 
 	inviteLink, err := plugins.Bot.GetInviteLink(tgbotapi.ChatConfig{ChatID: groupchat.TelegramID})
 	if err != nil {
-		return telegram.Send(user.TelegramID, "failed: "+err.Error())
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
 	}
 
 	groupchat.InviteLink = inviteLink
 	if groupchat.InviteLink != "" {
 		_, err := database.UpdateGroupChatInviteLink(plugins.DB, groupchat)
 		if err != nil {
-			return telegram.Send(user.TelegramID, "failed: "+err.Error())
+			return telegram.Send(user.TelegramID, i18n.Failed(user, err))
 		}
+
+		plugins.Audit(user, command, chatTarget(groupchat.TelegramID), "", "new invite link")
 	}
 
-	return telegram.Send(user.TelegramID, "success")
+	return telegram.Send(user.TelegramID, i18n.T(user, "common.success"))
 }
 
 var groupChatUserBan plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
-	errorString := "failed: you must provide the IDs of the user ans groupchat with a new line between them"
+	errorString := i18n.T(user, "groupchats.provide_user_chat")
 
 	params := strings.Split(args, "\n")
 
@@ -106,9 +266,9 @@ var groupChatUserBan plugins.CommandCallback = func(update *tgbotapi.Update, com
 		return telegram.Send(user.TelegramID, errorString)
 	}
 
-	var userID int
-	if n, err := strconv.Atoi(userIDstring); err == nil {
-		userID = n
+	userID, err := memberID(update, userIDstring)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
 	}
 
 	var groupchatID int64
@@ -130,14 +290,16 @@ var groupChatUserBan plugins.CommandCallback = func(update *tgbotapi.Update, com
 		},
 	)
 	if err != nil {
-		return telegram.Send(user.TelegramID, "failed: "+err.Error())
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
 	}
 
-	return telegram.Send(user.TelegramID, "success")
+	plugins.Audit(user, command, memberTarget(userID, groupchatID), "", database.Kicked)
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "common.success"))
 }
 
 var groupChatUserUnban plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
-	errorString := "failed: you must provide the IDs of the user ans groupchat with a new line between them"
+	errorString := i18n.T(user, "groupchats.provide_user_chat")
 
 	params := strings.Split(args, "\n")
 
@@ -151,9 +313,9 @@ var groupChatUserUnban plugins.CommandCallback = func(update *tgbotapi.Update, c
 		return telegram.Send(user.TelegramID, errorString)
 	}
 
-	var userID int
-	if n, err := strconv.Atoi(userIDstring); err == nil {
-		userID = n
+	userID, err := memberID(update, userIDstring)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
 	}
 
 	var groupchatID int64
@@ -168,14 +330,16 @@ var groupChatUserUnban plugins.CommandCallback = func(update *tgbotapi.Update, c
 
 	_, err = plugins.Bot.UnbanChatMember(tgbotapi.ChatMemberConfig{ChatID: groupchatID, UserID: userID})
 	if err != nil {
-		return telegram.Send(user.TelegramID, "failed: "+err.Error())
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
 	}
 
-	return telegram.Send(user.TelegramID, "success")
+	plugins.Audit(user, command, memberTarget(userID, groupchatID), database.Kicked, "")
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "common.success"))
 }
 
 var groupChatMembers plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
-	errorString := "failed: you must provide the groupchat ID"
+	errorString := i18n.T(user, "groupchats.provide_chat")
 
 	if args == "" {
 		return telegram.Send(user.TelegramID, errorString)
@@ -193,7 +357,7 @@ var groupChatMembers plugins.CommandCallback = func(update *tgbotapi.Update, com
 
 	result, err := plugins.Bot.GetChatAdministrators(tgbotapi.ChatConfig{ChatID: groupchatID})
 	if err != nil {
-		return telegram.Send(user.TelegramID, "failed: "+err.Error())
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
 	}
 
 	if len(result) > 0 {
@@ -205,11 +369,11 @@ var groupChatMembers plugins.CommandCallback = func(update *tgbotapi.Update, com
 		return telegram.Send(user.TelegramID, strings.Join(usersList, "\n"))
 	}
 
-	return telegram.Send(user.TelegramID, "users not found")
+	return telegram.Send(user.TelegramID, i18n.T(user, "groupchats.no_users"))
 }
 
 var groupChatDelete plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
-	errorString := "failed: you must provide the groupchat ID"
+	errorString := i18n.T(user, "groupchats.provide_chat")
 
 	if args == "" {
 		return telegram.Send(user.TelegramID, errorString)
@@ -225,14 +389,201 @@ var groupChatDelete plugins.CommandCallback = func(update *tgbotapi.Update, comm
 		return telegram.Send(user.TelegramID, errorString)
 	}
 
+	target := chatTarget(groupchatID)
+
 	result, err := database.GroupChatDelete(plugins.DB, &database.Groupchat{TelegramID: groupchatID})
 	if err != nil {
-		return telegram.Send(user.TelegramID, "failed: "+err.Error())
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
 	}
 
 	if result {
-		return telegram.Send(user.TelegramID, "success")
+		plugins.Audit(user, command, target, "", "")
+
+		return telegram.Send(user.TelegramID, i18n.T(user, "common.success"))
+	}
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "common.failed"))
+}
+
+var groupChatUsers plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	errorString := i18n.T(user, "groupchats.provide_chat")
+
+	groupchatID, err := strconv.ParseInt(args, 10, 64)
+	if err != nil || groupchatID == 0 {
+		return telegram.Send(user.TelegramID, errorString)
+	}
+
+	groupchat, err := database.GetGroupChatByTelegramID(plugins.DB, &database.Groupchat{TelegramID: groupchatID})
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Err(user, err))
+	}
+
+	var members []*database.GroupchatMember
+	if command == "groupchatstrangers" {
+		members, err = database.GetGroupchatUnregisteredMembers(plugins.DB, groupchat)
+	} else {
+		members, err = database.GetGroupchatMembers(plugins.DB, groupchat)
+	}
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	if len(members) > 0 {
+		var usersList []string
+
+		for _, u := range members {
+			usersList = append(usersList, "• "+u.String())
+		}
+
+		return telegram.Send(user.TelegramID, strings.Join(usersList, "\n"))
+	}
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "groupchats.no_users"))
+}
+
+var userGroupChats plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	if args == "" {
+		return telegram.AskUser(update, user, command, "")
+	}
+
+	userID, err := memberID(update, args)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	groupchats, err := database.GetGroupchatsByMemberTelegramID(plugins.DB, int64(userID))
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	if len(groupchats) > 0 {
+		var groupchatsList []string
+
+		for _, u := range groupchats {
+			groupchatsList = append(groupchatsList, "• "+u.String())
+		}
+
+		return telegram.Send(user.TelegramID, strings.Join(groupchatsList, "\n"))
+	}
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "groupchats.empty"))
+}
+
+// membersCheckDelay keeps members check well below Telegram limits
+const membersCheckDelay = 200 * time.Millisecond
+
+// membersCheck periodically asks Telegram about present and expected members of every active groupchat
+func membersCheck(stop chan struct{}) {
+	interval := time.Duration(plugins.Config.MembersCheckInterval) * time.Minute
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if plugins.Bot == nil {
+				continue
+			}
+			checkMembers(stop)
+		}
+	}
+}
+
+func checkMembers(stop chan struct{}) {
+	groupchats, err := database.GetGroupchats(plugins.DB, []string{database.Active})
+	if err != nil {
+		dlog.Errorln(err)
+		return
+	}
+
+	for _, groupchat := range groupchats {
+		telegramIDs, err := database.GetGroupchatExpectedMembers(plugins.DB, groupchat)
+		if err != nil {
+			dlog.Errorln(err)
+			continue
+		}
+
+		for _, telegramID := range telegramIDs {
+			delay := membersCheckDelay
+
+			member, err := plugins.Bot.GetChatMember(tgbotapi.ChatConfigWithUser{ChatID: groupchat.TelegramID, UserID: int(telegramID)})
+			if err != nil {
+				dlog.Errorf("get member [%d] of %s failed: %s", telegramID, groupchat.Title, err)
+
+				var e tgbotapi.Error
+				if errors.As(err, &e) && e.RetryAfter > 0 {
+					delay = time.Duration(e.RetryAfter) * time.Second
+				}
+			} else if isKnownMember(groupchat, member) {
+				telegram.StoreGroupChatMember(plugins.DB, groupchat.TelegramID, member.User, member.Status)
+			}
+
+			select {
+			case <-stop:
+				return
+			case <-time.After(delay):
+			}
+		}
+	}
+}
+
+// isKnownMember checks if member is in groupchat or was stored before, so users who never joined are not stored
+func isKnownMember(groupchat *database.Groupchat, member tgbotapi.ChatMember) bool {
+	if member.User == nil {
+		return false
+	}
+
+	if (&database.GroupchatMember{Status: member.Status}).IsPresent() {
+		return true
+	}
+
+	_, err := database.GetGroupchatMember(plugins.DB, &database.GroupchatMember{GroupchatID: groupchat.ID, TelegramID: int64(member.User.ID)})
+
+	return err == nil
+}
+
+// chatTarget is a readable groupchat name for audit log
+func chatTarget(telegramID int64) string {
+	groupchat, err := database.GetGroupChatByTelegramID(plugins.DB, &database.Groupchat{TelegramID: telegramID})
+	if err != nil {
+		return strconv.FormatInt(telegramID, 10)
+	}
+
+	return groupchat.Label()
+}
+
+// memberTarget is a readable user in groupchat for audit log
+func memberTarget(userID int, groupchatID int64) string {
+	target := strconv.Itoa(userID)
+
+	u, err := database.GetUserByTelegramID(plugins.DB, &database.User{TelegramID: int64(userID)})
+	if err == nil {
+		target = u.String()
+	}
+
+	return target + " in " + chatTarget(groupchatID)
+}
+
+// memberID resolves user reference, unknown numeric IDs are taken as is since groupchat members may have never talked
+// to the bot
+func memberID(update *tgbotapi.Update, ref string) (int, error) {
+	u, err := telegram.ResolveUser(update, ref)
+	if err == nil {
+		return int(u.TelegramID), nil
+	}
+
+	var e *i18n.Error
+	if errors.As(err, &e) && (e.Key == "userref.not_found" || e.Key == "userref.not_started") {
+		if n, errAtoi := strconv.Atoi(strings.TrimPrefix(ref, "tg:")); errAtoi == nil && n != 0 {
+			return n, nil
+		}
 	}
 
-	return telegram.Send(user.TelegramID, "failed")
+	return 0, err
 }
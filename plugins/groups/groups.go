@@ -3,8 +3,12 @@ package groups
 import (
 	"strconv"
 	"strings"
+	"time"
 
+	cal "github.com/ad/corpobot/calendar"
 	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
+	"github.com/ad/corpobot/pagination"
 	"github.com/ad/corpobot/plugins"
 	"github.com/ad/corpobot/telegram"
 
@@ -18,26 +22,42 @@ func init() {
 	plugins.RegisterPlugin(&Plugin{})
 }
 
-func (m *Plugin) OnStart() {
-	if !plugins.CheckIfPluginDisabled("groups.Plugin", "enabled") {
-		return
+// Meta ...
+func (m *Plugin) Meta() plugins.Meta {
+	return plugins.Meta{
+		Version: "1.0",
 	}
+}
 
-	plugins.RegisterCommand("grouplist", "Group list", []string{database.Member, database.Admin, database.Owner}, groupList)
-	plugins.RegisterCommand("groupcreate", "Create group", []string{database.Admin, database.Owner}, groupCreate)
-	plugins.RegisterCommand("grouprename", "Rename group", []string{database.Admin, database.Owner}, groupRename)
-	plugins.RegisterCommand("groupdelete", "Delete group", []string{database.Admin, database.Owner}, groupDeleteUndelete)
-	plugins.RegisterCommand("groupundelete", "Undelete group", []string{database.Admin, database.Owner}, groupDeleteUndelete)
-	plugins.RegisterCommand("groupaddgroupchat", "Add groupchat to group", []string{database.Admin, database.Owner}, groupAddGroupChat)
-	plugins.RegisterCommand("groupdeletegroupchat", "Delete groupchat from group", []string{database.Admin, database.Owner}, groupDeleteGroupChat)
-	plugins.RegisterCommand("groupadduser", "Add user to group", []string{database.Admin, database.Owner}, groupAddUser)
-	plugins.RegisterCommand("groupdeleteuser", "Delete user from group", []string{database.Admin, database.Owner}, groupDeleteUser)
+func (m *Plugin) OnStart() {
+	plugins.RegisterPermission("groups.list", "List groups", database.Member, database.Admin, database.Owner)
+	plugins.RegisterPermission("groups.edit", "Create and edit groups", database.Admin, database.Owner)
+	plugins.RegisterPermission("groups.managers", "Assign group managers", database.Admin, database.Owner)
+	plugins.RegisterPermission("groups.access", "View groupchats available to users", database.Admin, database.Owner)
+
+	plugins.RegisterCommand("grouplist", "Group list", "groups.list", groupList)
+	plugins.RegisterScopedCommand("group", "Group actions", "groups.edit", managedGroupScope, group)
+	plugins.RegisterCommand("groupcreate", "Create group", "groups.edit", groupCreate)
+	plugins.RegisterCommand("grouprename", "Rename group", "groups.edit", groupRename)
+	plugins.RegisterCommand("groupdelete", "Delete group", "groups.edit", groupDeleteUndelete)
+	plugins.RegisterCommand("groupundelete", "Undelete group", "groups.edit", groupDeleteUndelete)
+	plugins.RegisterScopedCommand("groupaddgroupchat", "Add groupchat to group", "groups.edit", managedGroupScope, groupAddDeleteGroupChat)
+	plugins.RegisterScopedCommand("groupdeletegroupchat", "Delete groupchat from group", "groups.edit", managedGroupScope, groupAddDeleteGroupChat)
+	plugins.RegisterScopedCommand("groupadduser", "Add user to group", "groups.edit", managedGroupScope, groupAddDeleteUser)
+	plugins.RegisterScopedCommand("groupdeleteuser", "Delete user from group", "groups.edit", managedGroupScope, groupAddDeleteUser)
+	plugins.RegisterScopedCommand("groupuserexpire", "Set expiry of group membership", "groups.edit", managedGroupScope, groupUserExpire)
+	plugins.RegisterCommand("groupaddmanager", "Add group manager", "groups.managers", groupAddDeleteUser)
+	plugins.RegisterCommand("groupdeletemanager", "Delete group manager", "groups.managers", groupAddDeleteUser)
+	plugins.RegisterCommand("groupsetparent", "Set parent group", "groups.edit", groupSetParent)
+	plugins.RegisterCommand("grouptree", "Group tree", "groups.list", groupTree)
+	plugins.RegisterCommand("useraccess", "Groupchats available to user through groups", "groups.access", userAccess)
 }
 
 func (m *Plugin) OnStop() {
 	dlog.Debugln("[groups.Plugin] Stopped")
 
 	plugins.UnregisterCommand("grouplist")
+	plugins.UnregisterCommand("group")
 	plugins.UnregisterCommand("groupcreate")
 	plugins.UnregisterCommand("grouprename")
 	plugins.UnregisterCommand("groupdelete")
@@ -46,21 +66,63 @@ func (m *Plugin) OnStop() {
 	plugins.UnregisterCommand("groupdeletegroupchat")
 	plugins.UnregisterCommand("groupadduser")
 	plugins.UnregisterCommand("groupdeleteuser")
+	plugins.UnregisterCommand("groupuserexpire")
+	plugins.UnregisterCommand("groupaddmanager")
+	plugins.UnregisterCommand("groupdeletemanager")
+	plugins.UnregisterCommand("groupsetparent")
+	plugins.UnregisterCommand("grouptree")
+	plugins.UnregisterCommand("useraccess")
 }
 
 var groupList plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
-	groups, err := database.GetGroups(plugins.DB, strings.Fields(args))
+	managed, err := database.GetManagedGroupsByUserID(plugins.DB, user.ID)
 	if err != nil {
 		return err
 	}
 
-	if len(groups) == 0 {
-		return telegram.Send(user.TelegramID, "group list is empty")
+	if canEditGroups(user) || len(managed) > 0 {
+		state, args := pagination.Parse(args)
+		if state.Search {
+			return pagination.AskQuery(update, user, command, args)
+		}
+
+		if canEditGroups(user) {
+			states := strings.Fields(args)
+			if len(states) == 0 {
+				states = []string{database.Active, database.Deleted}
+			}
+
+			managed, err = database.GetGroups(plugins.DB, states)
+			if err != nil {
+				return err
+			}
+		}
+
+		replyKeyboard := groupsKeyboard(managed, args, state)
+
+		answer(update, "")
+
+		return reply(update, user, i18n.T(user, "groups.choose"), &replyKeyboard)
+	}
+
+	// other users see only their own groups, invite links are given by /mychats
+	groups, err := database.GetGroupsByUserID(plugins.DB, user.ID)
+	if err != nil {
+		return err
+	}
+
+	states := strings.Fields(args)
+	if len(states) == 0 {
+		states = []string{database.Active}
 	}
 
 	var groupsList []string
 
 	for _, u := range groups {
+		if !containsString(states, u.State) {
+			continue
+		}
+
 		groupsList = append(groupsList, "* "+u.String())
 
 		groupchats, err := database.GetGroupchatsByGroupID(plugins.DB, u.ID)
@@ -68,16 +130,36 @@ var groupList plugins.CommandCallback = func(update *tgbotapi.Update, command, a
 			return err
 		}
 		for _, c := range groupchats {
-			groupsList = append(groupsList, "    * "+c.String())
+			groupsList = append(groupsList, "    * "+c.Title)
 		}
 	}
 
+	if len(groupsList) == 0 {
+		return telegram.Send(user.TelegramID, i18n.T(user, "groups.empty"))
+	}
+
 	return telegram.Send(user.TelegramID, strings.Join(groupsList, "\n"))
 }
 
+var group plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	g, err := getGroup(args)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Err(user, err))
+	}
+
+	text, replyKeyboard, err := groupCard(g, user)
+	if err != nil {
+		return err
+	}
+
+	answer(update, "")
+
+	return reply(update, user, text, &replyKeyboard)
+}
+
 var groupCreate plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
 	if args == "" {
-		return telegram.Send(user.TelegramID, "failed: empty group name")
+		return telegram.Send(user.TelegramID, i18n.T(user, "groups.empty_name"))
 	}
 
 	group := &database.Group{
@@ -86,35 +168,59 @@ var groupCreate plugins.CommandCallback = func(update *tgbotapi.Update, command,
 
 	_, err := database.AddGroupIfNotExist(plugins.DB, group)
 	if err != nil {
-		return telegram.Send(user.TelegramID, "failed: "+err.Error())
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
 	}
 
-	return telegram.Send(user.TelegramID, "group created")
+	plugins.Audit(user, command, group.Name, "", database.Active)
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "groups.created"))
 }
 
 var groupRename plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	errorString := i18n.T(user, "groups.rename_usage")
+
 	names := strings.Split(args, "\n")
 
+	// only group provided, wait for the new name
+	if len(names) == 1 && names[0] != "" {
+		g, err := getGroup(names[0])
+		if err != nil {
+			return telegram.Send(user.TelegramID, i18n.Err(user, err))
+		}
+
+		answer(update, "")
+		plugins.AwaitInput(user.TelegramID, command, groupRef(g)+"\n")
+
+		return telegram.Send(user.TelegramID, i18n.T(user, "groups.send_name", "group", g.Name))
+	}
+
 	if len(names) != 2 {
-		return telegram.Send(user.TelegramID, "failed: you must provide the names of the two groups with a new line between them")
+		return telegram.Send(user.TelegramID, errorString)
 	}
 
 	oldName, newName := strings.TrimSpace(names[0]), strings.TrimSpace(names[1])
 
 	if oldName == "" || newName == "" {
-		return telegram.Send(user.TelegramID, "failed: you must provide the names of the two groups with a new line between them")
+		return telegram.Send(user.TelegramID, errorString)
+	}
+
+	g, err := getGroup(oldName)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Err(user, err))
 	}
 
-	rows, err := database.UpdateGroupName(plugins.DB, oldName, newName)
+	rows, err := database.UpdateGroupName(plugins.DB, g.Name, newName)
 	if err != nil {
 		return err
 	}
 
 	if rows != 1 {
-		return telegram.Send(user.TelegramID, "failed")
+		return telegram.Send(user.TelegramID, i18n.T(user, "common.failed"))
 	}
 
-	return telegram.Send(user.TelegramID, "success")
+	plugins.Audit(user, command, groupRef(g), g.Name, newName)
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "common.success"))
 }
 
 var groupDeleteUndelete plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
@@ -124,171 +230,907 @@ var groupDeleteUndelete plugins.CommandCallback = func(update *tgbotapi.Update,
 		newState = database.Deleted
 	}
 
-	group := &database.Group{
-		Name:  args,
-		State: newState,
+	g, err := getGroup(args)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Err(user, err))
 	}
 
-	rows, err := database.UpdateGroupState(plugins.DB, group)
+	before := g.State
+	g.State = newState
+
+	rows, err := database.UpdateGroupState(plugins.DB, g)
 	if err != nil {
 		return err
 	}
 
 	if rows != 1 {
-		return telegram.Send(user.TelegramID, "failed")
+		return telegram.Send(user.TelegramID, i18n.T(user, "common.failed"))
+	}
+
+	plugins.Audit(user, command, g.Name, before, newState)
+
+	if update.CallbackQuery != nil {
+		answer(update, g.Name+" "+newState)
+
+		text, replyKeyboard, err := groupCard(g, user)
+		if err != nil {
+			return err
+		}
+
+		return reply(update, user, text, &replyKeyboard)
 	}
 
-	return telegram.Send(user.TelegramID, "success")
+	return telegram.Send(user.TelegramID, i18n.T(user, "common.success"))
 }
 
-var groupAddGroupChat plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+var groupAddDeleteGroupChat plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	add := command == "groupaddgroupchat"
+
+	state, args := pagination.Parse(args)
+
 	params := strings.Split(args, "\n")
 
-	errorString := "failed: you must provide two lines (group name and groupchat id) with a new line between them"
+	errorString := i18n.T(user, "groups.groupchat_usage")
 
-	if len(params) != 2 {
+	groupName := strings.TrimSpace(params[0])
+	if groupName == "" {
 		return telegram.Send(user.TelegramID, errorString)
 	}
 
-	groupName, groupchatIDstring := strings.TrimSpace(params[0]), strings.TrimSpace(params[1])
+	g, err := getGroup(groupName)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Err(user, err))
+	}
 
-	if groupName == "" || groupchatIDstring == "" {
-		return telegram.Send(user.TelegramID, errorString)
+	// only group provided, choose groupchat from the list
+	if len(params) == 1 {
+		if state.Search {
+			return pagination.AskQuery(update, user, command, args)
+		}
+
+		replyKeyboard, err := groupchatsPicker(g, add, state, user)
+		if err != nil {
+			return err
+		}
+
+		answer(update, "")
+
+		return reply(update, user, i18n.T(user, "groups.choose_groupchat"), &replyKeyboard)
 	}
 
-	groupchatID, err := strconv.ParseInt(groupchatIDstring, 10, 64)
-	if err != nil {
+	if len(params) != 2 {
 		return telegram.Send(user.TelegramID, errorString)
 	}
 
-	group, err := database.GetGroupByName(plugins.DB, &database.Group{Name: groupName})
+	groupchatID, err := strconv.ParseInt(strings.TrimSpace(params[1]), 10, 64)
 	if err != nil {
-		return telegram.Send(user.TelegramID, err.Error())
+		return telegram.Send(user.TelegramID, errorString)
 	}
 
 	groupchat, err := database.GetGroupChatByTelegramID(plugins.DB, &database.Groupchat{TelegramID: groupchatID})
 	if err != nil {
-		return telegram.Send(user.TelegramID, err.Error())
+		return telegram.Send(user.TelegramID, i18n.Err(user, err))
+	}
+
+	if add && !canEditGroups(user) {
+		allowed, err := linkableGroupchats(user)
+		if err != nil {
+			return err
+		}
+
+		if !containsGroupchat(allowed, groupchat) {
+			return telegram.Send(user.TelegramID, i18n.T(user, "groups.foreign_groupchat"))
+		}
 	}
 
-	_, err = database.AddGroupGroupChatIfNotExist(plugins.DB, group, groupchat)
+	if add {
+		_, err = database.AddGroupGroupChatIfNotExist(plugins.DB, g, groupchat)
+	} else {
+		_, err = database.DeleteGroupGroupChat(plugins.DB, g, groupchat)
+	}
 	if err != nil {
-		return telegram.Send(user.TelegramID, err.Error())
+		return telegram.Send(user.TelegramID, i18n.Err(user, err))
 	}
 
-	return telegram.Send(user.TelegramID, "success")
+	before, after := "", "linked"
+	if !add {
+		before, after = after, before
+	}
+
+	plugins.Audit(user, command, groupchat.Label()+" in "+g.Name, before, after)
+
+	if update.CallbackQuery != nil {
+		answer(update, i18n.T(user, "groups.item_success", "name", groupchat.Title))
+
+		replyKeyboard, err := groupchatsPicker(g, add, state, user)
+		if err != nil {
+			return err
+		}
+
+		return reply(update, user, i18n.T(user, "groups.choose_groupchat"), &replyKeyboard)
+	}
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "common.success"))
 }
 
-var groupDeleteGroupChat plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+var groupAddDeleteUser plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+
+	state, args := pagination.Parse(args)
+
 	params := strings.Split(args, "\n")
 
-	errorString := "failed: you must provide two lines (group name and groupchat id) with a new line between them"
+	errorString := i18n.T(user, "groups.user_usage")
 
-	if len(params) != 2 {
+	groupName := strings.TrimSpace(params[0])
+	if groupName == "" {
 		return telegram.Send(user.TelegramID, errorString)
 	}
 
-	groupName, groupchatIDstring := strings.TrimSpace(params[0]), strings.TrimSpace(params[1])
+	g, err := getGroup(groupName)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Err(user, err))
+	}
+
+	// only group provided, choose user from the list
+	if len(params) == 1 {
+		if state.Search {
+			return pagination.AskQuery(update, user, command, args)
+		}
+
+		replyKeyboard, err := usersPicker(g, command, state)
+		if err != nil {
+			return err
+		}
+
+		answer(update, "")
 
-	if groupName == "" || groupchatIDstring == "" {
+		return reply(update, user, i18n.T(user, "common.choose_user"), &replyKeyboard)
+	}
+
+	if len(params) != 2 {
 		return telegram.Send(user.TelegramID, errorString)
 	}
 
-	groupchatID, err := strconv.ParseInt(groupchatIDstring, 10, 64)
+	userFromDB, err := telegram.ResolveUser(update, params[1])
 	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	switch command {
+	case "groupadduser":
+		_, err = database.AddGroupUserIfNotExist(plugins.DB, g, userFromDB)
+	case "groupdeleteuser":
+		_, err = database.DeleteGroupUser(plugins.DB, g, userFromDB)
+	case "groupaddmanager":
+		_, err = database.AddGroupManagerIfNotExist(plugins.DB, g, userFromDB)
+	case "groupdeletemanager":
+		_, err = database.DeleteGroupManager(plugins.DB, g, userFromDB)
+	}
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Err(user, err))
+	}
+
+	before, after := membershipChange(command)
+	plugins.Audit(user, command, userFromDB.String()+" in "+g.Name, before, after)
+
+	if command == "groupadduser" || command == "groupdeleteuser" {
+		plugins.Publish(&plugins.GroupMembershipChanged{Actor: user, User: userFromDB, Group: g, Action: command, Joined: command == "groupadduser"})
+	}
+
+	if update.CallbackQuery != nil {
+		answer(update, i18n.T(user, "groups.item_success", "name", userFromDB.String()))
+
+		replyKeyboard, err := usersPicker(g, command, state)
+		if err != nil {
+			return err
+		}
+
+		return reply(update, user, i18n.T(user, "common.choose_user"), &replyKeyboard)
+	}
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "common.success"))
+}
+
+// membershipChange is membership of user in group before and after command for audit log
+func membershipChange(command string) (before, after string) {
+	switch command {
+	case "groupadduser":
+		return "", "member"
+	case "groupdeleteuser":
+		return "member", ""
+	case "groupaddmanager":
+		return "", "manager"
+	case "groupdeletemanager":
+		return "manager", ""
+	}
+
+	return "", ""
+}
+
+// groupUserExpire sets a date when user leaves group: "-" makes membership permanent, "+N" extends it for N days
+var groupUserExpire plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	state, args := pagination.Parse(args)
+
+	params := strings.Split(args, "\n")
+
+	errorString := i18n.T(user, "groups.expire_usage")
+
+	groupName := strings.TrimSpace(params[0])
+	if groupName == "" {
 		return telegram.Send(user.TelegramID, errorString)
 	}
 
-	group, err := database.GetGroupByName(plugins.DB, &database.Group{Name: groupName})
+	g, err := getGroup(groupName)
 	if err != nil {
-		return telegram.Send(user.TelegramID, err.Error())
+		return telegram.Send(user.TelegramID, i18n.Err(user, err))
 	}
 
-	groupchat, err := database.GetGroupChatByTelegramID(plugins.DB, &database.Groupchat{TelegramID: groupchatID})
+	// only group provided, choose user from the list
+	if len(params) == 1 {
+		if state.Search {
+			return pagination.AskQuery(update, user, command, args)
+		}
+
+		replyKeyboard, err := usersPicker(g, command, state)
+		if err != nil {
+			return err
+		}
+
+		answer(update, "")
+
+		return reply(update, user, i18n.T(user, "common.choose_user"), &replyKeyboard)
+	}
+
+	if len(params) != 2 {
+		return telegram.Send(user.TelegramID, errorString)
+	}
+
+	fields := strings.SplitN(strings.TrimSpace(params[1]), " ", 2)
+
+	userFromDB, err := telegram.ResolveUser(update, fields[0])
 	if err != nil {
-		return telegram.Send(user.TelegramID, err.Error())
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	if !isGroupUser(g, userFromDB) {
+		return telegram.Send(user.TelegramID, i18n.T(user, "groups.not_member", "user", userFromDB.String(), "group", g.Name))
+	}
+
+	before := "-"
+	current, err := database.GetGroupUserExpiry(plugins.DB, g, userFromDB)
+	if err == nil {
+		before = current.Date()
+	}
+
+	value := ""
+	if len(fields) == 2 {
+		value = strings.TrimSpace(fields[1])
+	}
+
+	var expiresAt time.Time
+
+	switch {
+	case value == "-":
+		if _, err = database.DeleteGroupUserExpiry(plugins.DB, g, userFromDB); err != nil {
+			return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+		}
+	case strings.HasPrefix(value, "+"):
+		days, err := strconv.Atoi(strings.TrimPrefix(value, "+"))
+		if err != nil || days <= 0 {
+			return telegram.Send(user.TelegramID, errorString)
+		}
+
+		from := time.Now()
+		if current != nil && current.ExpiresAt.After(from) {
+			from = current.ExpiresAt
+		}
+		expiresAt = from.AddDate(0, 0, days)
+	default:
+		var replyKeyboard tgbotapi.InlineKeyboardMarkup
+		var done bool
+
+		expiresAt, replyKeyboard, done = cal.Pick(expiryPrefix(g, userFromDB), value, i18n.Lang(user))
+		if !done {
+			replyKeyboard.InlineKeyboard = append(replyKeyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "common.permanent"), expiryPrefix(g, userFromDB)+" -"),
+				tgbotapi.NewInlineKeyboardButtonData("« "+g.Name, "/group "+groupRef(g)),
+			))
+
+			answer(update, "")
+
+			return reply(update, user, i18n.T(user, "groups.choose_expiry", "user", userFromDB.String(), "group", g.Name, "date", before), &replyKeyboard)
+		}
+
+		expiresAt = cal.EndOfDay(expiresAt)
+		if expiresAt.Before(time.Now()) {
+			return telegram.Send(user.TelegramID, i18n.T(user, "common.date_passed"))
+		}
+	}
+
+	after := "-"
+	if !expiresAt.IsZero() {
+		if err = database.SetGroupUserExpiry(plugins.DB, g, userFromDB, expiresAt); err != nil {
+			return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+		}
+
+		after = expiresAt.Format("2006.01.02")
+	}
+
+	plugins.Audit(user, command, userFromDB.String()+" in "+g.Name, before, after)
+
+	text := i18n.T(user, "groups.permanent", "user", userFromDB.String(), "group", g.Name)
+	notice := i18n.T(userFromDB, "groups.your_permanent", "group", g.Name)
+	if after != "-" {
+		text = i18n.T(user, "groups.leaves", "user", userFromDB.String(), "group", g.Name, "date", after)
+		notice = i18n.T(userFromDB, "expiry.access_reminder", "group", g.Name, "date", after)
+	}
+
+	if err = telegram.Send(userFromDB.TelegramID, notice); err != nil {
+		dlog.Errorln(err.Error())
 	}
 
-	_, err = database.DeleteGroupGroupChat(plugins.DB, group, groupchat)
+	if update.CallbackQuery != nil {
+		answer(update, i18n.T(user, "common.success"))
+
+		card, replyKeyboard, err := groupCard(g, user)
+		if err != nil {
+			return err
+		}
+
+		return reply(update, user, card, &replyKeyboard)
+	}
+
+	return telegram.Send(user.TelegramID, text)
+}
+
+// expiryPrefix is a callback data prefix of membership expiry calendar
+func expiryPrefix(group *database.Group, user *database.User) string {
+	return "/groupuserexpire " + groupRef(group) + "\n" + strconv.FormatInt(user.TelegramID, 10)
+}
+
+func isGroupUser(group *database.Group, user *database.User) bool {
+	users, err := database.GetUsersByGroupID(plugins.DB, group.ID)
 	if err != nil {
-		return telegram.Send(user.TelegramID, err.Error())
+		dlog.Errorln(err.Error())
+		return false
 	}
 
-	return telegram.Send(user.TelegramID, "success")
+	for _, u := range users {
+		if u.ID == user.ID {
+			return true
+		}
+	}
+
+	return false
 }
 
-var groupAddUser plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+var groupSetParent plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	state, args := pagination.Parse(args)
+
 	params := strings.Split(args, "\n")
 
-	errorString := "failed: you must provide two lines (group name and user id) with a new line between them"
+	errorString := i18n.T(user, "groups.parent_usage")
 
-	if len(params) != 2 {
+	groupName := strings.TrimSpace(params[0])
+	if groupName == "" {
 		return telegram.Send(user.TelegramID, errorString)
 	}
 
-	groupName, userIDstring := strings.TrimSpace(params[0]), strings.TrimSpace(params[1])
+	g, err := getGroup(groupName)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Err(user, err))
+	}
+
+	// only group provided, choose parent from the list
+	if len(params) == 1 {
+		if state.Search {
+			return pagination.AskQuery(update, user, command, args)
+		}
+
+		replyKeyboard, err := parentsPicker(g, state, user)
+		if err != nil {
+			return err
+		}
+
+		answer(update, "")
 
-	if groupName == "" || userIDstring == "" {
+		return reply(update, user, i18n.T(user, "groups.choose_parent", "group", g.Name), &replyKeyboard)
+	}
+
+	if len(params) != 2 {
 		return telegram.Send(user.TelegramID, errorString)
 	}
 
-	userID, err := strconv.ParseInt(userIDstring, 10, 64)
+	var parent *database.Group
+	if parentName := strings.TrimSpace(params[1]); parentName != "-" {
+		parent, err = getGroup(parentName)
+		if err != nil {
+			return telegram.Send(user.TelegramID, i18n.Err(user, err))
+		}
+	}
+
+	before, after := "-", "-"
+	if oldParent, err := database.GetGroupParent(plugins.DB, g); err == nil {
+		before = oldParent.Name
+	}
+	if parent != nil {
+		after = parent.Name
+	}
+
+	_, err = database.SetGroupParent(plugins.DB, g, parent)
 	if err != nil {
-		return telegram.Send(user.TelegramID, errorString)
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	plugins.Audit(user, command, g.Name, before, after)
+
+	if update.CallbackQuery != nil {
+		answer(update, i18n.T(user, "common.success"))
+
+		text, replyKeyboard, err := groupCard(g, user)
+		if err != nil {
+			return err
+		}
+
+		return reply(update, user, text, &replyKeyboard)
+	}
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "common.success"))
+}
+
+var groupTree plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	groups, err := database.GetGroups(plugins.DB, []string{database.Active})
+	if err != nil {
+		return err
+	}
+
+	if len(groups) == 0 {
+		return telegram.Send(user.TelegramID, i18n.T(user, "groups.empty"))
+	}
+
+	parents, err := database.GetGroupParents(plugins.DB)
+	if err != nil {
+		return err
+	}
+
+	children := make(map[int64][]*database.Group)
+	known := make(map[int64]bool)
+	for _, g := range groups {
+		known[g.ID] = true
+	}
+
+	var roots []*database.Group
+	for _, g := range groups {
+		if parentID, ok := parents[g.ID]; ok && known[parentID] {
+			children[parentID] = append(children[parentID], g)
+		} else {
+			roots = append(roots, g)
+		}
+	}
+
+	var b strings.Builder
+
+	var walk func(groups []*database.Group, level int)
+	walk = func(groups []*database.Group, level int) {
+		for _, g := range groups {
+			b.WriteString(strings.Repeat("    ", level) + "* " + g.Name + "\n")
+			walk(children[g.ID], level+1)
+		}
+	}
+	walk(roots, 0)
+
+	return telegram.Send(user.TelegramID, b.String())
+}
+
+var userAccess plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	if args == "" {
+		return telegram.AskUser(update, user, command, "")
+	}
+
+	userFromDB, err := telegram.ResolveUser(update, args)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	groups, err := database.GetGroupsByUserID(plugins.DB, userFromDB.ID)
+	if err != nil {
+		return err
 	}
 
-	group, err := database.GetGroupByName(plugins.DB, &database.Group{Name: groupName})
+	groupchats, err := database.GetEffectiveGroupchatsByUserID(plugins.DB, userFromDB.ID)
 	if err != nil {
-		return telegram.Send(user.TelegramID, err.Error())
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString(userFromDB.String() + "\n\n" + i18n.T(user, "groups.access_groups") + "\n")
+	for _, g := range groups {
+		b.WriteString("• " + g.Name + "\n")
+	}
+
+	b.WriteString("\n" + i18n.T(user, "groups.card_groupchats") + "\n")
+	for _, c := range groupchats {
+		b.WriteString("• " + c.String() + "\n")
+	}
+
+	return telegram.Send(user.TelegramID, b.String())
+}
+
+// managedGroupScope allows group commands to managers of the target group
+var managedGroupScope plugins.ScopeCallback = func(args string, user *database.User) bool {
+	_, args = pagination.Parse(args)
+
+	ref := strings.TrimSpace(strings.Split(args, "\n")[0])
+	if ref == "" {
+		groups, err := database.GetManagedGroupsByUserID(plugins.DB, user.ID)
+		return err == nil && len(groups) > 0
 	}
 
-	userFromDB, err := database.GetUserByTelegramID(plugins.DB, &database.User{TelegramID: userID})
+	g, err := getGroup(ref)
 	if err != nil {
-		return telegram.Send(user.TelegramID, err.Error())
+		return false
 	}
 
-	_, err = database.AddGroupUserIfNotExist(plugins.DB, group, userFromDB)
+	ok, err := database.IsGroupManager(plugins.DB, g, user)
 	if err != nil {
-		return telegram.Send(user.TelegramID, err.Error())
+		dlog.Errorln(err)
 	}
 
-	return telegram.Send(user.TelegramID, "success")
+	return ok
 }
 
-var groupDeleteUser plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
-	params := strings.Split(args, "\n")
+// canEditGroups checks if user can edit any group, not only managed ones
+func canEditGroups(user *database.User) bool {
+	return plugins.HasPermission(user, "groups.edit")
+}
 
-	errorString := "failed: you must provide two lines (group name and user id) with a new line between them"
+// linkableGroupchats returns groupchats user can link to groups, managers can link only chats they are in
+func linkableGroupchats(user *database.User) ([]*database.Groupchat, error) {
+	if canEditGroups(user) {
+		return database.GetGroupchats(plugins.DB, []string{database.Active})
+	}
 
-	if len(params) != 2 {
-		return telegram.Send(user.TelegramID, errorString)
+	groupchats, err := database.GetGroupchatsByMemberTelegramID(plugins.DB, user.TelegramID)
+	if err != nil {
+		return nil, err
 	}
 
-	groupName, userIDstring := strings.TrimSpace(params[0]), strings.TrimSpace(params[1])
+	var result []*database.Groupchat
+	for _, c := range groupchats {
+		if c.State == database.Active {
+			result = append(result, c)
+		}
+	}
 
-	if groupName == "" || userIDstring == "" {
-		return telegram.Send(user.TelegramID, errorString)
+	return result, nil
+}
+
+func containsGroupchat(groupchats []*database.Groupchat, groupchat *database.Groupchat) bool {
+	for _, c := range groupchats {
+		if c.ID == groupchat.ID {
+			return true
+		}
 	}
 
-	userID, err := strconv.ParseInt(userIDstring, 10, 64)
+	return false
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getGroup finds group by name or by "#<id>" reference used in callbacks
+func getGroup(ref string) (*database.Group, error) {
+	ref = strings.TrimSpace(ref)
+
+	if strings.HasPrefix(ref, "#") {
+		if id, err := strconv.ParseInt(strings.TrimPrefix(ref, "#"), 10, 64); err == nil {
+			return database.GetGroupByID(plugins.DB, &database.Group{ID: id})
+		}
+	}
+
+	return database.GetGroupByName(plugins.DB, &database.Group{Name: ref})
+}
+
+// groupRef is a short group reference for callback data, group names may be too long or contain spaces
+func groupRef(group *database.Group) string {
+	return "#" + strconv.FormatInt(group.ID, 10)
+}
+
+func groupCard(group *database.Group, user *database.User) (string, tgbotapi.InlineKeyboardMarkup, error) {
+	var b strings.Builder
+	b.WriteString(i18n.T(user, "groups.card_group", "group", group.Name, "state", group.State) + "\n")
+
+	managers, err := database.GetManagersByGroupID(plugins.DB, group.ID)
 	if err != nil {
-		return telegram.Send(user.TelegramID, errorString)
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+
+	if len(managers) > 0 {
+		b.WriteString("\n" + i18n.T(user, "groups.card_managers") + "\n")
+		for _, u := range managers {
+			b.WriteString("• " + u.String() + "\n")
+		}
+	}
+
+	users, err := database.GetUsersByGroupID(plugins.DB, group.ID)
+	if err != nil {
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+
+	expiries, err := database.GetGroupExpiries(plugins.DB, group)
+	if err != nil {
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+
+	until := make(map[int64]string)
+	for _, e := range expiries {
+		until[e.UserID] = " " + i18n.T(user, "groups.card_until", "date", e.Date())
+	}
+
+	b.WriteString("\n" + i18n.T(user, "groups.card_users") + "\n")
+	for _, u := range users {
+		b.WriteString("• " + u.String() + until[u.ID] + "\n")
+	}
+
+	groupchats, err := database.GetGroupchatsByGroupID(plugins.DB, group.ID)
+	if err != nil {
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+
+	b.WriteString("\n" + i18n.T(user, "groups.card_groupchats") + "\n")
+	own := make(map[int64]bool)
+	for _, c := range groupchats {
+		own[c.ID] = true
+		b.WriteString("• " + c.String() + "\n")
+	}
+
+	inherited, err := database.GetInheritedGroupchatsByGroupID(plugins.DB, group.ID)
+	if err != nil {
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+
+	for _, c := range inherited {
+		if !own[c.ID] {
+			b.WriteString("• " + c.String() + " " + i18n.T(user, "groups.card_inherited") + "\n")
+		}
+	}
+
+	if parent, err := database.GetGroupParent(plugins.DB, group); err == nil {
+		b.WriteString("\n" + i18n.T(user, "groups.card_parent", "group", parent.Name) + "\n")
+	}
+
+	children, err := database.GetGroupChildren(plugins.DB, group)
+	if err != nil {
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+
+	if len(children) > 0 {
+		b.WriteString("\n" + i18n.T(user, "groups.card_children") + "\n")
+		for _, c := range children {
+			b.WriteString("• " + c.Name + "\n")
+		}
+	}
+
+	ref := groupRef(group)
+
+	buttons := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "groups.button_add_user"), "/groupadduser "+ref),
+			tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "groups.button_remove_user"), "/groupdeleteuser "+ref),
+		),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "groups.button_expiry"), "/groupuserexpire "+ref)),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "groups.button_add_groupchat"), "/groupaddgroupchat "+ref),
+			tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "groups.button_remove_groupchat"), "/groupdeletegroupchat "+ref),
+		),
+	}
+
+	if plugins.HasPermission(user, "groups.managers") {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "groups.button_add_manager"), "/groupaddmanager "+ref),
+			tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "groups.button_remove_manager"), "/groupdeletemanager "+ref),
+		))
+	}
+
+	if !canEditGroups(user) {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "groups.button_back"), "/grouplist")))
+
+		return b.String(), tgbotapi.NewInlineKeyboardMarkup(buttons...), nil
+	}
+
+	if group.State == database.Deleted {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "groups.button_rename"), "/grouprename "+ref),
+			tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "groups.button_undelete"), "/groupundelete "+ref),
+		))
+	} else {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "groups.button_rename"), "/grouprename "+ref),
+			tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "groups.button_delete"), "/groupdelete "+ref),
+		))
+	}
+
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "groups.button_set_parent"), "/groupsetparent "+ref)))
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "groups.button_back"), "/grouplist")))
+
+	return b.String(), tgbotapi.NewInlineKeyboardMarkup(buttons...), nil
+}
+
+func groupsKeyboard(groups []*database.Group, args string, state pagination.State) tgbotapi.InlineKeyboardMarkup {
+	items := make([]pagination.Item, 0, len(groups))
+	for _, g := range groups {
+		title := g.Name
+		if g.State != database.Active {
+			title += " (" + g.State + ")"
+		}
+		items = append(items, pagination.Item{Text: title, Data: "/group " + groupRef(g)})
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(pagination.Rows(items, state, pagination.Prefix("/grouplist", args), true)...)
+}
+
+// usersPicker lists users who can be added to group (or its managers) or removed from it
+func usersPicker(group *database.Group, command string, state pagination.State) (tgbotapi.InlineKeyboardMarkup, error) {
+	var groupUsers []*database.User
+	var err error
+
+	if command == "groupaddmanager" || command == "groupdeletemanager" {
+		groupUsers, err = database.GetManagersByGroupID(plugins.DB, group.ID)
+	} else {
+		groupUsers, err = database.GetUsersByGroupID(plugins.DB, group.ID)
+	}
+	if err != nil {
+		return tgbotapi.NewInlineKeyboardMarkup(), err
+	}
+
+	users := groupUsers
+
+	if command == "groupadduser" || command == "groupaddmanager" {
+		roles := []string{}
+		if command == "groupaddmanager" {
+			roles = []string{database.Member, database.Admin, database.Owner}
+		}
+
+		allUsers, err := database.GetUsers(plugins.DB, roles)
+		if err != nil {
+			return tgbotapi.NewInlineKeyboardMarkup(), err
+		}
+
+		inGroup := make(map[int64]bool)
+		for _, u := range groupUsers {
+			inGroup[u.ID] = true
+		}
+
+		users = nil
+		for _, u := range allUsers {
+			if !inGroup[u.ID] {
+				users = append(users, u)
+			}
+		}
+	}
+
+	prefix := pagination.Prefix("/"+command, groupRef(group))
+
+	items := make([]pagination.Item, 0, len(users))
+	for _, u := range users {
+		items = append(items, pagination.Item{Text: u.String(), Data: pagination.Data(prefix+strconv.FormatInt(u.TelegramID, 10)+"\n", state)})
+	}
+
+	buttons := pagination.Rows(items, state, prefix, true)
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("« "+group.Name, "/group "+groupRef(group))))
+
+	return tgbotapi.NewInlineKeyboardMarkup(buttons...), nil
+}
+
+// groupchatsPicker lists groupchats which can be linked to group or unlinked from it
+func groupchatsPicker(group *database.Group, add bool, state pagination.State, user *database.User) (tgbotapi.InlineKeyboardMarkup, error) {
+	groupGroupchats, err := database.GetGroupchatsByGroupID(plugins.DB, group.ID)
+	if err != nil {
+		return tgbotapi.NewInlineKeyboardMarkup(), err
+	}
+
+	command := "/groupdeletegroupchat"
+	groupchats := groupGroupchats
+
+	if add {
+		command = "/groupaddgroupchat"
+
+		allGroupchats, err := linkableGroupchats(user)
+		if err != nil {
+			return tgbotapi.NewInlineKeyboardMarkup(), err
+		}
+
+		inGroup := make(map[int64]bool)
+		for _, c := range groupGroupchats {
+			inGroup[c.ID] = true
+		}
+
+		groupchats = nil
+		for _, c := range allGroupchats {
+			if !inGroup[c.ID] {
+				groupchats = append(groupchats, c)
+			}
+		}
+	}
+
+	prefix := pagination.Prefix(command, groupRef(group))
+
+	items := make([]pagination.Item, 0, len(groupchats))
+	for _, c := range groupchats {
+		items = append(items, pagination.Item{Text: c.Title, Data: pagination.Data(prefix+strconv.FormatInt(c.TelegramID, 10)+"\n", state)})
 	}
 
-	group, err := database.GetGroupByName(plugins.DB, &database.Group{Name: groupName})
+	buttons := pagination.Rows(items, state, prefix, true)
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("« "+group.Name, "/group "+groupRef(group))))
+
+	return tgbotapi.NewInlineKeyboardMarkup(buttons...), nil
+}
+
+// parentsPicker lists groups which can be a parent of group, descendants are excluded to prevent cycles
+func parentsPicker(group *database.Group, state pagination.State, user *database.User) (tgbotapi.InlineKeyboardMarkup, error) {
+	groups, err := database.GetGroups(plugins.DB, []string{database.Active})
 	if err != nil {
-		return telegram.Send(user.TelegramID, err.Error())
+		return tgbotapi.NewInlineKeyboardMarkup(), err
 	}
 
-	userFromDB, err := database.GetUserByTelegramID(plugins.DB, &database.User{TelegramID: userID})
+	descendants, err := database.GetGroupDescendants(plugins.DB, group)
 	if err != nil {
-		return telegram.Send(user.TelegramID, err.Error())
+		return tgbotapi.NewInlineKeyboardMarkup(), err
+	}
+
+	excluded := map[int64]bool{group.ID: true}
+	for _, d := range descendants {
+		excluded[d.ID] = true
+	}
+
+	prefix := pagination.Prefix("/groupsetparent", groupRef(group))
+
+	items := []pagination.Item{{Text: i18n.T(user, "groups.no_parent"), Data: prefix + "-"}}
+	for _, g := range groups {
+		if !excluded[g.ID] {
+			items = append(items, pagination.Item{Text: g.Name, Data: prefix + groupRef(g)})
+		}
 	}
 
-	_, err = database.DeleteGroupUser(plugins.DB, group, userFromDB)
+	buttons := pagination.Rows(items, state, prefix, true)
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("« "+group.Name, "/group "+groupRef(group))))
+
+	return tgbotapi.NewInlineKeyboardMarkup(buttons...), nil
+}
+
+func answer(update *tgbotapi.Update, text string) {
+	if update.CallbackQuery == nil {
+		return
+	}
+
+	_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, text))
 	if err != nil {
-		return telegram.Send(user.TelegramID, err.Error())
+		dlog.Errorln(err.Error())
+	}
+}
+
+// reply edits message with pressed button or sends a new one, callback query must be answered separately
+func reply(update *tgbotapi.Update, user *database.User, text string, replyKeyboard *tgbotapi.InlineKeyboardMarkup) error {
+	if update.CallbackQuery != nil {
+		editKeyboard := tgbotapi.EditMessageTextConfig{
+			BaseEdit: tgbotapi.BaseEdit{
+				ChatID:      update.CallbackQuery.Message.Chat.ID,
+				MessageID:   update.CallbackQuery.Message.MessageID,
+				ReplyMarkup: replyKeyboard,
+			},
+			Text: text,
+		}
+
+		_, err := plugins.Bot.Send(editKeyboard)
+		return err
 	}
 
-	return telegram.Send(user.TelegramID, "success")
+	return telegram.SendCustom(user.TelegramID, 0, text, false, replyKeyboard)
 }
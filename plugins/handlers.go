@@ -0,0 +1,306 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+
+	database "github.com/ad/corpobot/db"
+
+	dlog "github.com/amoghe/distillog"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// Types of updates delivered to handlers
+const (
+	MessageUpdate            = "message"
+	EditedMessageUpdate      = "edited_message"
+	ChannelPostUpdate        = "channel_post"
+	EditedChannelPostUpdate  = "edited_channel_post"
+	CallbackQueryUpdate      = "callback_query"
+	InlineQueryUpdate        = "inline_query"
+	ChosenInlineResultUpdate = "chosen_inline_result"
+)
+
+// Types of message content
+const (
+	TextContent       = "text"
+	PhotoContent      = "photo"
+	DocumentContent   = "document"
+	AudioContent      = "audio"
+	VoiceContent      = "voice"
+	VideoContent      = "video"
+	VideoNoteContent  = "video_note"
+	AnimationContent  = "animation"
+	StickerContent    = "sticker"
+	LocationContent   = "location"
+	VenueContent      = "venue"
+	ContactContent    = "contact"
+	NewMembersContent = "new_chat_members"
+	LeftMemberContent = "left_chat_member"
+	OtherContent      = "other"
+)
+
+// ErrStopPropagation returned by a handler keeps the update from handlers after it
+var ErrStopPropagation = errors.New("stop propagation")
+
+// HandlerCallback gets an update which is not a command, user is nil for channel posts
+type HandlerCallback func(update *tgbotapi.Update, user *database.User) error
+
+// Handler gets updates which are not commands, empty filters match everything, except update types: without them
+// handler gets new messages only
+type Handler struct {
+	// UpdateTypes like MessageUpdate or InlineQueryUpdate
+	UpdateTypes []string
+	// ChatTypes are "private", "group", "supergroup" and "channel", inline queries have no chat and don't match them
+	ChatTypes []string
+	// ContentTypes like TextContent or PhotoContent, checked for messages only
+	ContentTypes []string
+	// Pattern matches text or caption of message, data of callback query or inline query text
+	Pattern *regexp.Regexp
+	// Permission of user, empty permission allows handler for everyone, including channel posts
+	Permission string
+	// Order of handlers, lower goes first, handlers of the same order are called in order of registration
+	Order    int
+	Callback HandlerCallback
+	Plugin   string
+}
+
+var (
+	handlersMu sync.RWMutex
+	handlers   []Handler
+)
+
+// RegisterHandler adds a handler of updates, a handler registered in OnStart belongs to the starting plugin and is
+// removed when the plugin stops
+func RegisterHandler(h Handler) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+
+	h.Plugin = current
+	handlers = append(handlers, h)
+
+	// stable sort keeps registration order within the same order
+	sort.SliceStable(handlers, func(i, j int) bool {
+		return handlers[i].Order < handlers[j].Order
+	})
+}
+
+// UnregisterHandlers removes handlers of plugin
+func UnregisterHandlers(plugin string) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+
+	kept := make([]Handler, 0, len(handlers))
+	for _, h := range handlers {
+		if h.Plugin != plugin {
+			kept = append(kept, h)
+		}
+	}
+
+	handlers = kept
+}
+
+// HandleUpdate passes update to matching handlers in their order until one of them returns ErrStopPropagation,
+// reports if any handler got the update
+func HandleUpdate(update *tgbotapi.Update, user *database.User) bool {
+	handlersMu.RLock()
+	matching := make([]Handler, 0, len(handlers))
+	for _, h := range handlers {
+		if h.Matches(update, user) {
+			matching = append(matching, h)
+		}
+	}
+	handlersMu.RUnlock()
+
+	for _, h := range matching {
+		if err := callHandler(h, update, user); err != nil {
+			if err == ErrStopPropagation {
+				break
+			}
+			dlog.Errorf("[%s] handler failed: %s", h.Plugin, err)
+		}
+	}
+
+	return len(matching) > 0
+}
+
+func callHandler(h Handler, update *tgbotapi.Update, user *database.User) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return h.Callback(update, user)
+}
+
+// Matches checks update against filters and permission of handler
+func (h Handler) Matches(update *tgbotapi.Update, user *database.User) bool {
+	updateType := UpdateType(update)
+
+	if !contains(h.UpdateTypes, updateType) && !(len(h.UpdateTypes) == 0 && updateType == MessageUpdate) {
+		return false
+	}
+
+	if len(h.ChatTypes) > 0 {
+		chat := UpdateChat(update)
+		if chat == nil || !contains(h.ChatTypes, chat.Type) {
+			return false
+		}
+	}
+
+	if len(h.ContentTypes) > 0 {
+		message := UpdateMessage(update)
+		if message == nil || !contains(h.ContentTypes, ContentType(message)) {
+			return false
+		}
+	}
+
+	if h.Pattern != nil && !h.Pattern.MatchString(UpdateText(update)) {
+		return false
+	}
+
+	if user == nil {
+		return h.Permission == ""
+	}
+
+	return HasPermission(user, h.Permission)
+}
+
+// UpdateType returns type of update, empty for updates unknown to handlers
+func UpdateType(update *tgbotapi.Update) string {
+	switch {
+	case update.Message != nil:
+		return MessageUpdate
+	case update.EditedMessage != nil:
+		return EditedMessageUpdate
+	case update.ChannelPost != nil:
+		return ChannelPostUpdate
+	case update.EditedChannelPost != nil:
+		return EditedChannelPostUpdate
+	case update.CallbackQuery != nil:
+		return CallbackQueryUpdate
+	case update.InlineQuery != nil:
+		return InlineQueryUpdate
+	case update.ChosenInlineResult != nil:
+		return ChosenInlineResultUpdate
+	}
+
+	return ""
+}
+
+// UpdateMessage returns message of update, a callback query returns the message with its button
+func UpdateMessage(update *tgbotapi.Update) *tgbotapi.Message {
+	switch {
+	case update.Message != nil:
+		return update.Message
+	case update.EditedMessage != nil:
+		return update.EditedMessage
+	case update.ChannelPost != nil:
+		return update.ChannelPost
+	case update.EditedChannelPost != nil:
+		return update.EditedChannelPost
+	case update.CallbackQuery != nil:
+		return update.CallbackQuery.Message
+	}
+
+	return nil
+}
+
+// UpdateChat returns chat of update, nil for inline queries
+func UpdateChat(update *tgbotapi.Update) *tgbotapi.Chat {
+	if message := UpdateMessage(update); message != nil {
+		return message.Chat
+	}
+
+	return nil
+}
+
+// UpdateSender returns user who sent update, nil for channel posts
+func UpdateSender(update *tgbotapi.Update) *tgbotapi.User {
+	switch {
+	case update.Message != nil:
+		return update.Message.From
+	case update.EditedMessage != nil:
+		return update.EditedMessage.From
+	case update.CallbackQuery != nil:
+		return update.CallbackQuery.From
+	case update.InlineQuery != nil:
+		return update.InlineQuery.From
+	case update.ChosenInlineResult != nil:
+		return update.ChosenInlineResult.From
+	}
+
+	return nil
+}
+
+// UpdateText returns text or caption of message, data of callback query or text of inline query
+func UpdateText(update *tgbotapi.Update) string {
+	switch {
+	case update.CallbackQuery != nil:
+		return update.CallbackQuery.Data
+	case update.InlineQuery != nil:
+		return update.InlineQuery.Query
+	case update.ChosenInlineResult != nil:
+		return update.ChosenInlineResult.Query
+	}
+
+	if message := UpdateMessage(update); message != nil {
+		if message.Text != "" {
+			return message.Text
+		}
+		return message.Caption
+	}
+
+	return ""
+}
+
+// ContentType returns type of message content
+func ContentType(message *tgbotapi.Message) string {
+	switch {
+	case message.Text != "":
+		return TextContent
+	case message.Photo != nil:
+		return PhotoContent
+	case message.Audio != nil:
+		return AudioContent
+	case message.Voice != nil:
+		return VoiceContent
+	case message.Video != nil:
+		return VideoContent
+	case message.VideoNote != nil:
+		return VideoNoteContent
+	// an animation is a document too
+	case message.Animation != nil:
+		return AnimationContent
+	case message.Document != nil:
+		return DocumentContent
+	case message.Sticker != nil:
+		return StickerContent
+	case message.Venue != nil:
+		return VenueContent
+	case message.Location != nil:
+		return LocationContent
+	case message.Contact != nil:
+		return ContactContent
+	case message.NewChatMembers != nil:
+		return NewMembersContent
+	case message.LeftChatMember != nil:
+		return LeftMemberContent
+	}
+
+	return OtherContent
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
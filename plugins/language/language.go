@@ -0,0 +1,90 @@
+package language
+
+import (
+	"strings"
+
+	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
+	"github.com/ad/corpobot/plugins"
+	"github.com/ad/corpobot/telegram"
+
+	dlog "github.com/amoghe/distillog"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+type Plugin struct{}
+
+// auto resets language to the one of Telegram client
+const auto = "auto"
+
+func init() {
+	plugins.RegisterPlugin(&Plugin{})
+}
+
+// Meta ...
+func (m *Plugin) Meta() plugins.Meta {
+	return plugins.Meta{
+		Version: "1.0",
+	}
+}
+
+func (m *Plugin) OnStart() {
+	plugins.RegisterCommand("language", "Choose bot language", "", language)
+}
+
+func (m *Plugin) OnStop() {
+	dlog.Debugln("[language.Plugin] Stopped")
+
+	plugins.UnregisterCommand("language")
+}
+
+var language plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	lang := strings.ToLower(strings.TrimSpace(args))
+
+	if lang == "" {
+		buttons := make([][]tgbotapi.InlineKeyboardButton, 0)
+		for _, l := range i18n.Languages() {
+			buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(i18n.Translate(l, "language.name"), "/language "+l)))
+		}
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "language.auto"), "/language "+auto)))
+
+		replyKeyboard := tgbotapi.NewInlineKeyboardMarkup(buttons...)
+
+		return telegram.SendCustom(user.TelegramID, 0, i18n.T(user, "language.choose", "language", i18n.T(user, "language.name")), false, &replyKeyboard)
+	}
+
+	if lang == auto {
+		lang = ""
+	} else if !contains(i18n.Languages(), lang) {
+		return telegram.Send(user.TelegramID, i18n.T(user, "language.unknown", "language", lang, "languages", strings.Join(i18n.Languages(), ", ")))
+	}
+
+	user.Language = lang
+	if _, err := database.UpdateUserLanguage(plugins.DB, user); err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	text := i18n.T(user, "language.changed", "language", i18n.T(user, "language.name"))
+
+	if update.CallbackQuery != nil {
+		_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, ""))
+		if err != nil {
+			dlog.Errorln(err.Error())
+		}
+
+		_, err = plugins.Bot.Send(tgbotapi.NewEditMessageText(update.CallbackQuery.Message.Chat.ID, update.CallbackQuery.Message.MessageID, text))
+		return err
+	}
+
+	return telegram.Send(user.TelegramID, text)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
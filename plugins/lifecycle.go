@@ -0,0 +1,369 @@
+package plugins
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
+
+	dlog "github.com/amoghe/distillog"
+)
+
+// Meta describes plugin, the name of plugin is its type (e.g. "echo.Plugin"). Dependencies are started first and
+// the plugin fails if one of them is not running
+type Meta struct {
+	Version      string
+	Dependencies []string
+	Settings     []Setting
+}
+
+// MetaPlugin is implemented by plugins which declare their metadata
+type MetaPlugin interface {
+	Meta() Meta
+}
+
+// Status is a lifecycle state of plugin
+type Status string
+
+const (
+	Starting Status = "starting"
+	Running  Status = "running"
+	Failed   Status = "failed"
+	Stopped  Status = "stopped"
+)
+
+// State of plugin, Error is set for failed ones
+type State struct {
+	Status Status
+	Error  error
+}
+
+var (
+	states sync.Map
+
+	// lifecycle makes plugins start and stop one by one
+	lifecycle sync.Mutex
+
+	// current is a plugin whose OnStart or OnStop is running, commands registered meanwhile belong to it
+	current string
+
+	// startErrors are errors of plugin which happened while it was starting, e.g. command conflicts
+	startErrors sync.Map
+)
+
+// MetaOf returns metadata of plugin, plugins without it have no dependencies
+func MetaOf(p TelegramPlugin) Meta {
+	if m, ok := p.(MetaPlugin); ok {
+		return m.Meta()
+	}
+
+	return Meta{}
+}
+
+// PluginState ...
+func PluginState(name string) State {
+	if v, ok := states.Load(name); ok {
+		return v.(State)
+	}
+
+	return State{Status: Stopped}
+}
+
+// IsRunning ...
+func IsRunning(name string) bool {
+	return PluginState(name).Status == Running
+}
+
+// StartAll starts registered plugins in order of their dependencies, plugins disabled in the database are only
+// declared, their settings still may be changed
+func StartAll() {
+	lifecycle.Lock()
+	defer lifecycle.Unlock()
+
+	all := make(map[string]TelegramPlugin)
+	Plugins.Range(func(k, v interface{}) bool {
+		all[k.(string)] = v.(TelegramPlugin)
+		return true
+	})
+
+	metas := make(map[string]Meta, len(all))
+	for name, p := range all {
+		metas[name] = MetaOf(p)
+		if len(metas[name].Settings) > 0 {
+			RegisterSettings(name, metas[name].Settings...)
+		}
+	}
+
+	order, cyclic := sortByDependencies(metas)
+	inCycle := make(map[string]bool, len(cyclic))
+	for _, name := range cyclic {
+		inCycle[name] = true
+	}
+
+	for _, name := range append(order, cyclic...) {
+		plugin, err := database.AddPluginIfNotExist(DB, &database.Plugin{Name: name, State: "enabled"})
+		if err != nil {
+			dlog.Errorln("failed: " + err.Error())
+			continue
+		}
+
+		if !plugin.IsEnabled() {
+			DisabledPlugins.Store(name, all[name])
+			Plugins.Delete(name)
+			setState(name, Stopped, nil)
+			dlog.Debugln("[" + name + "] Disabled")
+			continue
+		}
+
+		if inCycle[name] {
+			setState(name, Failed, i18n.NewError("plugins.cycle"))
+			dlog.Errorf("[%s] failed: dependency cycle", name)
+			continue
+		}
+
+		if err := start(name, all[name]); err != nil {
+			dlog.Errorf("[%s] failed: %s", name, err)
+		}
+	}
+}
+
+// EnablePlugin starts a disabled or failed plugin, a plugin which failed to start stays disabled
+func EnablePlugin(name string) error {
+	lifecycle.Lock()
+	defer lifecycle.Unlock()
+
+	p, ok := DisabledPlugins.Load(name)
+	if !ok {
+		if p, ok = Plugins.Load(name); !ok {
+			return i18n.NewError("plugins.not_found", "plugin", name)
+		}
+
+		if IsRunning(name) {
+			return nil
+		}
+	}
+
+	if err := start(name, p.(TelegramPlugin)); err != nil {
+		// keep it disabled, the state shows why
+		DisabledPlugins.Store(name, p)
+		Plugins.Delete(name)
+		return err
+	}
+
+	DisabledPlugins.Delete(name)
+	Plugins.Store(name, p)
+
+	return nil
+}
+
+// DisablePlugin stops plugin unless other running plugins depend on it
+func DisablePlugin(name string) error {
+	lifecycle.Lock()
+	defer lifecycle.Unlock()
+
+	v, ok := Plugins.Load(name)
+	if !ok {
+		if _, ok = DisabledPlugins.Load(name); ok {
+			return nil
+		}
+		return i18n.NewError("plugins.not_found", "plugin", name)
+	}
+
+	if dependents := runningDependents(name); len(dependents) > 0 {
+		return i18n.NewError("plugins.required_by", "plugin", name, "dependents", strings.Join(dependents, ", "))
+	}
+
+	p := v.(TelegramPlugin)
+	if IsRunning(name) {
+		if err := call(name, p.OnStop); err != nil {
+			dlog.Errorf("[%s] stop failed: %s", name, err)
+		}
+		Unsubscribe(name)
+		UnregisterHandlers(name)
+	}
+
+	DisabledPlugins.Store(name, p)
+	Plugins.Delete(name)
+	setState(name, Stopped, nil)
+
+	dlog.Debugln(name + " removed from running plugins")
+
+	return nil
+}
+
+// start runs OnStart of plugin, plugin fails if a dependency is not running, OnStart panics or registers a command
+// of another plugin, in that case OnStop cleans up what was registered and event subscriptions and handlers are removed
+func start(name string, p TelegramPlugin) error {
+	for _, dependency := range MetaOf(p).Dependencies {
+		if !IsRunning(dependency) {
+			err := i18n.NewError("plugins.dependency", "dependency", dependency)
+			setState(name, Failed, err)
+			return err
+		}
+	}
+
+	setState(name, Starting, nil)
+	startErrors.Delete(name)
+
+	err := call(name, p.OnStart)
+	if err == nil {
+		if v, ok := startErrors.Load(name); ok {
+			err = v.(error)
+		}
+	}
+
+	if err != nil {
+		if errStop := call(name, p.OnStop); errStop != nil {
+			dlog.Errorf("[%s] stop failed: %s", name, errStop)
+		}
+		Unsubscribe(name)
+		UnregisterHandlers(name)
+
+		setState(name, Failed, err)
+		return err
+	}
+
+	setState(name, Running, nil)
+	dlog.Debugln("[" + name + "] Started")
+
+	return nil
+}
+
+// call runs a lifecycle callback of plugin, a panic is returned as an error
+func call(name string, callback func()) (err error) {
+	current = name
+	defer func() {
+		current = ""
+
+		if r := recover(); r != nil {
+			err = i18n.NewError("plugins.panic", "error", fmt.Sprint(r))
+		}
+	}()
+
+	callback()
+
+	return nil
+}
+
+// Fail makes a starting plugin fail after its OnStart, or marks a running one failed, e.g. when its process exits
+func Fail(name string, err error) {
+	switch PluginState(name).Status {
+	case Starting:
+		startErrors.LoadOrStore(name, err)
+	case Running:
+		setState(name, Failed, err)
+		dlog.Errorf("[%s] failed: %s", name, err)
+	}
+}
+
+func setState(name string, status Status, err error) {
+	states.Store(name, State{Status: status, Error: err})
+}
+
+// runningDependents are running plugins which depend on plugin
+func runningDependents(name string) []string {
+	var dependents []string
+
+	Plugins.Range(func(k, v interface{}) bool {
+		for _, dependency := range MetaOf(v.(TelegramPlugin)).Dependencies {
+			if dependency == name && IsRunning(k.(string)) {
+				dependents = append(dependents, k.(string))
+			}
+		}
+		return true
+	})
+	sort.Strings(dependents)
+
+	return dependents
+}
+
+// sortByDependencies orders plugins so that dependencies go first, plugins of the same level are sorted by name.
+// Plugins in a dependency cycle are returned separately, plugins depending on a cycle go last and fail on start
+func sortByDependencies(metas map[string]Meta) (order, cyclic []string) {
+	pending := make(map[string]int, len(metas))
+	dependents := make(map[string][]string)
+
+	for name, meta := range metas {
+		if _, ok := pending[name]; !ok {
+			pending[name] = 0
+		}
+
+		for _, dependency := range meta.Dependencies {
+			// unknown dependencies fail the plugin on start
+			if _, ok := metas[dependency]; !ok {
+				continue
+			}
+
+			pending[name]++
+			dependents[dependency] = append(dependents[dependency], name)
+		}
+	}
+
+	var level []string
+	for name, n := range pending {
+		if n == 0 {
+			level = append(level, name)
+		}
+	}
+
+	for len(level) > 0 {
+		sort.Strings(level)
+		order = append(order, level...)
+
+		var next []string
+		for _, name := range level {
+			delete(pending, name)
+
+			for _, dependent := range dependents[name] {
+				pending[dependent]--
+				if pending[dependent] == 0 {
+					next = append(next, dependent)
+				}
+			}
+		}
+		level = next
+	}
+
+	var blocked []string
+	for name := range pending {
+		if inCycle(name, metas, pending) {
+			cyclic = append(cyclic, name)
+		} else {
+			blocked = append(blocked, name)
+		}
+	}
+	sort.Strings(cyclic)
+	sort.Strings(blocked)
+
+	return append(order, blocked...), cyclic
+}
+
+// inCycle checks if plugin depends on itself through plugins left after sorting
+func inCycle(name string, metas map[string]Meta, left map[string]int) bool {
+	visited := make(map[string]bool)
+
+	var reaches func(from string) bool
+	reaches = func(from string) bool {
+		for _, dependency := range metas[from].Dependencies {
+			if _, ok := left[dependency]; !ok {
+				continue
+			}
+			if dependency == name {
+				return true
+			}
+			if !visited[dependency] {
+				visited[dependency] = true
+				if reaches(dependency) {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+
+	return reaches(name)
+}
@@ -0,0 +1,89 @@
+package plugins
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSortByDependencies(t *testing.T) {
+	tests := []struct {
+		name   string
+		metas  map[string]Meta
+		order  []string
+		cyclic []string
+	}{
+		{
+			name:  "no dependencies are sorted by name",
+			metas: map[string]Meta{"c": {}, "a": {}, "b": {}},
+			order: []string{"a", "b", "c"},
+		},
+		{
+			name: "chain starts from the last dependency",
+			metas: map[string]Meta{
+				"a": {Dependencies: []string{"b"}},
+				"b": {Dependencies: []string{"c"}},
+				"c": {},
+			},
+			order: []string{"c", "b", "a"},
+		},
+		{
+			name: "same level is sorted by name",
+			metas: map[string]Meta{
+				"z":    {},
+				"a":    {},
+				"b":    {Dependencies: []string{"a"}},
+				"both": {Dependencies: []string{"a", "z"}},
+			},
+			order: []string{"a", "z", "b", "both"},
+		},
+		{
+			name: "unknown dependency is left to start",
+			metas: map[string]Meta{
+				"b": {Dependencies: []string{"missing"}},
+				"a": {},
+			},
+			order: []string{"a", "b"},
+		},
+		{
+			name: "cycle is returned separately",
+			metas: map[string]Meta{
+				"a": {Dependencies: []string{"b"}},
+				"b": {Dependencies: []string{"a"}},
+				"c": {},
+			},
+			order:  []string{"c"},
+			cyclic: []string{"a", "b"},
+		},
+		{
+			name: "self dependency is a cycle",
+			metas: map[string]Meta{
+				"a": {Dependencies: []string{"a"}},
+			},
+			cyclic: []string{"a"},
+		},
+		{
+			name: "dependent of cycle goes last",
+			metas: map[string]Meta{
+				"a": {Dependencies: []string{"b"}},
+				"b": {Dependencies: []string{"a"}},
+				"c": {Dependencies: []string{"a"}},
+				"d": {},
+			},
+			order:  []string{"d", "c"},
+			cyclic: []string{"a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, cyclic := sortByDependencies(tt.metas)
+
+			if !reflect.DeepEqual(order, tt.order) {
+				t.Errorf("order = %v, want %v", order, tt.order)
+			}
+			if !reflect.DeepEqual(cyclic, tt.cyclic) {
+				t.Errorf("cyclic = %v, want %v", cyclic, tt.cyclic)
+			}
+		})
+	}
+}
@@ -1,9 +1,8 @@
 package me
 
 import (
-	"fmt"
-
 	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
 	"github.com/ad/corpobot/plugins"
 	"github.com/ad/corpobot/telegram"
 
@@ -17,12 +16,17 @@ func init() {
 	plugins.RegisterPlugin(&Plugin{})
 }
 
-func (m *Plugin) OnStart() {
-	if !plugins.CheckIfPluginDisabled("me.Plugin", "enabled") {
-		return
+// Meta ...
+func (m *Plugin) Meta() plugins.Meta {
+	return plugins.Meta{
+		Version: "1.0",
 	}
+}
 
-	plugins.RegisterCommand("me", "Your ID/username", []string{database.New, database.Member, database.Admin, database.Owner}, me)
+func (m *Plugin) OnStart() {
+	plugins.RegisterPermission("users.me", "Show own ID", database.New, database.Member, database.Admin, database.Owner)
+
+	plugins.RegisterChatCommand("me", "Your ID/username", "users.me", plugins.AnyChats, me)
 }
 
 func (m *Plugin) OnStop() {
@@ -32,6 +36,9 @@ func (m *Plugin) OnStop() {
 }
 
 var me plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
-	msg := fmt.Sprintf("Hello %s, your ID: %d", user.UserName, user.TelegramID)
-	return telegram.Send(user.TelegramID, msg)
+	msg := i18n.T(user, "me.hello", "name", user.UserName, "id", user.TelegramID)
+	if _, ok := plugins.Commands.Load("mychats"); ok && plugins.HasPermission(user, "groupchats.my") {
+		msg += "\n" + i18n.T(user, "me.mychats_hint")
+	}
+	return telegram.Reply(update, user, msg)
 }
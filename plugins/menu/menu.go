@@ -0,0 +1,293 @@
+package menu
+
+import (
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
+	"github.com/ad/corpobot/plugins"
+
+	dlog "github.com/amoghe/distillog"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// Plugin publishes commands to the "/" menu of Telegram clients with setMyCommands: a member list for all private
+// chats and groupchats in each language, and personal lists for users of other roles, e.g. admins and owner
+type Plugin struct{}
+
+const (
+	// limits of Telegram
+	maxCommands    = 100
+	maxDescription = 256
+)
+
+var (
+	commandRe = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+	// refreshDelay collects bursts of changes, e.g. commands registered on start, into one refresh
+	refreshDelay = 2 * time.Second
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	started bool
+
+	// published lists by scope and language, unchanged lists are not sent again
+	published = make(map[string]publication)
+)
+
+type botCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
+type scope struct {
+	Type   string `json:"type"`
+	ChatID int64  `json:"chat_id,omitempty"`
+}
+
+type publication struct {
+	scope    scope
+	language string
+	commands string
+}
+
+func init() {
+	plugins.RegisterPlugin(&Plugin{})
+}
+
+// Meta ...
+func (m *Plugin) Meta() plugins.Meta {
+	return plugins.Meta{
+		Version: "1.0",
+	}
+}
+
+func (m *Plugin) OnStart() {
+	plugins.Subscribe(plugins.CommandsChangedEvent, schedule)
+	plugins.Subscribe(plugins.RoleChangedEvent, schedule)
+
+	// permissions of roles and group managers change what users may run
+	plugins.Subscribe(plugins.AuditEvent, schedule)
+
+	mu.Lock()
+	started = true
+	mu.Unlock()
+
+	schedule(nil)
+}
+
+func (m *Plugin) OnStop() {
+	dlog.Debugln("[menu.Plugin] Stopped")
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	started = false
+	if timer != nil {
+		timer.Stop()
+		timer = nil
+	}
+
+	for key, p := range published {
+		if err := deleteCommands(p.scope, p.language); err != nil {
+			dlog.Errorln(err)
+		}
+		delete(published, key)
+	}
+}
+
+// schedule refreshes menu after refreshDelay without changes
+func schedule(plugins.Event) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if timer != nil {
+		timer.Stop()
+	}
+
+	timer = time.AfterFunc(refreshDelay, refresh)
+}
+
+func refresh() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	// a refresh may fire while plugin stops
+	if !started || plugins.Bot == nil {
+		return
+	}
+
+	private := &tgbotapi.Chat{Type: "private"}
+	group := &tgbotapi.Chat{Type: "group"}
+
+	// default lists have no language, the other languages get their own
+	for _, language := range append([]string{""}, i18n.Languages()...) {
+		if language == i18n.DefaultLanguage {
+			continue
+		}
+
+		member := &database.User{Role: database.Member, Language: language}
+
+		publish(scope{Type: "all_private_chats"}, language, commands(member, private))
+		publish(scope{Type: "all_group_chats"}, language, commands(member, group))
+	}
+
+	users, err := database.GetUsers(plugins.DB, nil)
+	if err != nil {
+		dlog.Errorln(err)
+		return
+	}
+
+	personal := make(map[string]bool)
+	for _, user := range users {
+		if !hasPersonalMenu(user) {
+			continue
+		}
+
+		s := scope{Type: "chat", ChatID: user.TelegramID}
+		personal[key(s, "")] = true
+
+		publish(s, "", commands(user, private))
+	}
+
+	// users who lost their role get the member list
+	for k, p := range published {
+		if p.scope.Type == "chat" && !personal[k] {
+			if err := deleteCommands(p.scope, p.language); err != nil {
+				dlog.Errorln(err)
+				continue
+			}
+			delete(published, k)
+		}
+	}
+}
+
+// hasPersonalMenu checks if user has a role which may run other commands than members
+func hasPersonalMenu(user *database.User) bool {
+	switch user.Role {
+	case database.Member, database.New, database.Blocked, database.Deleted:
+		return false
+	}
+
+	return true
+}
+
+// commands lists commands allowed for role of user in chat, sorted by name, in language of user
+func commands(user *database.User, chat *tgbotapi.Chat) []botCommand {
+	var list []botCommand
+
+	plugins.Commands.Range(func(k, v interface{}) bool {
+		name, cmd := k.(string), v.(plugins.Command)
+		if !commandRe.MatchString(name) || !cmd.RunsIn(chat) || !plugins.HasPermission(user, cmd.Permission) {
+			return true
+		}
+
+		description := plugins.CommandDescription(user, name, cmd)
+		if description == "" {
+			description = name
+		}
+		if utf8.RuneCountInString(description) > maxDescription {
+			description = string([]rune(description)[:maxDescription-1]) + "…"
+		}
+
+		list = append(list, botCommand{Command: name, Description: description})
+
+		return true
+	})
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Command < list[j].Command
+	})
+
+	if len(list) > maxCommands {
+		list = list[:maxCommands]
+	}
+
+	return list
+}
+
+// publish sends list unless it's already published, an empty list deletes the published one
+func publish(s scope, language string, list []botCommand) {
+	k := key(s, language)
+
+	if len(list) == 0 {
+		if _, ok := published[k]; !ok {
+			return
+		}
+		if err := deleteCommands(s, language); err != nil {
+			dlog.Errorln(err)
+			return
+		}
+		delete(published, k)
+		return
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		dlog.Errorln(err)
+		return
+	}
+
+	if p, ok := published[k]; ok && p.commands == string(data) {
+		return
+	}
+
+	v, err := values(s, language)
+	if err != nil {
+		dlog.Errorln(err)
+		return
+	}
+	v.Add("commands", string(data))
+
+	if _, err = plugins.Bot.MakeRequest("setMyCommands", v); err != nil {
+		dlog.Errorf("setMyCommands for %s failed: %s", k, err)
+		return
+	}
+
+	published[k] = publication{scope: s, language: language, commands: string(data)}
+}
+
+func deleteCommands(s scope, language string) error {
+	v, err := values(s, language)
+	if err != nil {
+		return err
+	}
+
+	_, err = plugins.Bot.MakeRequest("deleteMyCommands", v)
+
+	return err
+}
+
+func values(s scope, language string) (url.Values, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	v := url.Values{}
+	v.Add("scope", string(data))
+	if language != "" {
+		v.Add("language_code", language)
+	}
+
+	return v, nil
+}
+
+func key(s scope, language string) string {
+	k := s.Type
+	if s.ChatID != 0 {
+		k += ":" + strconv.FormatInt(s.ChatID, 10)
+	}
+	if language != "" {
+		k += "/" + language
+	}
+
+	return k
+}
@@ -3,10 +3,10 @@ package messages
 // https://core.telegram.org/bots/api#deletemessage
 
 import (
-	"strconv"
 	"strings"
 
 	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
 	"github.com/ad/corpobot/plugins"
 	"github.com/ad/corpobot/telegram"
 
@@ -20,13 +20,19 @@ func init() {
 	plugins.RegisterPlugin(&Plugin{})
 }
 
-func (m *Plugin) OnStart() {
-	if !plugins.CheckIfPluginDisabled("messages.Plugin", "enabled") {
-		return
+// Meta ...
+func (m *Plugin) Meta() plugins.Meta {
+	return plugins.Meta{
+		Version: "1.0",
 	}
+}
 
-	plugins.RegisterCommand("broadcast", "Send message to all users", []string{database.Admin, database.Owner}, broadcast)
-	plugins.RegisterCommand("message", "Send message to user", []string{database.Admin, database.Owner}, message)
+func (m *Plugin) OnStart() {
+	plugins.RegisterPermission("messages.broadcast", "Send message to all users", database.Admin, database.Owner)
+	plugins.RegisterPermission("messages.send", "Send message to user", database.Admin, database.Owner)
+
+	plugins.RegisterCommand("broadcast", "Send message to all users", "messages.broadcast", broadcast)
+	plugins.RegisterCommand("message", "Send message to user", "messages.send", message)
 }
 
 func (m *Plugin) OnStop() {
@@ -38,12 +44,12 @@ func (m *Plugin) OnStop() {
 
 var broadcast plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
 	if args == "" {
-		return telegram.Send(user.TelegramID, "failed: empty message")
+		return telegram.Send(user.TelegramID, i18n.T(user, "messages.empty"))
 	}
 
 	users, err := database.GetUsers(plugins.DB, []string{})
 	if err != nil {
-		return telegram.Send(user.TelegramID, err.Error())
+		return telegram.Send(user.TelegramID, i18n.Err(user, err))
 	}
 
 	if len(users) > 0 {
@@ -52,24 +58,24 @@ var broadcast plugins.CommandCallback = func(update *tgbotapi.Update, command, a
 		for _, u := range users {
 			err = telegram.Send(u.TelegramID, args)
 			if err != nil {
-				usersList = append(usersList, "* "+u.String()+" — failed: "+err.Error())
+				usersList = append(usersList, "* "+u.String()+" — "+i18n.Failed(user, err))
 			} else {
-				usersList = append(usersList, "* "+u.String()+" — success")
+				usersList = append(usersList, "* "+u.String()+" — "+i18n.T(user, "common.success"))
 			}
 		}
 
 		return telegram.Send(user.TelegramID, strings.Join(usersList, "\n"))
 	}
 
-	return telegram.Send(user.TelegramID, args+" broadcast")
+	return telegram.Send(user.TelegramID, i18n.T(user, "messages.broadcast", "message", args))
 }
 
 var message plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
-	errorString := "failed: you must provide user id message with a new line between them"
+	errorString := i18n.T(user, "messages.usage")
 	params := strings.Split(args, "\n")
 
 	if len(params) != 2 {
-		return telegram.Send(user.TelegramID, "failed: empty message")
+		return telegram.Send(user.TelegramID, i18n.T(user, "messages.empty"))
 	}
 
 	userIDstring, message := strings.TrimSpace(params[0]), strings.TrimSpace(params[1])
@@ -78,15 +84,15 @@ var message plugins.CommandCallback = func(update *tgbotapi.Update, command, arg
 		return telegram.Send(user.TelegramID, errorString)
 	}
 
-	userID, err := strconv.ParseInt(userIDstring, 10, 64)
+	recipient, err := telegram.ResolveUser(update, userIDstring)
 	if err != nil {
-		return telegram.Send(user.TelegramID, errorString)
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
 	}
 
-	err = telegram.Send(userID, message)
+	err = telegram.Send(recipient.TelegramID, message)
 	if err != nil {
-		return telegram.Send(user.TelegramID, err.Error())
+		return telegram.Send(user.TelegramID, i18n.Err(user, err))
 	}
 
-	return telegram.Send(user.TelegramID, "message sent")
+	return telegram.Send(user.TelegramID, i18n.T(user, "messages.sent"))
 }
@@ -7,6 +7,7 @@ import (
 
 	"github.com/ad/corpobot/config"
 	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
 
 	dlog "github.com/amoghe/distillog"
 	sql "github.com/lazada/sqle"
@@ -21,17 +22,40 @@ type TelegramPlugin interface {
 
 // Command ...
 type Command struct {
-	Description string          `sql:"description"`
-	Roles       map[string]bool `sql:"roles"`
+	Description string
+	Permission  string
 	Callback    CommandCallback
+	Scope       ScopeCallback
+	Chats       Chats
+	Plugin      string
 }
 
+// Chats where command may run, commands without them run in private chats only
+type Chats int
+
+const (
+	PrivateChats Chats = 1 << iota
+	GroupChats
+	AnyChats = PrivateChats | GroupChats
+)
+
 type CommandCallback func(update *tgbotapi.Update, command, args string, user *database.User) error
 
+// ScopeCallback allows command for user whose role is not enough, but who is responsible for the command target
+// (e.g. a group manager), empty args ask if user is responsible for any target
+type ScopeCallback func(args string, user *database.User) bool
+
+// Input is a command waiting for the next text message from user
+type Input struct {
+	Command string
+	Args    string
+}
+
 var (
 	Plugins         sync.Map
 	DisabledPlugins sync.Map
 	Commands        sync.Map
+	Inputs          sync.Map
 	Bot             *tgbotapi.BotAPI
 	DB              *sql.DB
 	Config          *config.Config
@@ -42,102 +66,193 @@ func RegisterPlugin(p TelegramPlugin) {
 	Plugins.Store(KeyOf(p), p)
 }
 
-// Disable a plugin
-func DisablePlugin(plugin string) bool {
-	plugin = strings.TrimSpace(plugin)
+// NamedPlugin is implemented by plugins whose type doesn't tell them apart, e.g. external ones
+type NamedPlugin interface {
+	Name() string
+}
 
-	v, exists := Plugins.Load(plugin)
-	if exists {
-		DisabledPlugins.Store(plugin, v.(TelegramPlugin))
-		_, disabled := DisabledPlugins.Load(plugin)
-		if disabled {
-			Plugins.Delete(plugin)
-			v.(TelegramPlugin).OnStop()
+// KeyOf ...
+func KeyOf(p TelegramPlugin) string {
+	if named, ok := p.(NamedPlugin); ok {
+		return named.Name()
+	}
 
-			dlog.Debugln(plugin + " removed from running plugins")
-		} else {
-			dlog.Debugln("Can't disable " + plugin + ", odd")
-		}
-		return disabled
+	return strings.TrimPrefix(reflect.TypeOf(p).String(), "*")
+}
+
+// RegisterPermission declares a permission used by plugin commands, roles are granted it when it's seen for the first time
+func RegisterPermission(name, description string, roles ...string) {
+	permission := &database.Permission{
+		Name:        name,
+		Description: description,
 	}
 
-	dlog.Debugln("Plugin '" + plugin + "' does not exist or is not loaded")
+	if _, err := database.AddPermissionIfNotExist(DB, permission, roles); err != nil {
+		dlog.Errorln("failed: " + err.Error())
+	}
+}
 
-	return exists
+// Register a Command exported by a plugin, empty permission allows command for everyone
+func RegisterCommand(command, description, permission string, callback CommandCallback) {
+	RegisterScopedCommand(command, description, permission, nil, callback)
 }
 
-// Enable a plugin
-func EnablePlugin(plugin string) bool {
-	plugin = strings.TrimSpace(plugin)
+// RegisterChatCommand registers a Command which may run in groupchats too, its callback should answer with
+// telegram.Reply to get to the chat where command was sent
+func RegisterChatCommand(command, description, permission string, chats Chats, callback CommandCallback) {
+	registerCurrent(command, Command{Description: description, Permission: permission, Callback: callback, Chats: chats})
+}
 
-	_, PluginExists := Plugins.Load(plugin)
-	if PluginExists {
-		return true
-	}
+// Register a Command allowed by permission and for users within the scope, a command registered by another plugin
+// is not replaced and the starting plugin fails, a plugin starting again replaces its own commands
+func RegisterScopedCommand(command, description, permission string, scope ScopeCallback, callback CommandCallback) {
+	registerCurrent(command, Command{Description: description, Permission: permission, Callback: callback, Scope: scope})
+}
 
-	PluginInstance, InstanceExists := DisabledPlugins.Load(plugin)
-	Plugins.Store(plugin, PluginInstance)
-	if InstanceExists {
-		DisabledPlugins.Delete(plugin)
-		dlog.Debugln("[" + plugin + "] enabled ")
+// registerCurrent registers command of the starting plugin, a conflict makes the plugin fail
+func registerCurrent(command string, cmd Command) {
+	err := registerCommand(current, command, cmd)
+	if err != nil {
+		dlog.Errorln("[" + current + "] " + err.Error())
 
-		PluginInstance.(TelegramPlugin).OnStart()
-		return true
+		if current != "" {
+			startErrors.LoadOrStore(current, err)
+		}
 	}
-	return false
 }
 
-func CheckIfPluginDisabled(name, state string) bool {
-	plugin := &database.Plugin{
-		Name:  name,
-		State: state,
+// RegisterPluginCommand registers a command of running plugin, e.g. one defined by users, a command of another
+// plugin is not replaced
+func RegisterPluginCommand(plugin, command, description, permission string, callback CommandCallback) error {
+	return registerCommand(plugin, command, Command{Description: description, Permission: permission, Callback: callback})
+}
+
+// UnregisterPluginCommand removes command if it belongs to plugin
+func UnregisterPluginCommand(plugin, command string) {
+	commandsMu.Lock()
+	v, ok := Commands.Load(command)
+	ok = ok && v.(Command).Plugin == plugin
+	if ok {
+		Commands.Delete(command)
 	}
+	commandsMu.Unlock()
 
-	plugin, err := database.AddPluginIfNotExist(DB, plugin)
-	if err != nil {
-		dlog.Errorln("failed: " + err.Error())
-		return false
+	if ok {
+		Publish(&CommandsChanged{Command: command})
 	}
+}
 
-	if !plugin.IsEnabled() {
-		if v, ok := Plugins.Load(name); ok {
-			DisabledPlugins.Store(name, v.(TelegramPlugin))
-			Plugins.Delete(name)
-			dlog.Debugln("[" + name + "] Disabled")
-			return false
-		}
+// commandsMu makes checking a command owner and replacing the command atomic
+var commandsMu sync.Mutex
+
+func registerCommand(plugin, command string, cmd Command) error {
+	commandsMu.Lock()
+
+	if v, ok := Commands.Load(command); ok && (plugin == "" || v.(Command).Plugin != plugin) {
+		commandsMu.Unlock()
+		return i18n.NewError("plugins.conflict", "command", command, "plugin", v.(Command).Plugin)
 	}
 
-	dlog.Debugln("[" + name + "] Started")
+	cmd.Plugin = plugin
+	Commands.Store(command, cmd)
+	commandsMu.Unlock()
 
-	return true
-}
+	Publish(&CommandsChanged{Command: command, Registered: true})
 
-// KeyOf ...
-func KeyOf(p TelegramPlugin) string {
-	return strings.TrimPrefix(reflect.TypeOf(p).String(), "*")
+	return nil
 }
 
-// Register a Command exported by a plugin
-func RegisterCommand(command string, description string, roles []string, callback CommandCallback) {
-	if _, ok := Commands.Load(command); ok {
-		dlog.Debugln("[SKIP] /" + command + " already registered by another plugin")
+// UnRegister a Command exported by a plugin, commands of other plugins are kept
+func UnregisterCommand(command string) {
+	if current != "" {
+		UnregisterPluginCommand(current, command)
 		return
 	}
 
-	r := make(map[string]bool)
-	for _, v := range roles {
-		r[v] = true
+	if _, ok := Commands.LoadAndDelete(command); ok {
+		Publish(&CommandsChanged{Command: command})
 	}
-	Commands.Store(command, Command{Description: description, Roles: r, Callback: callback})
 }
 
-// UnRegister a Command exported by a plugin
-func UnregisterCommand(command string) {
-	Commands.Delete(command)
+// AwaitInput makes the next text message from user an argument of command, appended to args as is, a document is
+// passed with the update
+func AwaitInput(telegramID int64, command, args string) {
+	Inputs.Store(telegramID, Input{Command: command, Args: args})
+}
+
+// TakeInput returns and forgets a command waiting for input from user
+func TakeInput(telegramID int64) (Input, bool) {
+	v, ok := Inputs.Load(telegramID)
+	if !ok {
+		return Input{}, false
+	}
+
+	Inputs.Delete(telegramID)
+
+	return v.(Input), true
+}
+
+// CommandDescription is a description of command in language of user, "command.<name>" key of catalogue, commands
+// of plugins without translation keep the registered description
+func CommandDescription(user *database.User, name string, cmd Command) string {
+	if key := "command." + name; i18n.Has(key) {
+		return i18n.T(user, key)
+	}
+
+	return cmd.Description
 }
 
-func (cmd Command) IsAllowedForRole(role string) bool {
-	_, ok := cmd.Roles[role]
+// HasPermission checks if user role is granted the permission, owner is granted everything, blocked and deleted
+// users nothing, even commands without permission
+func HasPermission(user *database.User, permission string) bool {
+	switch user.Role {
+	case database.Blocked, database.Deleted:
+		return false
+	case database.Owner:
+		return true
+	}
+
+	if permission == "" {
+		return true
+	}
+
+	ok, err := database.HasPermission(DB, user.Role, permission)
+	if err != nil {
+		dlog.Errorln(err)
+	}
+
 	return ok
 }
+
+// RunsIn checks if command may run in chat, a callback query of an inline message has no chat and is private
+func (cmd Command) RunsIn(chat *tgbotapi.Chat) bool {
+	chats := cmd.Chats
+	if chats == 0 {
+		chats = PrivateChats
+	}
+
+	if chat == nil || chat.IsPrivate() {
+		return chats&PrivateChats != 0
+	}
+
+	return (chat.IsGroup() || chat.IsSuperGroup()) && chats&GroupChats != 0
+}
+
+// IsAllowedForUser checks user permissions and, for registered users, the command scope
+func (cmd Command) IsAllowedForUser(user *database.User, args string) bool {
+	if HasPermission(user, cmd.Permission) {
+		return true
+	}
+
+	if cmd.Scope == nil {
+		return false
+	}
+
+	// e.g. a blocked user may still be a group manager
+	switch user.Role {
+	case database.New, database.Blocked, database.Deleted:
+		return false
+	}
+
+	return cmd.Scope(args, user)
+}
@@ -0,0 +1,37 @@
+package plugins
+
+import (
+	"testing"
+
+	database "github.com/ad/corpobot/db"
+)
+
+func TestBlockedUsersHaveNoPermissions(t *testing.T) {
+	for _, role := range []string{database.Blocked, database.Deleted} {
+		user := &database.User{Role: role}
+
+		if HasPermission(user, "") {
+			t.Errorf("%s user may run commands without permission", role)
+		}
+	}
+
+	for _, role := range []string{database.New, database.Member, database.Owner} {
+		user := &database.User{Role: role}
+
+		if !HasPermission(user, "") {
+			t.Errorf("%s user may not run commands without permission", role)
+		}
+	}
+}
+
+func TestScopeIsNotForUnregisteredUsers(t *testing.T) {
+	manager := func(args string, user *database.User) bool { return true }
+	cmd := Command{Permission: "groups.manage", Scope: manager}
+
+	// checking new users needs database to look up permission
+	for _, role := range []string{database.Blocked, database.Deleted} {
+		if cmd.IsAllowedForUser(&database.User{Role: role}, "devs") {
+			t.Errorf("%s user is allowed by scope", role)
+		}
+	}
+}
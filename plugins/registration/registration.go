@@ -0,0 +1,524 @@
+package registration
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
+	"github.com/ad/corpobot/pagination"
+	"github.com/ad/corpobot/plugins"
+	"github.com/ad/corpobot/telegram"
+
+	dlog "github.com/amoghe/distillog"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+type Plugin struct{}
+
+// questions asked to a new user, answers are stored in the registration
+var questions = []string{
+	"registration.question_name",
+	"registration.question_department",
+	"registration.question_reason",
+}
+
+func init() {
+	plugins.RegisterPlugin(&Plugin{})
+}
+
+// Meta ...
+func (m *Plugin) Meta() plugins.Meta {
+	return plugins.Meta{
+		Version: "1.0",
+	}
+}
+
+func (m *Plugin) OnStart() {
+	plugins.RegisterPermission("users.register", "Apply for registration", database.New)
+	plugins.RegisterPermission("users.approve", "Approve and reject registrations", database.Admin, database.Owner)
+
+	plugins.RegisterCommand("register", "Apply for registration", "users.register", register)
+	plugins.RegisterCommand("registrationlist", "Pending registrations", "users.approve", registrationList)
+	plugins.RegisterCommand("registrationapprove", "Approve registration", "users.approve", registrationApprove)
+	plugins.RegisterCommand("registrationreject", "Reject registration", "users.approve", registrationReject)
+	plugins.RegisterCommand("registrationgroups", "Add approved user to groups", "users.approve", registrationGroups)
+}
+
+func (m *Plugin) OnStop() {
+	dlog.Debugln("[registration.Plugin] Stopped")
+
+	plugins.UnregisterCommand("register")
+	plugins.UnregisterCommand("registrationlist")
+	plugins.UnregisterCommand("registrationapprove")
+	plugins.UnregisterCommand("registrationreject")
+	plugins.UnregisterCommand("registrationgroups")
+}
+
+var register plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	if last, err := database.GetLastRegistrationByTelegramID(plugins.DB, user.TelegramID); err == nil {
+		switch last.State {
+		case database.Pending:
+			return telegram.Send(user.TelegramID, i18n.T(user, "registration.pending"))
+		case database.Rejected:
+			return telegram.Send(user.TelegramID, i18n.T(user, "registration.rejected"))
+		}
+	}
+
+	var answers []string
+	if args != "" {
+		answers = strings.SplitN(args, "\n", len(questions))
+	}
+
+	// ask questions one by one, answers are collected in args
+	if len(answers) < len(questions) {
+		input := ""
+		if len(answers) > 0 {
+			input = strings.Join(answers, "\n") + "\n"
+		}
+
+		plugins.AwaitInput(user.TelegramID, command, input)
+
+		return telegram.Send(user.TelegramID, i18n.T(user, questions[len(answers)]))
+	}
+
+	registration := &database.Registration{
+		TelegramID: user.TelegramID,
+		Name:       strings.TrimSpace(answers[0]),
+		Department: strings.TrimSpace(answers[1]),
+		Reason:     strings.TrimSpace(answers[2]),
+	}
+
+	registration, err := database.AddRegistration(plugins.DB, registration)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	approvers, err := database.GetUsersByPermission(plugins.DB, "users.approve")
+	if err != nil {
+		return err
+	}
+
+	for _, u := range approvers {
+		sendRegistration(u, registration, user)
+	}
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "registration.sent"))
+}
+
+var registrationList plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	registrations, err := database.GetRegistrations(plugins.DB, database.Pending)
+	if err != nil {
+		return err
+	}
+
+	if len(registrations) == 0 {
+		return telegram.Send(user.TelegramID, i18n.T(user, "registration.empty"))
+	}
+
+	for _, r := range registrations {
+		applicant, err := database.GetUserByTelegramID(plugins.DB, &database.User{TelegramID: r.TelegramID})
+		if err != nil {
+			dlog.Errorln(err.Error())
+			continue
+		}
+
+		sendRegistration(user, r, applicant)
+	}
+
+	return nil
+}
+
+var registrationApprove plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	params := strings.Split(args, "\n")
+
+	registration, applicant, err := getRegistration(params[0])
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	if registration.State != database.Pending {
+		return handled(update, user, registration, applicant)
+	}
+
+	// only registration provided, choose role
+	if len(params) == 1 {
+		replyKeyboard := rolesPicker(user, registration)
+
+		answer(update, "")
+
+		return reply(update, user, registrationText(user, registration, applicant)+"\n\n"+i18n.T(user, "registration.choose_role"), &replyKeyboard)
+	}
+
+	role := strings.TrimSpace(params[1])
+	if !isAssignable(role) {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, errors.New(database.RoleNotFound)))
+	}
+
+	registration.State = database.Approved
+	registration.Role = role
+	registration.HandledBy = user.TelegramID
+
+	if err = database.HandleRegistration(plugins.DB, registration); err != nil {
+		return handled(update, user, registration, applicant)
+	}
+
+	oldRole := applicant.Role
+	applicant.Role = role
+	if _, err = database.UpdateUserRole(plugins.DB, applicant); err != nil {
+		return err
+	}
+
+	plugins.Audit(user, command, applicant.String(), database.New, role)
+	plugins.Publish(&plugins.RoleChanged{Actor: user, User: applicant, Action: command, OldRole: oldRole, NewRole: role})
+
+	answer(update, i18n.T(user, "registration.approved"))
+
+	errNotifyUser := telegram.Send(applicant.TelegramID, i18n.T(applicant, "registration.your_approved", "role", role))
+	if errNotifyUser != nil {
+		dlog.Errorln(errNotifyUser.Error())
+	}
+
+	var skip *tgbotapi.Message
+	if update.CallbackQuery != nil {
+		skip = update.CallbackQuery.Message
+	}
+
+	if err = closeMessages(registration, applicant, skip); err != nil {
+		dlog.Errorln(err.Error())
+	}
+
+	replyKeyboard, err := groupsPicker(user, registration, applicant, pagination.State{})
+	if err != nil {
+		return err
+	}
+
+	return reply(update, user, registrationText(user, registration, applicant)+"\n\n"+i18n.T(user, "registration.add_groups"), &replyKeyboard)
+}
+
+var registrationReject plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	registration, applicant, err := getRegistration(args)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	registration.State = database.Rejected
+	registration.HandledBy = user.TelegramID
+
+	if err = database.HandleRegistration(plugins.DB, registration); err != nil {
+		return handled(update, user, registration, applicant)
+	}
+
+	plugins.Audit(user, command, applicant.String(), database.Pending, database.Rejected)
+
+	answer(update, i18n.T(user, "registration.rejected_answer"))
+
+	errNotifyUser := telegram.Send(applicant.TelegramID, i18n.T(applicant, "registration.rejected"))
+	if errNotifyUser != nil {
+		dlog.Errorln(errNotifyUser.Error())
+	}
+
+	return closeMessages(registration, applicant, nil)
+}
+
+var registrationGroups plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	state, args := pagination.Parse(args)
+
+	params := strings.Split(args, "\n")
+
+	registration, applicant, err := getRegistration(params[0])
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	if registration.State != database.Approved {
+		return telegram.Send(user.TelegramID, i18n.T(user, "registration.not_approved"))
+	}
+
+	if state.Search {
+		return pagination.AskQuery(update, user, command, params[0])
+	}
+
+	if len(params) == 2 && params[1] == "done" {
+		answer(update, "")
+
+		groups, err := database.GetGroupsByUserID(plugins.DB, applicant.ID)
+		if err != nil {
+			return err
+		}
+
+		if len(groups) > 0 {
+			var names []string
+			for _, g := range groups {
+				names = append(names, g.Name)
+			}
+
+			errNotifyUser := telegram.Send(applicant.TelegramID, i18n.T(applicant, "registration.added_groups", "groups", strings.Join(names, ", ")))
+			if errNotifyUser != nil {
+				dlog.Errorln(errNotifyUser.Error())
+			}
+		}
+
+		return reply(update, user, registrationText(user, registration, applicant), nil)
+	}
+
+	if len(params) == 2 {
+		id, err := strconv.ParseInt(strings.TrimPrefix(params[1], "#"), 10, 64)
+		if err != nil {
+			return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+		}
+
+		g, err := database.GetGroupByID(plugins.DB, &database.Group{ID: id})
+		if err != nil {
+			return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+		}
+
+		member, err := isGroupMember(applicant, g)
+		if err != nil {
+			return err
+		}
+
+		action, before, after := "groupadduser", "", "member"
+		if member {
+			action, before, after = "groupdeleteuser", after, before
+			_, err = database.DeleteGroupUser(plugins.DB, g, applicant)
+		} else {
+			_, err = database.AddGroupUserIfNotExist(plugins.DB, g, applicant)
+		}
+		if err != nil {
+			return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+		}
+
+		plugins.Audit(user, action, applicant.String()+" in "+g.Name, before, after)
+		plugins.Publish(&plugins.GroupMembershipChanged{Actor: user, User: applicant, Group: g, Action: action, Joined: !member})
+	}
+
+	replyKeyboard, err := groupsPicker(user, registration, applicant, state)
+	if err != nil {
+		return err
+	}
+
+	answer(update, "")
+
+	return reply(update, user, registrationText(user, registration, applicant)+"\n\n"+i18n.T(user, "registration.add_groups"), &replyKeyboard)
+}
+
+// sendRegistration sends registration with decision buttons to admin and remembers the message to update it later
+func sendRegistration(admin *database.User, registration *database.Registration, applicant *database.User) {
+	id := strconv.FormatInt(registration.ID, 10)
+
+	replyKeyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(i18n.T(admin, "common.approve"), "/registrationapprove "+id),
+			tgbotapi.NewInlineKeyboardButtonData(i18n.T(admin, "common.reject"), "/registrationreject "+id),
+		),
+	)
+
+	msg, err := telegram.SendCustomMessage(admin.TelegramID, 0, registrationText(admin, registration, applicant), false, &replyKeyboard)
+	if err != nil {
+		dlog.Errorln(err.Error())
+		return
+	}
+
+	err = database.AddRegistrationMessage(plugins.DB, &database.RegistrationMessage{
+		RegistrationID: registration.ID,
+		ChatID:         msg.Chat.ID,
+		MessageID:      msg.MessageID,
+	})
+	if err != nil {
+		dlog.Errorln(err.Error())
+	}
+}
+
+// closeMessages shows the decision in all messages about registration and removes their buttons, skip is a message
+// which is updated by caller
+func closeMessages(registration *database.Registration, applicant *database.User, skip *tgbotapi.Message) error {
+	messages, err := database.GetRegistrationMessages(plugins.DB, registration.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range messages {
+		// message IDs are unique within a chat only
+		if skip != nil && m.ChatID == skip.Chat.ID && m.MessageID == skip.MessageID {
+			continue
+		}
+
+		// messages are in the language of admin who got them
+		admin, err := database.GetUserByTelegramID(plugins.DB, &database.User{TelegramID: m.ChatID})
+		if err != nil {
+			dlog.Errorln(err.Error())
+		}
+
+		text := registrationText(admin, registration, applicant)
+		if _, err := plugins.Bot.Send(tgbotapi.NewEditMessageText(m.ChatID, m.MessageID, text)); err != nil {
+			dlog.Errorln(err.Error())
+		}
+	}
+
+	return nil
+}
+
+// handled shows decision made by another admin
+func handled(update *tgbotapi.Update, user *database.User, registration *database.Registration, applicant *database.User) error {
+	answer(update, i18n.Err(user, errors.New(database.RegistrationHandled)))
+
+	registration, err := database.GetRegistration(plugins.DB, registration.ID)
+	if err != nil {
+		return err
+	}
+
+	return closeMessages(registration, applicant, nil)
+}
+
+// registrationText describes registration in the language of viewer
+func registrationText(viewer *database.User, registration *database.Registration, applicant *database.User) string {
+	text := i18n.T(viewer, "telegram.new_user", "user", applicant.String()) + "\n\n" +
+		i18n.T(viewer, "registration.card", "name", registration.Name, "department", registration.Department, "reason", registration.Reason)
+
+	if registration.State == database.Pending {
+		return text
+	}
+
+	handler := strconv.FormatInt(registration.HandledBy, 10)
+	if u, err := database.GetUserByTelegramID(plugins.DB, &database.User{TelegramID: registration.HandledBy}); err == nil {
+		handler = u.String()
+	}
+
+	if registration.State == database.Approved {
+		text += "\n\n" + i18n.T(viewer, "registration.approved_by", "user", handler, "role", registration.Role)
+	} else {
+		text += "\n\n" + i18n.T(viewer, "registration.rejected_by", "user", handler)
+	}
+
+	return text
+}
+
+func getRegistration(ref string) (*database.Registration, *database.User, error) {
+	id, err := strconv.ParseInt(strings.TrimSpace(ref), 10, 64)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	registration, err := database.GetRegistration(plugins.DB, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	applicant, err := database.GetUserByTelegramID(plugins.DB, &database.User{TelegramID: registration.TelegramID})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return registration, applicant, nil
+}
+
+// isAssignable checks if new user may be approved with the role
+func isAssignable(role string) bool {
+	switch role {
+	case "", database.New, database.Owner, database.Blocked, database.Deleted:
+		return false
+	}
+
+	_, err := database.GetRole(plugins.DB, role)
+
+	return err == nil
+}
+
+func rolesPicker(user *database.User, registration *database.Registration) tgbotapi.InlineKeyboardMarkup {
+	id := strconv.FormatInt(registration.ID, 10)
+
+	roles, err := database.GetRoles(plugins.DB)
+	if err != nil {
+		dlog.Errorln(err.Error())
+	}
+
+	buttons := make([][]tgbotapi.InlineKeyboardButton, 0)
+	for _, role := range roles {
+		if !isAssignable(role.Name) {
+			continue
+		}
+
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(role.Name, "/registrationapprove "+id+"\n"+role.Name)))
+	}
+
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "common.reject"), "/registrationreject "+id)))
+
+	return tgbotapi.NewInlineKeyboardMarkup(buttons...)
+}
+
+// groupsPicker lists active groups, tap adds user to group or removes from it
+func groupsPicker(user *database.User, registration *database.Registration, applicant *database.User, state pagination.State) (tgbotapi.InlineKeyboardMarkup, error) {
+	groups, err := database.GetGroups(plugins.DB, []string{database.Active})
+	if err != nil {
+		return tgbotapi.NewInlineKeyboardMarkup(), err
+	}
+
+	userGroups, err := database.GetGroupsByUserID(plugins.DB, applicant.ID)
+	if err != nil {
+		return tgbotapi.NewInlineKeyboardMarkup(), err
+	}
+
+	selected := make(map[int64]bool)
+	for _, g := range userGroups {
+		selected[g.ID] = true
+	}
+
+	prefix := pagination.Prefix("/registrationgroups", strconv.FormatInt(registration.ID, 10))
+
+	items := make([]pagination.Item, 0, len(groups))
+	for _, g := range groups {
+		text := g.Name
+		if selected[g.ID] {
+			text = "✓ " + text
+		}
+
+		items = append(items, pagination.Item{Text: text, Data: pagination.Data(prefix+"#"+strconv.FormatInt(g.ID, 10)+"\n", state)})
+	}
+
+	rows := pagination.Rows(items, state, prefix, true)
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "common.done"), prefix+"done")))
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...), nil
+}
+
+func isGroupMember(user *database.User, group *database.Group) (bool, error) {
+	groups, err := database.GetGroupsByUserID(plugins.DB, user.ID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, g := range groups {
+		if g.ID == group.ID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func answer(update *tgbotapi.Update, text string) {
+	if update.CallbackQuery == nil {
+		return
+	}
+
+	_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, text))
+	if err != nil {
+		dlog.Errorln(err.Error())
+	}
+}
+
+// reply edits message with buttons on callback, otherwise sends a new one
+func reply(update *tgbotapi.Update, user *database.User, text string, replyKeyboard *tgbotapi.InlineKeyboardMarkup) error {
+	if update.CallbackQuery == nil {
+		return telegram.SendCustom(user.TelegramID, 0, text, false, replyKeyboard)
+	}
+
+	edit := tgbotapi.NewEditMessageText(update.CallbackQuery.Message.Chat.ID, update.CallbackQuery.Message.MessageID, text)
+	edit.ReplyMarkup = replyKeyboard
+
+	_, err := plugins.Bot.Send(edit)
+
+	return err
+}
@@ -0,0 +1,419 @@
+package requests
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
+	"github.com/ad/corpobot/pagination"
+	"github.com/ad/corpobot/plugins"
+	"github.com/ad/corpobot/telegram"
+
+	dlog "github.com/amoghe/distillog"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+type Plugin struct{}
+
+func init() {
+	plugins.RegisterPlugin(&Plugin{})
+}
+
+// Meta ...
+func (m *Plugin) Meta() plugins.Meta {
+	return plugins.Meta{
+		Version:      "1.0",
+		Dependencies: []string{"groups.Plugin"},
+	}
+}
+
+func (m *Plugin) OnStart() {
+	plugins.RegisterPermission("groups.request", "Request access to groups", database.Member, database.Admin, database.Owner)
+	plugins.RegisterPermission("groups.approve", "Approve access requests to any group", database.Admin, database.Owner)
+
+	plugins.RegisterCommand("request", "Request access to group", "groups.request", request)
+	plugins.RegisterScopedCommand("requestapprove", "Approve access request", "groups.approve", requestScope, requestApproveDeny)
+	plugins.RegisterScopedCommand("requestdeny", "Deny access request", "groups.approve", requestScope, requestApproveDeny)
+}
+
+func (m *Plugin) OnStop() {
+	dlog.Debugln("[requests.Plugin] Stopped")
+
+	plugins.UnregisterCommand("request")
+	plugins.UnregisterCommand("requestapprove")
+	plugins.UnregisterCommand("requestdeny")
+}
+
+var request plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	// group is referenced as "#id", otherwise args are a state of groups list
+	var state pagination.State
+	if !strings.HasPrefix(args, "#") {
+		state, args = pagination.Parse(args)
+	}
+
+	params := strings.SplitN(args, "\n", 2)
+
+	// no group provided, choose it from the list
+	if params[0] == "" {
+		if state.Search {
+			return pagination.AskQuery(update, user, command, "")
+		}
+
+		replyKeyboard, err := groupsPicker(user, state)
+		if err != nil {
+			return err
+		}
+
+		answer(update, "")
+
+		return reply(update, user, i18n.T(user, "groups.choose"), &replyKeyboard)
+	}
+
+	g, err := getGroup(params[0])
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	if g.State != database.Active {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, errors.New(database.GroupDeleted)))
+	}
+
+	// only group provided, ask for justification
+	if len(params) == 1 {
+		answer(update, "")
+
+		ref := "#" + strconv.FormatInt(g.ID, 10)
+		plugins.AwaitInput(user.TelegramID, command, ref+"\n")
+
+		replyKeyboard := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "requests.skip_comment"), "/request "+ref+"\n-")),
+		)
+
+		return reply(update, user, i18n.T(user, "requests.ask_reason", "group", g.Name), &replyKeyboard)
+	}
+
+	reason := strings.TrimSpace(params[1])
+	if reason == "-" {
+		reason = ""
+	}
+
+	r, err := database.AddAccessRequest(plugins.DB, &database.AccessRequest{
+		TelegramID: user.TelegramID,
+		GroupID:    g.ID,
+		Reason:     reason,
+	})
+	if err != nil {
+		answer(update, "")
+		return reply(update, user, i18n.Failed(user, err), nil)
+	}
+
+	approvers, err := getApprovers(g)
+	if err != nil {
+		return err
+	}
+
+	if len(approvers) == 0 {
+		dlog.Errorln("nobody can approve request to " + g.Name)
+	}
+
+	for _, u := range approvers {
+		sendRequest(u, r, user, g)
+	}
+
+	answer(update, "")
+
+	return reply(update, user, i18n.T(user, "requests.sent", "group", g.Name), nil)
+}
+
+var requestApproveDeny plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	r, applicant, g, err := getRequest(args)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	r.State = database.Rejected
+	if command == "requestapprove" {
+		r.State = database.Approved
+	}
+	r.HandledBy = user.TelegramID
+
+	if err = database.HandleAccessRequest(plugins.DB, r); err != nil {
+		answer(update, i18n.Err(user, err))
+
+		// the request is still pending and may be decided again
+		if err.Error() != database.AccessRequestHandled {
+			return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+		}
+
+		if r, err = database.GetAccessRequest(plugins.DB, r.ID); err != nil {
+			return err
+		}
+
+		return closeMessages(r, applicant, g)
+	}
+
+	if r.State == database.Rejected {
+		plugins.Audit(user, command, applicant.String()+" in "+g.Name, database.Pending, database.Rejected)
+
+		errNotifyUser := telegram.Send(applicant.TelegramID, i18n.T(applicant, "requests.denied", "group", g.Name))
+		if errNotifyUser != nil {
+			dlog.Errorln(errNotifyUser.Error())
+		}
+
+		answer(update, i18n.T(user, "requests.denied_answer"))
+
+		return closeMessages(r, applicant, g)
+	}
+
+	plugins.Audit(user, command, applicant.String()+" in "+g.Name, database.Pending, database.Approved)
+	plugins.Publish(&plugins.GroupMembershipChanged{Actor: user, User: applicant, Group: g, Action: command, Joined: true})
+
+	answer(update, i18n.T(user, "requests.approved_answer"))
+
+	errNotifyUser := telegram.Send(applicant.TelegramID, i18n.T(applicant, "requests.approved", "group", g.Name)+inviteLinks(applicant, g))
+	if errNotifyUser != nil {
+		dlog.Errorln(errNotifyUser.Error())
+	}
+
+	return closeMessages(r, applicant, g)
+}
+
+// requestScope allows managers of the requested group to decide on request
+var requestScope plugins.ScopeCallback = func(args string, user *database.User) bool {
+	if strings.TrimSpace(args) == "" {
+		groups, err := database.GetManagedGroupsByUserID(plugins.DB, user.ID)
+		return err == nil && len(groups) > 0
+	}
+
+	_, _, g, err := getRequest(args)
+	if err != nil {
+		return false
+	}
+
+	ok, err := database.IsGroupManager(plugins.DB, g, user)
+	if err != nil {
+		dlog.Errorln(err)
+	}
+
+	return ok
+}
+
+// getApprovers returns managers of group and its ancestors and users who can approve requests to any group
+func getApprovers(group *database.Group) ([]*database.User, error) {
+	managers, err := database.GetInheritedManagersByGroupID(plugins.DB, group.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	admins, err := database.GetUsersByPermission(plugins.DB, "groups.approve")
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int64]bool)
+
+	var approvers []*database.User
+	for _, u := range append(managers, admins...) {
+		if seen[u.TelegramID] {
+			continue
+		}
+		seen[u.TelegramID] = true
+
+		approvers = append(approvers, u)
+	}
+
+	return approvers, nil
+}
+
+// inviteLinks lists links to groupchats available through group, links are generated if needed
+func inviteLinks(user *database.User, group *database.Group) string {
+	groupchats, err := database.GetInheritedGroupchatsByGroupID(plugins.DB, group.ID)
+	if err != nil {
+		dlog.Errorln(err.Error())
+		return ""
+	}
+
+	var b strings.Builder
+	for _, c := range groupchats {
+		if c.State != database.Active {
+			continue
+		}
+
+		link, err := telegram.GetInviteLink(c)
+		if err != nil {
+			dlog.Errorln(err.Error())
+			continue
+		}
+
+		b.WriteString("\n" + c.Title + ": " + link)
+	}
+
+	if b.Len() == 0 {
+		return ""
+	}
+
+	return i18n.T(user, "requests.join") + b.String()
+}
+
+// sendRequest sends request with decision buttons to approver and remembers the message to update it later
+func sendRequest(approver *database.User, r *database.AccessRequest, applicant *database.User, group *database.Group) {
+	id := strconv.FormatInt(r.ID, 10)
+
+	replyKeyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(i18n.T(approver, "common.approve"), "/requestapprove "+id),
+			tgbotapi.NewInlineKeyboardButtonData(i18n.T(approver, "requests.deny"), "/requestdeny "+id),
+		),
+	)
+
+	msg, err := telegram.SendCustomMessage(approver.TelegramID, 0, requestText(approver, r, applicant, group), false, &replyKeyboard)
+	if err != nil {
+		dlog.Errorln(err.Error())
+		return
+	}
+
+	err = database.AddAccessRequestMessage(plugins.DB, &database.AccessRequestMessage{
+		RequestID: r.ID,
+		ChatID:    msg.Chat.ID,
+		MessageID: msg.MessageID,
+	})
+	if err != nil {
+		dlog.Errorln(err.Error())
+	}
+}
+
+// closeMessages shows the decision in all messages about request and removes their buttons
+func closeMessages(r *database.AccessRequest, applicant *database.User, group *database.Group) error {
+	messages, err := database.GetAccessRequestMessages(plugins.DB, r.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range messages {
+		// messages are in the language of approver who got them
+		approver, err := database.GetUserByTelegramID(plugins.DB, &database.User{TelegramID: m.ChatID})
+		if err != nil {
+			dlog.Errorln(err.Error())
+		}
+
+		text := requestText(approver, r, applicant, group)
+		if _, err := plugins.Bot.Send(tgbotapi.NewEditMessageText(m.ChatID, m.MessageID, text)); err != nil {
+			dlog.Errorln(err.Error())
+		}
+	}
+
+	return nil
+}
+
+// requestText describes request in the language of viewer
+func requestText(viewer *database.User, r *database.AccessRequest, applicant *database.User, group *database.Group) string {
+	text := i18n.T(viewer, "requests.card", "user", applicant.String(), "group", group.Name)
+	if r.Reason != "" {
+		text += "\n\n" + r.Reason
+	}
+
+	if r.State == database.Pending {
+		return text
+	}
+
+	handler := strconv.FormatInt(r.HandledBy, 10)
+	if u, err := database.GetUserByTelegramID(plugins.DB, &database.User{TelegramID: r.HandledBy}); err == nil {
+		handler = u.String()
+	}
+
+	if r.State == database.Approved {
+		return text + "\n\n" + i18n.T(viewer, "requests.approved_by", "user", handler)
+	}
+
+	return text + "\n\n" + i18n.T(viewer, "requests.denied_by", "user", handler)
+}
+
+func getRequest(ref string) (*database.AccessRequest, *database.User, *database.Group, error) {
+	id, err := strconv.ParseInt(strings.TrimSpace(ref), 10, 64)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	r, err := database.GetAccessRequest(plugins.DB, id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	applicant, err := database.GetUserByTelegramID(plugins.DB, &database.User{TelegramID: r.TelegramID})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	g, err := database.GetGroupByID(plugins.DB, &database.Group{ID: r.GroupID})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return r, applicant, g, nil
+}
+
+// getGroup finds group by "#id"
+func getGroup(ref string) (*database.Group, error) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(strings.TrimSpace(ref), "#"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return database.GetGroupByID(plugins.DB, &database.Group{ID: id})
+}
+
+// groupsPicker lists active groups user is not in
+func groupsPicker(user *database.User, state pagination.State) (tgbotapi.InlineKeyboardMarkup, error) {
+	groups, err := database.GetGroups(plugins.DB, []string{database.Active})
+	if err != nil {
+		return tgbotapi.NewInlineKeyboardMarkup(), err
+	}
+
+	userGroups, err := database.GetGroupsByUserID(plugins.DB, user.ID)
+	if err != nil {
+		return tgbotapi.NewInlineKeyboardMarkup(), err
+	}
+
+	joined := make(map[int64]bool)
+	for _, g := range userGroups {
+		joined[g.ID] = true
+	}
+
+	items := make([]pagination.Item, 0, len(groups))
+	for _, g := range groups {
+		if joined[g.ID] {
+			continue
+		}
+
+		items = append(items, pagination.Item{Text: g.Name, Data: "/request #" + strconv.FormatInt(g.ID, 10)})
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(pagination.Rows(items, state, pagination.Prefix("/request", ""), true)...), nil
+}
+
+func answer(update *tgbotapi.Update, text string) {
+	if update.CallbackQuery == nil {
+		return
+	}
+
+	_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, text))
+	if err != nil {
+		dlog.Errorln(err.Error())
+	}
+}
+
+// reply edits message with buttons on callback, otherwise sends a new one
+func reply(update *tgbotapi.Update, user *database.User, text string, replyKeyboard *tgbotapi.InlineKeyboardMarkup) error {
+	if update.CallbackQuery == nil {
+		return telegram.SendCustom(user.TelegramID, 0, text, false, replyKeyboard)
+	}
+
+	edit := tgbotapi.NewEditMessageText(update.CallbackQuery.Message.Chat.ID, update.CallbackQuery.Message.MessageID, text)
+	edit.ReplyMarkup = replyKeyboard
+
+	_, err := plugins.Bot.Send(edit)
+
+	return err
+}
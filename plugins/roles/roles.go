@@ -0,0 +1,167 @@
+package roles
+
+import (
+	"strings"
+
+	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
+	"github.com/ad/corpobot/plugins"
+	"github.com/ad/corpobot/telegram"
+
+	dlog "github.com/amoghe/distillog"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+type Plugin struct{}
+
+func init() {
+	plugins.RegisterPlugin(&Plugin{})
+}
+
+// Meta ...
+func (m *Plugin) Meta() plugins.Meta {
+	return plugins.Meta{
+		Version: "1.0",
+	}
+}
+
+func (m *Plugin) OnStart() {
+	plugins.RegisterPermission("roles.manage", "Manage roles and permissions", database.Owner)
+
+	plugins.RegisterCommand("rolelist", "List roles with permissions", "roles.manage", roleList)
+	plugins.RegisterCommand("rolecreate", "Create custom role", "roles.manage", roleCreate)
+	plugins.RegisterCommand("roledelete", "Delete custom role", "roles.manage", roleDelete)
+	plugins.RegisterCommand("permissionlist", "List permissions", "roles.manage", permissionList)
+	plugins.RegisterCommand("permissiongrant", "Grant permission to role", "roles.manage", permissionGrantRevoke)
+	plugins.RegisterCommand("permissionrevoke", "Revoke permission from role", "roles.manage", permissionGrantRevoke)
+}
+
+func (m *Plugin) OnStop() {
+	dlog.Debugln("[roles.Plugin] Stopped")
+
+	plugins.UnregisterCommand("rolelist")
+	plugins.UnregisterCommand("rolecreate")
+	plugins.UnregisterCommand("roledelete")
+	plugins.UnregisterCommand("permissionlist")
+	plugins.UnregisterCommand("permissiongrant")
+	plugins.UnregisterCommand("permissionrevoke")
+}
+
+var roleList plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	roles, err := database.GetRoles(plugins.DB)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	for _, role := range roles {
+		b.WriteString("* " + role.String() + "\n")
+
+		if role.Name == database.Owner {
+			b.WriteString("    " + i18n.T(user, "roles.all_permissions") + "\n")
+			continue
+		}
+
+		permissions, err := database.GetRolePermissions(plugins.DB, role.Name)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range permissions {
+			b.WriteString("    " + p + "\n")
+		}
+	}
+
+	return telegram.Send(user.TelegramID, b.String())
+}
+
+var roleCreate plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	name := strings.ToLower(strings.TrimSpace(args))
+	if name == "" || strings.ContainsAny(name, " \n") {
+		return telegram.Send(user.TelegramID, i18n.T(user, "roles.name_spaces"))
+	}
+
+	_, err := database.AddRoleIfNotExist(plugins.DB, &database.Role{Name: name})
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	plugins.Audit(user, command, name, "", "")
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "roles.created"))
+}
+
+var roleDelete plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	name := strings.TrimSpace(args)
+	if name == "" {
+		return telegram.Send(user.TelegramID, i18n.T(user, "roles.name_empty"))
+	}
+
+	if err := database.DeleteRole(plugins.DB, name); err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	plugins.Audit(user, command, name, "", "")
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "common.success"))
+}
+
+var permissionList plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	permissions, err := database.GetPermissions(plugins.DB)
+	if err != nil {
+		return err
+	}
+
+	if len(permissions) == 0 {
+		return telegram.Send(user.TelegramID, i18n.T(user, "roles.permissions_empty"))
+	}
+
+	var b strings.Builder
+	for _, p := range permissions {
+		b.WriteString("* " + p.String() + "\n")
+	}
+
+	return telegram.Send(user.TelegramID, b.String())
+}
+
+var permissionGrantRevoke plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	errorString := i18n.T(user, "roles.grant_usage")
+
+	params := strings.Split(args, "\n")
+	if len(params) != 2 {
+		return telegram.Send(user.TelegramID, errorString)
+	}
+
+	role, permission := strings.TrimSpace(params[0]), strings.TrimSpace(params[1])
+	if role == "" || permission == "" {
+		return telegram.Send(user.TelegramID, errorString)
+	}
+
+	if role == database.Owner {
+		return telegram.Send(user.TelegramID, i18n.T(user, "roles.owner"))
+	}
+
+	var rows int64
+	var err error
+
+	if command == "permissiongrant" {
+		rows, err = database.GrantPermission(plugins.DB, role, permission)
+	} else {
+		rows, err = database.RevokePermission(plugins.DB, role, permission)
+	}
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	if rows != 1 {
+		return telegram.Send(user.TelegramID, i18n.T(user, "roles.unchanged"))
+	}
+
+	if command == "permissiongrant" {
+		plugins.Audit(user, command, role, "", permission)
+	} else {
+		plugins.Audit(user, command, role, permission, "")
+	}
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "common.success"))
+}
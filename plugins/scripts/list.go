@@ -0,0 +1,157 @@
+package scripts
+
+import (
+	"strings"
+
+	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
+	"github.com/ad/corpobot/pagination"
+	"github.com/ad/corpobot/plugins"
+	"github.com/ad/corpobot/telegram"
+
+	dlog "github.com/amoghe/distillog"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+var scriptList plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	state, _ := pagination.Parse(args)
+	if state.Search {
+		return pagination.AskQuery(update, user, command, "")
+	}
+
+	answer(update, "")
+
+	return reply(update, user, scriptsText(user), listScripts(user, state))
+}
+
+var scriptEnable plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	state, args := pagination.Parse(args)
+
+	if _, err := database.GetScript(plugins.DB, args); err != nil {
+		answer(update, i18n.Failed(user, err))
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	// the state is stored even if the script fails to load, the error is shown in the list
+	if err := Enable(args); err != nil {
+		answer(update, i18n.Failed(user, err))
+		if update.CallbackQuery == nil {
+			return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+		}
+	}
+
+	plugins.Audit(user, command, args, "disabled", "enabled")
+
+	if update.CallbackQuery != nil {
+		answer(update, i18n.T(user, "scripts.enabled", "script", args))
+		return reply(update, user, scriptsText(user), listScripts(user, state))
+	}
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "scripts.enabled", "script", args))
+}
+
+var scriptDisable plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	state, args := pagination.Parse(args)
+
+	if err := Disable(args); err != nil {
+		answer(update, i18n.Failed(user, err))
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	plugins.Audit(user, command, args, "enabled", "disabled")
+
+	if update.CallbackQuery != nil {
+		answer(update, i18n.T(user, "scripts.disabled", "script", args))
+		return reply(update, user, scriptsText(user), listScripts(user, state))
+	}
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "scripts.disabled", "script", args))
+}
+
+// scriptsText lists scripts with their state and load errors
+func scriptsText(user *database.User) string {
+	allScripts, err := database.GetScripts(plugins.DB)
+	if err != nil {
+		dlog.Errorln(err.Error())
+		return i18n.Failed(user, err)
+	}
+
+	if len(allScripts) == 0 {
+		return i18n.T(user, "scripts.empty")
+	}
+
+	var b strings.Builder
+	for _, s := range allScripts {
+		loaded, loadErr := Status(s.Name)
+
+		status := "scripts.state_disabled"
+		switch {
+		case loadErr != nil:
+			status = "scripts.state_failed"
+		case loaded:
+			status = "scripts.state_running"
+		case s.IsEnabled():
+			status = "scripts.state_stopped"
+		}
+
+		b.WriteString("• " + s.Name + ": " + i18n.T(user, status))
+		if loadErr != nil {
+			b.WriteString(" (" + i18n.Err(user, loadErr) + ")")
+		}
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+func listScripts(user *database.User, state pagination.State) *tgbotapi.InlineKeyboardMarkup {
+	allScripts, err := database.GetScripts(plugins.DB)
+	if err != nil {
+		dlog.Errorln(err.Error())
+		replyKeyboard := tgbotapi.NewInlineKeyboardMarkup()
+		return &replyKeyboard
+	}
+
+	items := make([]pagination.Item, 0, len(allScripts))
+	for _, s := range allScripts {
+		if s.IsEnabled() {
+			items = append(items, pagination.Item{Text: i18n.T(user, "scripts.disable", "script", s.Name), Data: pagination.Data("/scriptdisable "+s.Name+"\n", state)})
+		} else {
+			items = append(items, pagination.Item{Text: i18n.T(user, "scripts.enable", "script", s.Name), Data: pagination.Data("/scriptenable "+s.Name+"\n", state)})
+		}
+	}
+
+	replyKeyboard := tgbotapi.NewInlineKeyboardMarkup(pagination.Rows(items, state, pagination.Prefix("/scriptlist", ""), true)...)
+
+	return &replyKeyboard
+}
+
+func answer(update *tgbotapi.Update, text string) {
+	if update.CallbackQuery == nil {
+		return
+	}
+
+	_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, text))
+	if err != nil {
+		dlog.Errorln(err.Error())
+	}
+}
+
+// reply edits message with pressed button or sends a new one, callback query must be answered separately
+func reply(update *tgbotapi.Update, user *database.User, text string, replyKeyboard *tgbotapi.InlineKeyboardMarkup) error {
+	if update.CallbackQuery != nil {
+		editKeyboard := tgbotapi.EditMessageTextConfig{
+			BaseEdit: tgbotapi.BaseEdit{
+				ChatID:      update.CallbackQuery.Message.Chat.ID,
+				MessageID:   update.CallbackQuery.Message.MessageID,
+				ReplyMarkup: replyKeyboard,
+			},
+			Text: text,
+		}
+
+		_, err := plugins.Bot.Send(editKeyboard)
+		return err
+	}
+
+	return telegram.SendCustom(user.TelegramID, 0, text, false, replyKeyboard)
+}
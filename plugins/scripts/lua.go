@@ -0,0 +1,499 @@
+package scripts
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
+	"github.com/ad/corpobot/plugins"
+	"github.com/ad/corpobot/telegram"
+
+	dlog "github.com/amoghe/distillog"
+	lua "github.com/yuin/gopher-lua"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+var (
+	commandRe = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+	// maxString limits strings made by string.rep at once, other allocations are watched by memoryContext
+	maxString = 1 << 20
+
+	// memoryCheckInterval is short, a script doubling a string reaches gigabytes in milliseconds
+	memoryCheckInterval = time.Millisecond
+	memoryCheckPolls    = uint(4)
+)
+
+// script is a loaded script, its Lua state is not safe for concurrent use, so calls are serialized
+type script struct {
+	name string
+
+	mu       sync.Mutex
+	state    *lua.LState
+	commands []string
+	handlers map[string][]*lua.LFunction
+}
+
+// newScript makes a sandbox: only base, table, string and math libraries without loading code or files, and the
+// bot table with a whitelisted API
+func newScript(name string) *script {
+	s := &script{
+		name:     name,
+		handlers: make(map[string][]*lua.LFunction),
+		state: lua.NewState(lua.Options{
+			SkipOpenLibs:        true,
+			CallStackSize:       256,
+			RegistrySize:        1024,
+			RegistryMaxSize:     64 * 1024,
+			MinimizeStackMemory: true,
+		}),
+	}
+
+	L := s.state
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	for _, unsafe := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module", "collectgarbage", "getfenv", "setfenv", "newproxy"} {
+		L.SetGlobal(unsafe, lua.LNil)
+	}
+
+	L.SetGlobal("print", L.NewFunction(s.log))
+	L.SetField(L.GetGlobal(lua.StringLibName), "rep", L.NewFunction(rep))
+
+	L.SetGlobal("bot", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"command":     s.command,
+		"on":          s.on,
+		"send":        send,
+		"user":        getUser,
+		"groups":      groups,
+		"user_groups": userGroups,
+		"in_group":    inGroup,
+		"log":         s.log,
+	}))
+
+	return s
+}
+
+// run calls fn with the Lua state within the time limit of scripts
+func (s *script) run(fn func(L *lua.LState) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state == nil {
+		return i18n.NewError("scripts.not_running", "script", s.name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), plugins.SettingDuration(name, "timeout"))
+	defer cancel()
+
+	var limited *memoryContext
+	if limit := plugins.SettingInt(name, "memory_limit"); limit > 0 {
+		limited = newMemoryContext(ctx, cancel, uint64(limit)<<20)
+		ctx = limited
+	}
+
+	s.state.SetContext(ctx)
+	defer s.state.RemoveContext()
+
+	err := fn(s.state)
+	switch {
+	case err != nil && limited != nil && limited.exceeded:
+		return i18n.NewError("scripts.memory", "limit", plugins.SettingInt(name, "memory_limit"))
+	case err != nil && ctx.Err() != nil:
+		return i18n.NewError("scripts.timeout", "timeout", plugins.SettingValue(name, "timeout"))
+	}
+
+	return err
+}
+
+// memoryContext is a guard against runaway scripts, not a memory limit of a script: it stops a script call when the
+// heap of the whole bot grows by more than limit bytes during the call. Lua state has no allocation hooks, but it
+// polls Done of its context before each instruction, so the heap is sampled there: at most once per
+// memoryCheckInterval, which is reached shortly after a long instruction like a huge concatenation. Memory taken by
+// the bot or another script in the meantime counts too and may stop an innocent call, and a single instruction still
+// may allocate more than the limit
+type memoryContext struct {
+	context.Context
+
+	cancel   context.CancelFunc
+	base     uint64
+	limit    uint64
+	checked  time.Time
+	polls    uint
+	exceeded bool
+}
+
+func newMemoryContext(ctx context.Context, cancel context.CancelFunc, limit uint64) *memoryContext {
+	return &memoryContext{Context: ctx, cancel: cancel, base: heapBytes(), limit: limit, checked: time.Now()}
+}
+
+// Done is called by the goroutine running the script only, time is read on every memoryCheckPolls call as it costs
+// more than an instruction
+func (c *memoryContext) Done() <-chan struct{} {
+	c.polls++
+	if c.polls%memoryCheckPolls != 0 {
+		return c.Context.Done()
+	}
+
+	if now := time.Now(); now.Sub(c.checked) >= memoryCheckInterval {
+		c.checked = now
+
+		if heap := heapBytes(); heap > c.base && heap-c.base > c.limit {
+			c.exceeded = true
+			c.cancel()
+		}
+	}
+
+	return c.Context.Done()
+}
+
+func heapBytes() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+
+	return sample[0].Value.Uint64()
+}
+
+// call runs a Lua function with arguments, the first result is returned
+func (s *script) call(fn *lua.LFunction, args ...lua.LValue) (lua.LValue, error) {
+	var result lua.LValue = lua.LNil
+
+	err := s.run(func(L *lua.LState) error {
+		if err := L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, args...); err != nil {
+			return err
+		}
+
+		result = L.Get(-1)
+		L.Pop(1)
+
+		return nil
+	})
+
+	return result, err
+}
+
+// close unregisters commands of script and frees its state
+func (s *script) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, command := range s.commands {
+		plugins.UnregisterPluginCommand(name, command)
+	}
+	s.commands = nil
+
+	if s.state != nil {
+		s.state.Close()
+		s.state = nil
+	}
+}
+
+// command is bot.command(name, description, handler[, roles]), handler gets a table with command, args, chat_id and
+// user, a returned string is sent to user. Roles get a permission "script.<command>", without roles the command is
+// allowed for everyone
+func (s *script) command(L *lua.LState) int {
+	command := L.CheckString(1)
+	description := L.CheckString(2)
+	handler := L.CheckFunction(3)
+	roles := L.OptTable(4, nil)
+
+	if !commandRe.MatchString(command) {
+		L.ArgError(1, "wrong command name")
+	}
+
+	permission := ""
+	if roles != nil {
+		var names []string
+		roles.ForEach(func(_, v lua.LValue) {
+			names = append(names, v.String())
+		})
+
+		permission = "script." + command
+		plugins.RegisterPermission(permission, description, names...)
+	}
+
+	err := plugins.RegisterPluginCommand(name, command, description, permission, func(update *tgbotapi.Update, command, args string, user *database.User) error {
+		return s.handle(update, handler, command, args, user)
+	})
+	if err != nil {
+		L.RaiseError("%s", err)
+	}
+
+	s.commands = append(s.commands, command)
+
+	return 0
+}
+
+func (s *script) handle(update *tgbotapi.Update, handler *lua.LFunction, command, args string, user *database.User) error {
+	if update.CallbackQuery != nil {
+		_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, ""))
+		if err != nil {
+			dlog.Errorln(err.Error())
+		}
+	}
+
+	chatID := user.TelegramID
+	if update.Message != nil {
+		chatID = update.Message.Chat.ID
+	}
+
+	result, err := s.call(handler, s.table(map[string]interface{}{
+		"command": command,
+		"args":    args,
+		"chat_id": chatID,
+		"user":    userTable(user),
+	}))
+	if err != nil {
+		report(s.name, err)
+		return telegram.Send(user.TelegramID, i18n.Failed(user, i18n.NewError("scripts.failed", "script", s.name)))
+	}
+
+	if text, ok := result.(lua.LString); ok && text != "" {
+		return telegram.Send(user.TelegramID, string(text))
+	}
+
+	return nil
+}
+
+// on is bot.on(event, handler), handler gets a table with the event data
+func (s *script) on(L *lua.LState) int {
+	event := L.CheckString(1)
+	handler := L.CheckFunction(2)
+
+	s.handlers[event] = append(s.handlers[event], handler)
+
+	return 0
+}
+
+// emit calls handlers of event
+func (s *script) emit(event string, data map[string]interface{}) {
+	s.mu.Lock()
+	handlers := s.handlers[event]
+	s.mu.Unlock()
+
+	for _, handler := range handlers {
+		if _, err := s.call(handler, s.table(data)); err != nil {
+			report(s.name, err)
+		}
+	}
+}
+
+func (s *script) log(L *lua.LState) int {
+	parts := make([]string, 0, L.GetTop())
+	for i := 1; i <= L.GetTop(); i++ {
+		parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+	}
+
+	dlog.Infof("[script %s] %s", s.name, strings.Join(parts, " "))
+
+	return 0
+}
+
+// table converts strings, numbers, bools and nested maps to a Lua table, the state must be used by one goroutine, so
+// tables are made under the lock of script
+func (s *script) table(data map[string]interface{}) lua.LValue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state == nil {
+		return lua.LNil
+	}
+
+	return toLua(s.state, data)
+}
+
+func toLua(L *lua.LState, v interface{}) lua.LValue {
+	switch v := v.(type) {
+	case string:
+		return lua.LString(v)
+	case int:
+		return lua.LNumber(v)
+	case int64:
+		return lua.LNumber(v)
+	case bool:
+		return lua.LBool(v)
+	case []string:
+		t := L.NewTable()
+		for _, item := range v {
+			t.Append(lua.LString(item))
+		}
+		return t
+	case map[string]interface{}:
+		t := L.NewTable()
+		for key, item := range v {
+			t.RawSetString(key, toLua(L, item))
+		}
+		return t
+	}
+
+	return lua.LNil
+}
+
+func userTable(user *database.User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          user.ID,
+		"telegram_id": user.TelegramID,
+		"user_name":   user.UserName,
+		"first_name":  user.FirstName,
+		"last_name":   user.LastName,
+		"role":        user.Role,
+		"language":    i18n.Lang(user),
+	}
+}
+
+// resolve finds user by a Telegram ID number or a reference string
+func resolve(L *lua.LState, n int) (*database.User, error) {
+	ref := L.CheckAny(n)
+	if number, ok := ref.(lua.LNumber); ok {
+		return telegram.ResolveUser(nil, "tg:"+strconv.FormatInt(int64(number), 10))
+	}
+
+	return telegram.ResolveUser(nil, ref.String())
+}
+
+// send is bot.send(chat_id, text), only registered users of the bot, not blocked and not deleted, may get messages,
+// returns true or nil and an error
+func send(L *lua.LState) int {
+	chatID := L.CheckInt64(1)
+	text := L.CheckString(2)
+
+	recipient, err := telegram.ResolveUser(nil, "tg:"+strconv.FormatInt(chatID, 10))
+	if err != nil {
+		return fail(L, errors.New("chat is not allowed: "+err.Error()))
+	}
+
+	switch recipient.Role {
+	case database.New, database.Blocked, database.Deleted:
+		return fail(L, errors.New("chat is not allowed: user is "+recipient.Role))
+	}
+
+	if err := telegram.Send(chatID, text); err != nil {
+		return fail(L, err)
+	}
+
+	L.Push(lua.LTrue)
+
+	return 1
+}
+
+// getUser is bot.user(ref), ref is a Telegram ID or a reference like in commands
+func getUser(L *lua.LState) int {
+	user, err := resolve(L, 1)
+	if err != nil {
+		return fail(L, err)
+	}
+
+	L.Push(toLua(L, userTable(user)))
+
+	return 1
+}
+
+// groups is bot.groups(), names of active groups
+func groups(L *lua.LState) int {
+	list, err := database.GetGroups(plugins.DB, nil)
+	if err != nil {
+		return fail(L, err)
+	}
+
+	L.Push(toLua(L, groupNames(list)))
+
+	return 1
+}
+
+// userGroups is bot.user_groups(ref), names of active groups where user is a member
+func userGroups(L *lua.LState) int {
+	user, err := resolve(L, 1)
+	if err != nil {
+		return fail(L, err)
+	}
+
+	list, err := database.GetGroupsByUserID(plugins.DB, user.ID)
+	if err != nil {
+		return fail(L, err)
+	}
+
+	L.Push(toLua(L, groupNames(list)))
+
+	return 1
+}
+
+// inGroup is bot.in_group(ref, group)
+func inGroup(L *lua.LState) int {
+	user, err := resolve(L, 1)
+	if err != nil {
+		return fail(L, err)
+	}
+	group := L.CheckString(2)
+
+	list, err := database.GetGroupsByUserID(plugins.DB, user.ID)
+	if err != nil {
+		return fail(L, err)
+	}
+
+	for _, name := range groupNames(list) {
+		if name == group {
+			L.Push(lua.LTrue)
+			return 1
+		}
+	}
+
+	L.Push(lua.LFalse)
+
+	return 1
+}
+
+func groupNames(list []*database.Group) []string {
+	names := make([]string, 0, len(list))
+	for _, g := range list {
+		if g.State == database.Active {
+			names = append(names, g.Name)
+		}
+	}
+
+	return names
+}
+
+// rep is string.rep limited by maxString
+func rep(L *lua.LState) int {
+	str := L.CheckString(1)
+	n := L.CheckInt(2)
+
+	if n <= 0 || str == "" {
+		L.Push(lua.LString(""))
+		return 1
+	}
+
+	if n > maxString/len(str) {
+		L.RaiseError("string.rep: result is longer than %d bytes", maxString)
+	}
+
+	L.Push(lua.LString(strings.Repeat(str, n)))
+
+	return 1
+}
+
+// fail returns nil and an error message, as Lua functions usually do
+func fail(L *lua.LState, err error) int {
+	L.Push(lua.LNil)
+	L.Push(lua.LString(err.Error()))
+
+	return 2
+}
@@ -0,0 +1,103 @@
+package scripts
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ad/corpobot/i18n"
+	"github.com/ad/corpobot/plugins"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// setTimeout registers settings of plugin with another default timeout, settings are not stored without database
+func setTimeout(t *testing.T, timeout string) {
+	t.Helper()
+
+	var settings []plugins.Setting
+	for _, s := range (&Plugin{}).Meta().Settings {
+		if s.Name == "timeout" {
+			s.Default = timeout
+		}
+		settings = append(settings, s)
+	}
+
+	plugins.Settings.Store(name, settings)
+	t.Cleanup(func() { plugins.Settings.Delete(name) })
+}
+
+func runScript(source string) error {
+	s := newScript("test")
+	defer s.close()
+
+	return s.run(func(L *lua.LState) error {
+		return L.DoString(source)
+	})
+}
+
+func errorKey(err error) string {
+	var e *i18n.Error
+	if errors.As(err, &e) {
+		return e.Key
+	}
+
+	return ""
+}
+
+func TestScript(t *testing.T) {
+	setTimeout(t, "1s")
+
+	if err := runScript(`local s = string.rep("ab", 3) .. math.floor(2.5) assert(s == "ababab2")`); err != nil {
+		t.Errorf("script failed: %s", err)
+	}
+}
+
+func TestSandboxHasNoUnsafeGlobals(t *testing.T) {
+	setTimeout(t, "1s")
+
+	for _, global := range []string{"load", "loadstring", "loadfile", "dofile", "require", "module", "package", "os", "io", "debug", "collectgarbage", "getfenv", "setfenv", "newproxy"} {
+		if err := runScript(`assert(` + global + ` == nil, "available")`); err != nil {
+			t.Errorf("%s: %s", global, err)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	setTimeout(t, "100ms")
+
+	started := time.Now()
+	err := runScript(`while true do end`)
+
+	if errorKey(err) != "scripts.timeout" {
+		t.Errorf("endless loop is not stopped by timeout: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("endless loop is stopped after %s", elapsed)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	// memory limit must stop the script long before the timeout
+	setTimeout(t, "10s")
+
+	for _, source := range []string{
+		`local s = "x" for i = 1, 40 do s = s .. s end`,
+		`local t = {} for i = 1, 1e9 do t[i] = i end`,
+	} {
+		if err := runScript(source); errorKey(err) != "scripts.memory" {
+			t.Errorf("%s is not stopped by memory limit: %v", source, err)
+		}
+	}
+}
+
+func TestStringRepLimit(t *testing.T) {
+	setTimeout(t, "1s")
+
+	err := runScript(`local s = string.rep("x", 2^30)`)
+
+	if err == nil || !strings.Contains(err.Error(), "longer than") {
+		t.Errorf("long string.rep is not refused: %v", err)
+	}
+}
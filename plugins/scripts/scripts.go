@@ -0,0 +1,304 @@
+package scripts
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
+	"github.com/ad/corpobot/plugins"
+	"github.com/ad/corpobot/telegram"
+
+	dlog "github.com/amoghe/distillog"
+	lua "github.com/yuin/gopher-lua"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+type Plugin struct{}
+
+const name = "scripts.Plugin"
+
+var (
+	// loaded scripts by name
+	loaded sync.Map
+
+	// errors of scripts which failed to load, by name
+	failures sync.Map
+
+	// reported errors by script and text, the same error is reported once in reportInterval
+	reported       sync.Map
+	reportInterval = 10 * time.Minute
+
+	// loadMu makes loading and unloading of scripts sequential
+	loadMu sync.Mutex
+)
+
+func init() {
+	plugins.RegisterPlugin(&Plugin{})
+}
+
+// Meta ...
+func (m *Plugin) Meta() plugins.Meta {
+	return plugins.Meta{
+		Version: "1.0",
+		Settings: []plugins.Setting{
+			{
+				Name:        "timeout",
+				Type:        plugins.DurationSetting,
+				Default:     "1s",
+				Description: "Time limit of a script call",
+			},
+			{
+				Name:        "memory_limit",
+				Type:        plugins.IntSetting,
+				Default:     "64",
+				Description: "Guard against runaway scripts: a script call is stopped when the heap of the whole bot grows by more than this during it, in megabytes",
+			},
+			{
+				Name:        "report_errors",
+				Type:        plugins.BoolSetting,
+				Default:     "true",
+				Description: "Send script errors to owners",
+			},
+		},
+	}
+}
+
+func (m *Plugin) OnStart() {
+	plugins.RegisterPermission("scripts.manage", "Manage Lua scripts", database.Owner)
+
+	plugins.RegisterCommand("scriptset", "Create or change script", "scripts.manage", scriptSet)
+	plugins.RegisterCommand("scriptshow", "Show script source", "scripts.manage", scriptShow)
+	plugins.RegisterCommand("scriptdelete", "Delete script", "scripts.manage", scriptDelete)
+	plugins.RegisterCommand("scriptlist", "List of scripts", "scripts.manage", scriptList)
+	plugins.RegisterCommand("scriptenable", "Enable script", "scripts.manage", scriptEnable)
+	plugins.RegisterCommand("scriptdisable", "Disable script", "scripts.manage", scriptDisable)
+
+	plugins.Subscribe(plugins.AllEvents, emitEvent)
+
+	scripts, err := database.GetScripts(plugins.DB)
+	if err != nil {
+		dlog.Errorln("failed: " + err.Error())
+		return
+	}
+
+	// a broken script is reported and skipped, not the whole plugin
+	for _, s := range scripts {
+		if s.IsEnabled() {
+			_ = load(s)
+		}
+	}
+}
+
+func (m *Plugin) OnStop() {
+	dlog.Debugln("[scripts.Plugin] Stopped")
+
+	plugins.UnregisterCommand("scriptset")
+	plugins.UnregisterCommand("scriptshow")
+	plugins.UnregisterCommand("scriptdelete")
+	plugins.UnregisterCommand("scriptlist")
+	plugins.UnregisterCommand("scriptenable")
+	plugins.UnregisterCommand("scriptdisable")
+
+	loaded.Range(func(k, v interface{}) bool {
+		unload(k.(string))
+		return true
+	})
+}
+
+// load runs script source, which registers commands and event handlers, a script with the same name is unloaded first
+func load(s *database.Script) error {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+
+	unloadLocked(s.Name)
+
+	sc := newScript(s.Name)
+	err := sc.run(func(L *lua.LState) error {
+		return L.DoString(s.Source)
+	})
+	if err != nil {
+		sc.close()
+		failures.Store(s.Name, err)
+		report(s.Name, err)
+		return err
+	}
+
+	loaded.Store(s.Name, sc)
+	dlog.Debugf("[%s] script %s loaded", name, s.Name)
+
+	return nil
+}
+
+func unload(scriptName string) {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+
+	unloadLocked(scriptName)
+}
+
+func unloadLocked(scriptName string) {
+	failures.Delete(scriptName)
+
+	if v, ok := loaded.Load(scriptName); ok {
+		loaded.Delete(scriptName)
+		v.(*script).close()
+	}
+}
+
+// Status tells if script is loaded, a script which failed to load has an error
+func Status(script string) (bool, error) {
+	if v, ok := failures.Load(script); ok {
+		return false, v.(error)
+	}
+
+	_, ok := loaded.Load(script)
+
+	return ok, nil
+}
+
+// Enable stores script state and loads it if the plugin is running
+func Enable(script string) error {
+	s, err := database.GetScript(plugins.DB, script)
+	if err != nil {
+		return err
+	}
+
+	s.State = "enabled"
+	if _, err = database.UpdateScriptState(plugins.DB, s); err != nil {
+		return err
+	}
+
+	if !plugins.IsRunning(name) {
+		return nil
+	}
+
+	return load(s)
+}
+
+// Disable stores script state and unloads it
+func Disable(script string) error {
+	s, err := database.GetScript(plugins.DB, script)
+	if err != nil {
+		return err
+	}
+
+	s.State = "disabled"
+	if _, err = database.UpdateScriptState(plugins.DB, s); err != nil {
+		return err
+	}
+
+	unload(script)
+
+	return nil
+}
+
+// emitEvent passes events of the bot to handlers of scripts, e.g. bot.on("role_changed", ...)
+func emitEvent(e plugins.Event) {
+	data := e.Fields()
+
+	loaded.Range(func(k, v interface{}) bool {
+		go v.(*script).emit(e.EventName(), data)
+		return true
+	})
+}
+
+// report sends script error to owners
+func report(script string, err error) {
+	dlog.Errorf("[%s] script %s: %s", name, script, err)
+
+	if !plugins.SettingBool(name, "report_errors") {
+		return
+	}
+
+	key := script + "\n" + err.Error()
+	if v, ok := reported.Load(key); ok && time.Since(v.(time.Time)) < reportInterval {
+		return
+	}
+	reported.Store(key, time.Now())
+
+	owners, errOwners := database.GetUsers(plugins.DB, []string{database.Owner})
+	if errOwners != nil {
+		dlog.Errorln(errOwners)
+		return
+	}
+
+	for _, owner := range owners {
+		if errSend := telegram.Send(owner.TelegramID, i18n.T(owner, "scripts.error", "script", script, "error", err)); errSend != nil {
+			dlog.Errorln(errSend)
+		}
+	}
+}
+
+var scriptSet plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	lines := strings.SplitN(strings.TrimSpace(args), "\n", 2)
+	if len(lines) != 2 || strings.TrimSpace(lines[1]) == "" {
+		return telegram.Send(user.TelegramID, i18n.T(user, "scripts.usage"))
+	}
+
+	s := &database.Script{Name: strings.TrimSpace(lines[0]), Source: lines[1], CreatedBy: user.TelegramID}
+	if !commandRe.MatchString(s.Name) {
+		return telegram.Send(user.TelegramID, i18n.T(user, "scripts.usage"))
+	}
+
+	// the source must compile before it's stored
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	_, err := L.LoadString(s.Source)
+	L.Close()
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	before := ""
+	if old, err := database.GetScript(plugins.DB, s.Name); err == nil {
+		before = old.Source
+	}
+
+	if err := database.SetScript(plugins.DB, s); err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	plugins.Audit(user, command, s.Name, before, s.Source)
+
+	stored, err := database.GetScript(plugins.DB, s.Name)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	if !stored.IsEnabled() {
+		return telegram.Send(user.TelegramID, i18n.T(user, "scripts.saved_disabled", "script", s.Name))
+	}
+
+	if err = load(stored); err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "scripts.saved", "script", s.Name))
+}
+
+var scriptShow plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	s, err := database.GetScript(plugins.DB, strings.TrimSpace(args))
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	return telegram.Send(user.TelegramID, "/scriptset "+s.Name+"\n"+s.Source)
+}
+
+var scriptDelete plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	s, err := database.GetScript(plugins.DB, strings.TrimSpace(args))
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	if err = database.DeleteScript(plugins.DB, s.Name); err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	unload(s.Name)
+
+	plugins.Audit(user, command, s.Name, s.Source, "")
+
+	return telegram.Send(user.TelegramID, i18n.T(user, "common.success"))
+}
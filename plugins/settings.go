@@ -0,0 +1,223 @@
+package plugins
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
+
+	dlog "github.com/amoghe/distillog"
+)
+
+// SettingType defines how a setting value is checked and read
+type SettingType string
+
+const (
+	StringSetting   SettingType = "string"
+	IntSetting      SettingType = "int"
+	BoolSetting     SettingType = "bool"
+	DurationSetting SettingType = "duration"
+	ChatIDSetting   SettingType = "chat_id"
+)
+
+// Setting is an option declared by plugin and edited by owner with /pluginconfig, values are kept as text
+type Setting struct {
+	Name        string
+	Type        SettingType
+	Default     string
+	Description string
+}
+
+// SettingsListener is implemented by plugins which apply changed settings while running
+type SettingsListener interface {
+	OnSettingChange(name, value string)
+}
+
+var (
+	// Settings are declared settings by plugin name
+	Settings sync.Map
+
+	settingValues sync.Map
+)
+
+// RegisterSettings declares settings of plugin and loads their stored values, settings of Meta are registered on start
+func RegisterSettings(plugin string, settings ...Setting) {
+	Settings.Store(plugin, settings)
+
+	stored, err := database.GetPluginSettings(DB, plugin)
+	if err != nil {
+		dlog.Errorln("failed: " + err.Error())
+	}
+
+	for _, s := range settings {
+		settingValues.Delete(settingKey(plugin, s.Name))
+	}
+
+	for _, v := range stored {
+		s, ok := FindSetting(plugin, v.Name)
+		if !ok {
+			continue
+		}
+
+		value, err := s.Normalize(v.Value)
+		if err != nil {
+			dlog.Errorf("[%s] stored %s is ignored: %s", plugin, v.Name, err)
+			continue
+		}
+
+		settingValues.Store(settingKey(plugin, v.Name), value)
+	}
+}
+
+// GetSettings returns settings declared by plugin
+func GetSettings(plugin string) ([]Setting, bool) {
+	v, ok := Settings.Load(plugin)
+	if !ok {
+		return nil, false
+	}
+
+	return v.([]Setting), true
+}
+
+// FindSetting ...
+func FindSetting(plugin, name string) (Setting, bool) {
+	settings, _ := GetSettings(plugin)
+	for _, s := range settings {
+		if s.Name == name {
+			return s, true
+		}
+	}
+
+	return Setting{}, false
+}
+
+// SettingValue is a stored value of setting or its default
+func SettingValue(plugin, name string) string {
+	if v, ok := settingValues.Load(settingKey(plugin, name)); ok {
+		return v.(string)
+	}
+
+	s, _ := FindSetting(plugin, name)
+
+	return s.Default
+}
+
+// IsDefault checks if setting has no stored value
+func IsDefault(plugin, name string) bool {
+	_, ok := settingValues.Load(settingKey(plugin, name))
+	return !ok
+}
+
+// SettingInt ...
+func SettingInt(plugin, name string) int {
+	n, _ := strconv.Atoi(SettingValue(plugin, name))
+	return n
+}
+
+// SettingBool ...
+func SettingBool(plugin, name string) bool {
+	b, _ := strconv.ParseBool(SettingValue(plugin, name))
+	return b
+}
+
+// SettingDuration ...
+func SettingDuration(plugin, name string) time.Duration {
+	d, _ := time.ParseDuration(SettingValue(plugin, name))
+	return d
+}
+
+// SettingChatID ...
+func SettingChatID(plugin, name string) int64 {
+	id, _ := strconv.ParseInt(SettingValue(plugin, name), 10, 64)
+	return id
+}
+
+// Normalize checks value against the setting type and returns it in a canonical form
+func (s Setting) Normalize(value string) (string, error) {
+	wrong := i18n.NewError("settings.wrong_value", "value", value, "type", s.Type)
+
+	switch s.Type {
+	case IntSetting:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return "", wrong
+		}
+		return strconv.Itoa(n), nil
+	case BoolSetting:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", wrong
+		}
+		return strconv.FormatBool(b), nil
+	case DurationSetting:
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return "", wrong
+		}
+		return d.String(), nil
+	case ChatIDSetting:
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", wrong
+		}
+		return strconv.FormatInt(id, 10), nil
+	}
+
+	return value, nil
+}
+
+// SetSetting stores a new value of setting and tells the running plugin about it, empty value resets the setting to
+// default, the previous value is returned
+func SetSetting(plugin, name, value string) (string, error) {
+	s, ok := FindSetting(plugin, name)
+	if !ok {
+		return "", i18n.NewError("settings.unknown", "setting", name, "plugin", plugin)
+	}
+
+	before := SettingValue(plugin, name)
+
+	if value == "" {
+		if _, err := database.DeletePluginSetting(DB, plugin, name); err != nil {
+			return before, err
+		}
+
+		settingValues.Delete(settingKey(plugin, name))
+	} else {
+		normalized, err := s.Normalize(value)
+		if err != nil {
+			return before, err
+		}
+
+		if err = database.SetPluginSetting(DB, &database.PluginSetting{Plugin: plugin, Name: name, Value: normalized}); err != nil {
+			return before, err
+		}
+
+		settingValues.Store(settingKey(plugin, name), normalized)
+	}
+
+	if after := SettingValue(plugin, name); after != before {
+		if p, ok := Plugins.Load(plugin); ok && IsRunning(plugin) {
+			if listener, ok := p.(SettingsListener); ok {
+				listener.OnSettingChange(name, after)
+			}
+		}
+	}
+
+	return before, nil
+}
+
+// SettingDescription is a description of setting in language of user, "setting.<plugin>.<name>" key of catalogue,
+// settings without translation keep the declared description
+func SettingDescription(user *database.User, plugin string, s Setting) string {
+	if key := "setting." + plugin + "." + s.Name; i18n.Has(key) {
+		return i18n.T(user, key)
+	}
+
+	return s.Description
+}
+
+func settingKey(plugin, name string) string {
+	return plugin + "\n" + name
+}
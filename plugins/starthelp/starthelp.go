@@ -5,6 +5,7 @@ import (
 	"sort"
 
 	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
 	"github.com/ad/corpobot/plugins"
 	"github.com/ad/corpobot/telegram"
 	dlog "github.com/amoghe/distillog"
@@ -17,13 +18,16 @@ func init() {
 	plugins.RegisterPlugin(&Plugin{})
 }
 
-func (m *Plugin) OnStart() {
-	if !plugins.CheckIfPluginDisabled("starthelp.Plugin", "enabled") {
-		return
+// Meta ...
+func (m *Plugin) Meta() plugins.Meta {
+	return plugins.Meta{
+		Version: "1.0",
 	}
+}
 
-	plugins.RegisterCommand("start", "Bot /start command", []string{database.New, database.Member, database.Admin, database.Owner}, start)
-	plugins.RegisterCommand("help", "Display this help", []string{database.New, database.Member, database.Admin, database.Owner}, help)
+func (m *Plugin) OnStart() {
+	plugins.RegisterCommand("start", "Bot /start command", "", start)
+	plugins.RegisterChatCommand("help", "Display this help", "", plugins.AnyChats, help)
 }
 
 func (m *Plugin) OnStop() {
@@ -34,17 +38,20 @@ func (m *Plugin) OnStop() {
 }
 
 var start plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
-	return telegram.Send(user.TelegramID, "Hello! Send /help")
+	return telegram.Send(user.TelegramID, i18n.T(user, "start.hello"))
 }
 
 var help plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
 	mk := make(map[string]string)
 	var keys []string
 
+	// in groupchat only commands available there are listed
+	chat := plugins.UpdateChat(update)
+
 	plugins.Commands.Range(func(k, v interface{}) bool {
 		cmd := v.(plugins.Command)
-		if cmd.IsAllowedForRole(user.Role) {
-			mk[k.(string)] = cmd.Description
+		if cmd.RunsIn(chat) && cmd.IsAllowedForUser(user, "") {
+			mk[k.(string)] = plugins.CommandDescription(user, k.(string), cmd)
 			keys = append(keys, k.(string))
 		}
 		return true
@@ -60,5 +67,5 @@ var help plugins.CommandCallback = func(update *tgbotapi.Update, command, args s
 		}
 	}
 
-	return telegram.Send(user.TelegramID, "Those are my commands: \n"+buffer.String())
+	return telegram.Reply(update, user, i18n.T(user, "help.commands", "commands", buffer.String()))
 }
@@ -8,6 +8,8 @@ import (
 
 	cal "github.com/ad/corpobot/calendar"
 	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
+	"github.com/ad/corpobot/pagination"
 	"github.com/ad/corpobot/plugins"
 	"github.com/ad/corpobot/telegram"
 	dlog "github.com/amoghe/distillog"
@@ -20,19 +22,35 @@ func init() {
 	plugins.RegisterPlugin(&Plugin{})
 }
 
-func (m *Plugin) OnStart() {
-	if !plugins.CheckIfPluginDisabled("users.Plugin", "enabled") {
-		return
+// Meta ...
+func (m *Plugin) Meta() plugins.Meta {
+	return plugins.Meta{
+		Version: "1.0",
 	}
+}
 
-	plugins.RegisterCommand("userlist", "User list", []string{database.Member, database.Admin, database.Owner}, userList)
-	plugins.RegisterCommand("user", "User actions", []string{database.Admin, database.Owner}, user)
-	plugins.RegisterCommand("userpromote", "Change user role", []string{database.Admin, database.Owner}, userPromote)
-	plugins.RegisterCommand("userblock", "Block user", []string{database.Admin, database.Owner}, userBlockUnblock)
-	plugins.RegisterCommand("userdelete", "Delete user", []string{database.Admin, database.Owner}, userDeleteUndelete)
-	plugins.RegisterCommand("userunblock", "Unblock user", []string{database.Admin, database.Owner}, userBlockUnblock)
-	plugins.RegisterCommand("userundelete", "Undelete user", []string{database.Admin, database.Owner}, userDeleteUndelete)
-	plugins.RegisterCommand("userbirthday", "Set user birthday", []string{database.Member, database.Admin, database.Owner}, userBirthday)
+func (m *Plugin) OnStart() {
+	plugins.RegisterPermission("users.list", "List users", database.Member, database.Admin, database.Owner)
+	plugins.RegisterPermission("users.view", "View user actions", database.Admin, database.Owner)
+	plugins.RegisterPermission("users.promote", "Change user roles", database.Admin, database.Owner)
+	plugins.RegisterPermission("users.block", "Block and unblock users", database.Admin, database.Owner)
+	plugins.RegisterPermission("users.delete", "Delete and undelete users", database.Admin, database.Owner)
+	plugins.RegisterPermission("users.refresh", "Refresh user profiles from Telegram", database.Admin, database.Owner)
+	plugins.RegisterPermission("users.birthday", "Set birthdays", database.Member, database.Admin, database.Owner)
+
+	plugins.RegisterCommand("userlist", "User list", "users.list", userList)
+	plugins.RegisterCommand("user", "User actions", "users.view", user)
+	plugins.RegisterCommand("userpromote", "Change user role", "users.promote", userPromote)
+	plugins.RegisterCommand("userroleexpire", "Set expiry of user role", "users.promote", userRoleExpire)
+	plugins.RegisterCommand("userblock", "Block user", "users.block", userBlockUnblock)
+	plugins.RegisterCommand("userdelete", "Delete user", "users.delete", userDeleteUndelete)
+	plugins.RegisterCommand("userunblock", "Unblock user", "users.block", userBlockUnblock)
+	plugins.RegisterCommand("userundelete", "Undelete user", "users.delete", userDeleteUndelete)
+	plugins.RegisterCommand("userrefresh", "Refresh user profile from Telegram", "users.refresh", userRefresh)
+	plugins.RegisterCommand("userbirthday", "Set user birthday", "users.birthday", userBirthday)
+
+	plugins.Subscribe(plugins.UserRegisteredEvent, notifyNewUser)
+	plugins.Subscribe(plugins.RoleChangedEvent, notifyRoleChanged)
 }
 
 func (m *Plugin) OnStop() {
@@ -41,33 +59,107 @@ func (m *Plugin) OnStop() {
 	plugins.UnregisterCommand("userlist")
 	plugins.UnregisterCommand("user")
 	plugins.UnregisterCommand("userpromote")
+	plugins.UnregisterCommand("userroleexpire")
 	plugins.UnregisterCommand("userblock")
 	plugins.UnregisterCommand("userdelete")
 	plugins.UnregisterCommand("userunblock")
 	plugins.UnregisterCommand("userundelete")
+	plugins.UnregisterCommand("userrefresh")
 	plugins.UnregisterCommand("userbirthday")
 }
 
+// notifyNewUser tells admins about new user unless user applies for registration or is imported
+func notifyNewUser(e plugins.Event) {
+	event := e.(*plugins.UserRegistered)
+	if event.Applying || event.Imported {
+		return
+	}
+
+	users, err := database.GetUsers(plugins.DB, []string{database.Admin, database.Owner})
+	if err != nil {
+		dlog.Errorln(err)
+		return
+	}
+
+	for _, u := range users {
+		replyKeyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(i18n.T(u, "common.user_info"), "/user "+strconv.FormatInt(event.User.TelegramID, 10))))
+
+		if err := telegram.SendCustom(u.TelegramID, 0, i18n.T(u, "telegram.new_user", "user", event.User.String()), false, &replyKeyboard); err != nil {
+			dlog.Errorln(err.Error())
+		}
+	}
+}
+
+// roleCommands change roles, user is told about a new role set by them, other plugins send their own messages
+var roleCommands = map[string]bool{
+	"userpromote":  true,
+	"userblock":    true,
+	"userunblock":  true,
+	"userdelete":   true,
+	"userundelete": true,
+}
+
+func notifyRoleChanged(e plugins.Event) {
+	event := e.(*plugins.RoleChanged)
+	if !roleCommands[event.Action] {
+		return
+	}
+
+	if err := telegram.Send(event.User.TelegramID, i18n.T(event.User, "users.assigned", "role", event.NewRole)); err != nil {
+		dlog.Errorln(err.Error())
+	}
+}
+
+// publishRoleChanged tells subscribers that actor changed role of user, user is read before the change
+func publishRoleChanged(actor, user *database.User, action, newRole string) {
+	changed := *user
+	changed.Role = newRole
+
+	plugins.Publish(&plugins.RoleChanged{Actor: actor, User: &changed, Action: action, OldRole: user.Role, NewRole: newRole})
+}
+
 var userList plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
-	replyKeyboard := listUsers(args)
-	return telegram.SendCustom(user.TelegramID, 0, "Choose user", false, &replyKeyboard)
+	state, args := pagination.Parse(args)
+	if state.Search {
+		return pagination.AskQuery(update, user, command, args)
+	}
+
+	replyKeyboard := listUsers(args, state)
+
+	if update.CallbackQuery != nil {
+		_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, ""))
+		if err != nil {
+			dlog.Errorln(err.Error())
+		}
+
+		edit := tgbotapi.EditMessageReplyMarkupConfig{
+			BaseEdit: tgbotapi.BaseEdit{
+				ChatID:      update.CallbackQuery.Message.Chat.ID,
+				MessageID:   update.CallbackQuery.Message.MessageID,
+				ReplyMarkup: &replyKeyboard,
+			},
+		}
+
+		_, err = plugins.Bot.Send(edit)
+		return err
+	}
+
+	return telegram.SendCustom(user.TelegramID, 0, i18n.T(user, "common.choose_user"), false, &replyKeyboard)
 }
 
 var user plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
-	userID, err := strconv.ParseInt(args, 10, 64)
-	if err != nil {
-		return telegram.Send(user.TelegramID, "wrong telegramID provided")
+	if args == "" {
+		return telegram.AskUser(update, user, command, "")
 	}
 
-	userFromDB, err := database.GetUserByTelegramID(plugins.DB, &database.User{TelegramID: userID})
+	userFromDB, err := telegram.ResolveUser(update, args)
 	if err != nil {
-		dlog.Errorln(err.Error())
-		return telegram.Send(user.TelegramID, "user not found")
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
 	}
 
-	replyKeyboard := userActionsList(userFromDB)
+	replyKeyboard := userActionsList(user, userFromDB)
 	if update.CallbackQuery != nil {
-		_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, args+" success"))
+		_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, i18n.T(user, "common.success")))
 		if err != nil {
 			dlog.Errorln(err.Error())
 		}
@@ -78,18 +170,18 @@ var user plugins.CommandCallback = func(update *tgbotapi.Update, command, args s
 				MessageID:   update.CallbackQuery.Message.MessageID,
 				ReplyMarkup: &replyKeyboard,
 			},
-			Text: userFromDB.Paragraph(),
+			Text: userParagraph(user, userFromDB),
 		}
 
 		_, err = plugins.Bot.Send(editKeyboard)
 		return err
 	}
 
-	return telegram.SendCustom(user.TelegramID, 0, userFromDB.Paragraph(), false, &replyKeyboard)
+	return telegram.SendCustom(user.TelegramID, 0, userParagraph(user, userFromDB), false, &replyKeyboard)
 }
 
 var userPromote plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
-	errorString := "failed: you must provide TelegramID and new role with a new line between them"
+	errorString := i18n.T(user, "users.promote_usage")
 
 	params := strings.Split(args, "\n")
 
@@ -97,43 +189,52 @@ var userPromote plugins.CommandCallback = func(update *tgbotapi.Update, command,
 		return telegram.Send(user.TelegramID, errorString)
 	}
 
-	telegramIDstring, newRole := strings.TrimSpace(params[0]), strings.TrimSpace(params[1])
+	userRef, newRole := strings.TrimSpace(params[0]), strings.TrimSpace(params[1])
 
-	if telegramIDstring == "" || newRole == "" || newRole == database.Owner {
+	if userRef == "" || newRole == "" || newRole == database.Owner {
 		return telegram.Send(user.TelegramID, errorString)
 	}
 
-	var telegramID int64
-	n, err := strconv.ParseInt(telegramIDstring, 10, 64)
-	if err == nil {
-		telegramID = n
+	if _, err := database.GetRole(plugins.DB, newRole); err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
 	}
 
-	if telegramID == 0 {
-		return telegram.Send(user.TelegramID, errorString)
+	target, err := telegram.ResolveUser(update, userRef)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
 	}
 
+	telegramID := target.TelegramID
+
 	u := &database.User{
 		TelegramID: telegramID,
 		Role:       newRole,
 	}
 
+	before, err := database.GetUserByTelegramID(plugins.DB, u)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
 	rows, err := database.UpdateUserRole(plugins.DB, u)
 	if err != nil {
 		return err
 	}
 
 	if rows != 1 {
-		return telegram.Send(user.TelegramID, "failed")
+		return telegram.Send(user.TelegramID, i18n.T(user, "common.failed"))
 	}
 
+	plugins.Audit(user, command, before.String(), before.Role, newRole)
+	publishRoleChanged(user, before, command, newRole)
+
 	if update.CallbackQuery != nil {
-		_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, args+" enabled"))
+		_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, i18n.T(user, "common.success")))
 		if err != nil {
 			dlog.Errorln(err.Error())
 		}
 
-		replyKeyboard := userActionsList(u)
+		replyKeyboard := userActionsList(user, u)
 		edit := tgbotapi.EditMessageReplyMarkupConfig{
 			BaseEdit: tgbotapi.BaseEdit{
 				ChatID:      update.CallbackQuery.Message.Chat.ID,
@@ -146,12 +247,7 @@ var userPromote plugins.CommandCallback = func(update *tgbotapi.Update, command,
 		return err
 	}
 
-	errNotifyUser := telegram.Send(telegramID, "you were assigned the role \""+newRole+"\", user /help for command list")
-	if errNotifyUser != nil {
-		dlog.Errorln(errNotifyUser.Error())
-	}
-
-	return telegram.Send(user.TelegramID, "success")
+	return telegram.Send(user.TelegramID, i18n.T(user, "common.success"))
 }
 
 var userBlockUnblock plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
@@ -161,37 +257,46 @@ var userBlockUnblock plugins.CommandCallback = func(update *tgbotapi.Update, com
 		newRole = database.Blocked
 	}
 
-	var telegramID int64
-	n, err := strconv.ParseInt(args, 10, 64)
-	if err == nil {
-		telegramID = n
+	if args == "" {
+		return telegram.AskUser(update, user, command, "")
 	}
 
-	if telegramID == 0 {
-		return telegram.Send(user.TelegramID, "please provide user telegram ID")
+	target, err := telegram.ResolveUser(update, args)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
 	}
 
+	telegramID := target.TelegramID
+
 	u := &database.User{
 		TelegramID: telegramID,
 		Role:       newRole,
 	}
 
+	before, err := database.GetUserByTelegramID(plugins.DB, u)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
 	rows, err := database.UpdateUserRole(plugins.DB, u)
 	if err != nil {
 		return err
 	}
 
 	if rows != 1 {
-		return telegram.Send(user.TelegramID, "failed")
+		return telegram.Send(user.TelegramID, i18n.T(user, "common.failed"))
 	}
 
+	plugins.Audit(user, command, before.String(), before.Role, newRole)
+	publishRoleChanged(user, before, command, newRole)
+
 	if update.CallbackQuery != nil {
-		_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, args+" success"))
+		_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, i18n.T(user, "common.success")))
 		if err != nil {
 			dlog.Errorln(err.Error())
 		}
 
-		replyKeyboard := userActionsList(u)
+		replyKeyboard := userActionsList(user, u)
 		edit := tgbotapi.EditMessageReplyMarkupConfig{
 			BaseEdit: tgbotapi.BaseEdit{
 				ChatID:      update.CallbackQuery.Message.Chat.ID,
@@ -204,13 +309,205 @@ var userBlockUnblock plugins.CommandCallback = func(update *tgbotapi.Update, com
 		return err
 	}
 
-	errNotifyUser := telegram.Send(telegramID, "you were assigned the role \""+newRole+"\", user /help for command list")
-	if errNotifyUser != nil {
-		dlog.Errorln(errNotifyUser.Error())
+	return telegram.Send(user.TelegramID, i18n.T(user, "common.success"))
+
+}
+
+// userRoleExpire sets a date when user's role is taken back: "-" makes the role permanent, "+N" extends it for N days
+var userRoleExpire plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	if args == "" {
+		return telegram.AskUser(update, user, command, "")
+	}
+
+	errorString := i18n.T(user, "users.expire_usage")
+
+	fields := strings.SplitN(strings.TrimSpace(args), " ", 2)
+
+	userFromDB, err := telegram.ResolveUser(update, fields[0])
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	telegramID := userFromDB.TelegramID
+
+	switch userFromDB.Role {
+	case database.New, database.Owner, database.Blocked, database.Deleted:
+		return telegram.Send(user.TelegramID, i18n.T(user, "users.cant_expire", "role", userFromDB.Role))
+	}
+
+	before := "-"
+	current, err := database.GetUserRoleExpiry(plugins.DB, userFromDB)
+	if err == nil {
+		before = current.Date()
+	}
+
+	value := ""
+	if len(fields) == 2 {
+		value = strings.TrimSpace(fields[1])
+	}
+
+	prefix := "/userroleexpire " + strconv.FormatInt(telegramID, 10)
+
+	var expiresAt time.Time
+
+	switch {
+	case value == "-":
+		if _, err = database.DeleteUserRoleExpiry(plugins.DB, userFromDB); err != nil {
+			return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+		}
+	case strings.HasPrefix(value, "+"):
+		days, err := strconv.Atoi(strings.TrimPrefix(value, "+"))
+		if err != nil || days <= 0 {
+			return telegram.Send(user.TelegramID, errorString)
+		}
+
+		from := time.Now()
+		if current != nil && current.ExpiresAt.After(from) {
+			from = current.ExpiresAt
+		}
+		expiresAt = from.AddDate(0, 0, days)
+	default:
+		var replyKeyboard tgbotapi.InlineKeyboardMarkup
+		var done bool
+
+		expiresAt, replyKeyboard, done = cal.Pick(prefix, value, i18n.Lang(user))
+		if !done {
+			replyKeyboard.InlineKeyboard = append(replyKeyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(i18n.T(user, "common.permanent"), prefix+" -"),
+				tgbotapi.NewInlineKeyboardButtonData("« "+userFromDB.String(), "/user "+strconv.FormatInt(telegramID, 10)),
+			))
+
+			text := i18n.T(user, "users.choose_expiry", "user", userFromDB.String(), "role", database.FallbackRole(userFromDB.Role), "date", before)
+
+			if update.CallbackQuery != nil {
+				_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, ""))
+				if err != nil {
+					dlog.Errorln(err.Error())
+				}
+
+				editKeyboard := tgbotapi.EditMessageTextConfig{
+					BaseEdit: tgbotapi.BaseEdit{
+						ChatID:      update.CallbackQuery.Message.Chat.ID,
+						MessageID:   update.CallbackQuery.Message.MessageID,
+						ReplyMarkup: &replyKeyboard,
+					},
+					Text: text,
+				}
+
+				_, err = plugins.Bot.Send(editKeyboard)
+				return err
+			}
+
+			return telegram.SendCustom(user.TelegramID, 0, text, false, &replyKeyboard)
+		}
+
+		expiresAt = cal.EndOfDay(expiresAt)
+		if expiresAt.Before(time.Now()) {
+			return telegram.Send(user.TelegramID, i18n.T(user, "common.date_passed"))
+		}
+	}
+
+	after := "-"
+	if !expiresAt.IsZero() {
+		if err = database.SetUserRoleExpiry(plugins.DB, userFromDB, expiresAt); err != nil {
+			return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+		}
+
+		after = expiresAt.Format("2006.01.02")
+	}
+
+	plugins.Audit(user, command, userFromDB.String(), before, after)
+
+	text := i18n.T(user, "users.permanent", "user", userFromDB.String())
+	notice := i18n.T(userFromDB, "users.your_permanent", "role", userFromDB.Role)
+	if after != "-" {
+		text = i18n.T(user, "users.becomes", "user", userFromDB.String(), "role", database.FallbackRole(userFromDB.Role), "date", after)
+		notice = i18n.T(userFromDB, "expiry.your_role_reminder", "role", userFromDB.Role, "date", after)
+	}
+
+	if err = telegram.Send(userFromDB.TelegramID, notice); err != nil {
+		dlog.Errorln(err.Error())
+	}
+
+	if update.CallbackQuery != nil {
+		_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, i18n.T(user, "common.success")))
+		if err != nil {
+			dlog.Errorln(err.Error())
+		}
+
+		replyKeyboard := userActionsList(user, userFromDB)
+
+		editKeyboard := tgbotapi.EditMessageTextConfig{
+			BaseEdit: tgbotapi.BaseEdit{
+				ChatID:      update.CallbackQuery.Message.Chat.ID,
+				MessageID:   update.CallbackQuery.Message.MessageID,
+				ReplyMarkup: &replyKeyboard,
+			},
+			Text: userParagraph(user, userFromDB),
+		}
+
+		_, err = plugins.Bot.Send(editKeyboard)
+		return err
+	}
+
+	return telegram.Send(user.TelegramID, text)
+}
+
+// userRefresh asks Telegram for the current names of user, they are also refreshed whenever the user writes to the bot
+var userRefresh plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
+	if args == "" {
+		return telegram.AskUser(update, user, command, "")
 	}
 
-	return telegram.Send(user.TelegramID, "success")
+	userFromDB, err := telegram.ResolveUser(update, args)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	chat, err := plugins.Bot.GetChat(tgbotapi.ChatConfig{ChatID: userFromDB.TelegramID})
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	before := userFromDB.String()
+
+	changed, err := database.SyncUserProfile(plugins.DB, userFromDB, &database.User{
+		FirstName: chat.FirstName,
+		LastName:  chat.LastName,
+		UserName:  chat.UserName,
+	})
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
+	text := i18n.T(user, "users.profile_actual")
+	if changed {
+		text = i18n.T(user, "users.profile_refreshed")
+		plugins.Audit(user, command, userFromDB.String(), before, userFromDB.String())
+	}
+
+	if update.CallbackQuery != nil {
+		_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, text))
+		if err != nil {
+			dlog.Errorln(err.Error())
+		}
+
+		replyKeyboard := userActionsList(user, userFromDB)
+
+		editKeyboard := tgbotapi.EditMessageTextConfig{
+			BaseEdit: tgbotapi.BaseEdit{
+				ChatID:      update.CallbackQuery.Message.Chat.ID,
+				MessageID:   update.CallbackQuery.Message.MessageID,
+				ReplyMarkup: &replyKeyboard,
+			},
+			Text: userParagraph(user, userFromDB),
+		}
+
+		_, err = plugins.Bot.Send(editKeyboard)
+		return err
+	}
 
+	return telegram.Send(user.TelegramID, text+"\n\n"+userParagraph(user, userFromDB))
 }
 
 var userDeleteUndelete plugins.CommandCallback = func(update *tgbotapi.Update, command, args string, user *database.User) error {
@@ -220,37 +517,46 @@ var userDeleteUndelete plugins.CommandCallback = func(update *tgbotapi.Update, c
 		newRole = database.Deleted
 	}
 
-	var telegramID int64
-	n, err := strconv.ParseInt(args, 10, 64)
-	if err == nil {
-		telegramID = n
+	if args == "" {
+		return telegram.AskUser(update, user, command, "")
 	}
 
-	if telegramID == 0 {
-		return telegram.Send(user.TelegramID, "please provide user telegram ID")
+	target, err := telegram.ResolveUser(update, args)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
 	}
 
+	telegramID := target.TelegramID
+
 	u := &database.User{
 		TelegramID: telegramID,
 		Role:       newRole,
 	}
 
+	before, err := database.GetUserByTelegramID(plugins.DB, u)
+	if err != nil {
+		return telegram.Send(user.TelegramID, i18n.Failed(user, err))
+	}
+
 	rows, err := database.UpdateUserRole(plugins.DB, u)
 	if err != nil {
 		return err
 	}
 
 	if rows != 1 {
-		return telegram.Send(user.TelegramID, "failed")
+		return telegram.Send(user.TelegramID, i18n.T(user, "common.failed"))
 	}
 
+	plugins.Audit(user, command, before.String(), before.Role, newRole)
+	publishRoleChanged(user, before, command, newRole)
+
 	if update.CallbackQuery != nil {
-		_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, args+" success"))
+		_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, i18n.T(user, "common.success")))
 		if err != nil {
 			dlog.Errorln(err.Error())
 		}
 
-		replyKeyboard := userActionsList(u)
+		replyKeyboard := userActionsList(user, u)
 		edit := tgbotapi.EditMessageReplyMarkupConfig{
 			BaseEdit: tgbotapi.BaseEdit{
 				ChatID:      update.CallbackQuery.Message.Chat.ID,
@@ -263,12 +569,7 @@ var userDeleteUndelete plugins.CommandCallback = func(update *tgbotapi.Update, c
 		return err
 	}
 
-	errNotifyUser := telegram.Send(telegramID, "you were assigned the role \""+newRole+"\", user /help for command list")
-	if errNotifyUser != nil {
-		dlog.Errorln(errNotifyUser.Error())
-	}
-
-	return telegram.Send(user.TelegramID, "success")
+	return telegram.Send(user.TelegramID, i18n.T(user, "common.success"))
 
 }
 
@@ -277,71 +578,9 @@ var userBirthday plugins.CommandCallback = func(update *tgbotapi.Update, command
 		return err
 	}
 
-	lang := telegram.GetLanguage(update)
+	lang := i18n.Lang(user)
 
-	replyKeyboard := tgbotapi.InlineKeyboardMarkup{}
-
-	switch {
-	case strings.HasPrefix(args, "<"):
-		date := strings.TrimLeft(args, "<")
-		year, month, _, err := cal.ParseDate(date)
-		if err == nil {
-			replyKeyboard, _, _ = cal.HandlerPrevMonth("/userbirthday", year, time.Month(month), lang)
-		}
-	case strings.HasPrefix(args, ">"):
-		date := strings.TrimLeft(args, ">")
-		year, month, _, err := cal.ParseDate(date)
-		if err == nil {
-			replyKeyboard, _, _ = cal.HandlerNextMonth("/userbirthday", year, time.Month(month), lang)
-		}
-	case strings.HasPrefix(args, "«"):
-		date := strings.TrimLeft(args, "«")
-		year, month, _, err := cal.ParseDate(date)
-		if err == nil {
-			replyKeyboard, _, _ = cal.HandlerPrevYear("/userbirthday", year, time.Month(month), lang)
-		}
-	case strings.HasPrefix(args, "»"):
-		date := strings.TrimLeft(args, "»")
-		year, month, _, err := cal.ParseDate(date)
-		if err == nil {
-			replyKeyboard, _, _ = cal.HandlerNextYear("/userbirthday", year, time.Month(month), lang)
-		}
-	case strings.HasPrefix(args, "m"):
-		currentTime := time.Now()
-		year := currentTime.Year()
-		month := currentTime.Month()
-
-		date := strings.TrimLeft(args, "m")
-		year2, month2, _, err := cal.ParseDate(date)
-		if err == nil {
-			year = year2
-			month = time.Month(month2)
-		}
-		replyKeyboard = cal.GenerateMonths("/userbirthday", year, month, lang)
-	case strings.HasPrefix(args, "y"):
-		currentTime := time.Now()
-		year := currentTime.Year()
-		month := currentTime.Month()
-
-		date := strings.TrimLeft(args, "y")
-		year2, month2, _, err := cal.ParseDate(date)
-		if err == nil {
-			year = year2
-			month = time.Month(month2)
-		}
-		replyKeyboard = cal.GenerateYears("/userbirthday", year, month, lang)
-	default:
-		currentTime := time.Now()
-		year := currentTime.Year()
-		month := currentTime.Month()
-
-		year2, month2, _, err := cal.ParseDate(args)
-		if err == nil {
-			year = year2
-			month = time.Month(month2)
-		}
-		replyKeyboard = cal.GenerateCalendar("/userbirthday", year, month, lang)
-	}
+	_, replyKeyboard, _ := cal.Pick("/userbirthday", args, lang)
 
 	if update.CallbackQuery != nil {
 		_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, ""))
@@ -361,7 +600,7 @@ var userBirthday plugins.CommandCallback = func(update *tgbotapi.Update, command
 		return err
 	}
 
-	return telegram.SendCustom(user.TelegramID, 0, "Choose your birth date", false, &replyKeyboard)
+	return telegram.SendCustom(user.TelegramID, 0, i18n.T(user, "users.choose_birthday"), false, &replyKeyboard)
 }
 
 func storeBirthday(update *tgbotapi.Update, user *database.User, args string) (bool, error) {
@@ -370,7 +609,7 @@ func storeBirthday(update *tgbotapi.Update, user *database.User, args string) (b
 		layout := "2006-01-02"
 		t, err := time.Parse(layout, fmt.Sprintf("%d-%02d-%02d", year, month, day))
 		if err != nil {
-			return true, telegram.Send(user.TelegramID, "failed: "+err.Error())
+			return true, telegram.Send(user.TelegramID, i18n.Failed(user, err))
 		}
 
 		u := &database.User{
@@ -380,7 +619,7 @@ func storeBirthday(update *tgbotapi.Update, user *database.User, args string) (b
 
 		_, err = database.UpdateUserBirthday(plugins.DB, u)
 		if err != nil {
-			return true, telegram.Send(user.TelegramID, "failed: "+err.Error())
+			return true, telegram.Send(user.TelegramID, i18n.Failed(user, err))
 		}
 
 		var deleteMessage tgbotapi.DeleteMessageConfig
@@ -394,52 +633,87 @@ func storeBirthday(update *tgbotapi.Update, user *database.User, args string) (b
 			dlog.Errorln(err.Error())
 		}
 
-		return true, telegram.Send(user.TelegramID, "Your birth date ("+args+") saved")
+		return true, telegram.Send(user.TelegramID, i18n.T(user, "users.birthday_saved", "date", args))
 	}
 
 	return false, nil
 }
 
-func listUsers(args string) tgbotapi.InlineKeyboardMarkup {
-	buttons := make([][]tgbotapi.InlineKeyboardButton, 0)
-
+func listUsers(args string, state pagination.State) tgbotapi.InlineKeyboardMarkup {
 	users, err := database.GetUsers(plugins.DB, strings.Fields(args))
 	if err != nil {
 		dlog.Errorln(err.Error())
-		return tgbotapi.NewInlineKeyboardMarkup(buttons...)
+		return tgbotapi.NewInlineKeyboardMarkup()
 	}
 
+	items := make([]pagination.Item, 0, len(users))
 	for _, u := range users {
-		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(u.String(), "/user "+strconv.FormatInt(u.TelegramID, 10))))
+		items = append(items, pagination.Item{Text: u.String(), Data: "/user " + strconv.FormatInt(u.TelegramID, 10)})
 	}
 
-	return tgbotapi.NewInlineKeyboardMarkup(buttons...)
+	return tgbotapi.NewInlineKeyboardMarkup(pagination.Rows(items, state, pagination.Prefix("/userlist", args), true)...)
 }
 
-func userActionsList(user *database.User) tgbotapi.InlineKeyboardMarkup {
+// userActionsList is a keyboard of actions with user, labels are in the language of viewer
+func userActionsList(viewer, user *database.User) tgbotapi.InlineKeyboardMarkup {
 	buttons := make([][]tgbotapi.InlineKeyboardButton, 0)
+	telegramID := strconv.FormatInt(user.TelegramID, 10)
+
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(i18n.T(viewer, "users.button_refresh"), "/userrefresh "+telegramID)))
 
 	switch user.Role {
 	case database.Deleted:
-		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("undelete user", "/userundelete "+strconv.FormatInt(user.TelegramID, 10))))
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(i18n.T(viewer, "users.button_undelete"), "/userundelete "+telegramID)))
 	case database.Blocked:
-		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("unblock user", "/userunblock "+strconv.FormatInt(user.TelegramID, 10))))
-	case database.New:
-		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("block user", "/userblock "+strconv.FormatInt(user.TelegramID, 10))))
-		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("delete user", "/userdelete "+strconv.FormatInt(user.TelegramID, 10))))
-		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("make member", "/userpromote "+strconv.FormatInt(user.TelegramID, 10)+"\nmember")))
-		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("make admin", "/userpromote "+strconv.FormatInt(user.TelegramID, 10)+"\nadmin")))
-	case database.Member:
-		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("block user", "/userblock "+strconv.FormatInt(user.TelegramID, 10))))
-		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("delete user", "/userdelete "+strconv.FormatInt(user.TelegramID, 10))))
-		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("make admin", "/userpromote "+strconv.FormatInt(user.TelegramID, 10)+"\nadmin")))
-	case database.Admin:
-		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("block user", "/userblock "+strconv.FormatInt(user.TelegramID, 10))))
-		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("delete user", "/userdelete "+strconv.FormatInt(user.TelegramID, 10))))
-		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("make member", "/userpromote "+strconv.FormatInt(user.TelegramID, 10)+"\nmember")))
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(i18n.T(viewer, "users.button_unblock"), "/userunblock "+telegramID)))
+	case database.Owner:
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(i18n.T(viewer, "users.button_actions"), "/user "+telegramID)))
 	default:
-		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("user actions", "/user "+strconv.FormatInt(user.TelegramID, 10))))
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(i18n.T(viewer, "users.button_block"), "/userblock "+telegramID)))
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(i18n.T(viewer, "users.button_delete"), "/userdelete "+telegramID)))
+
+		if user.Role != database.New {
+			buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(i18n.T(viewer, "users.button_role_expiry"), "/userroleexpire "+telegramID)))
+		}
+
+		roles, err := database.GetRoles(plugins.DB)
+		if err != nil {
+			dlog.Errorln(err.Error())
+		}
+
+		for _, role := range roles {
+			switch role.Name {
+			case user.Role, database.New, database.Owner, database.Blocked, database.Deleted:
+				continue
+			}
+
+			buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(i18n.T(viewer, "users.button_make", "role", role.Name), "/userpromote "+telegramID+"\n"+role.Name)))
+		}
 	}
 
 	return tgbotapi.NewInlineKeyboardMarkup(buttons...)
 }
+
+// userParagraph is a user card with expiry of a temporary role and previous usernames
+func userParagraph(viewer, user *database.User) string {
+	text := strings.TrimSuffix(user.Paragraph(), "\n")
+
+	if expiry, err := database.GetUserRoleExpiry(plugins.DB, user); err == nil {
+		text += "\n" + i18n.T(viewer, "users.role_expires", "date", expiry.Date())
+	}
+
+	names, err := database.GetUserNames(plugins.DB, user)
+	if err != nil {
+		dlog.Errorln(err.Error())
+	}
+
+	if len(names) > 0 {
+		previous := make([]string, 0, len(names))
+		for _, n := range names {
+			previous = append(previous, "@"+n.UserName)
+		}
+		text += "\n" + i18n.T(viewer, "users.previously", "names", strings.Join(previous, ", "))
+	}
+
+	return text
+}
@@ -0,0 +1,191 @@
+package telegram
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+
+	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/plugins"
+	dlog "github.com/amoghe/distillog"
+	sql "github.com/lazada/sqle"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func updateGroupChat(db *sql.DB, message *tgbotapi.Message) {
+	if message == nil || message.Chat == nil || !(message.Chat.IsGroup() || message.Chat.IsSuperGroup()) {
+		return
+	}
+
+	// group was upgraded to a supergroup, the old chat ID is not valid anymore
+	if message.MigrateToChatID != 0 {
+		migrateGroupChat(db, message.Chat.ID, message.MigrateToChatID)
+		return
+	}
+
+	if message.MigrateFromChatID != 0 {
+		migrateGroupChat(db, message.MigrateFromChatID, message.Chat.ID)
+	}
+
+	if message.LeftChatMember != nil && message.LeftChatMember.ID == plugins.Bot.Self.ID {
+		deactivateGroupChat(db, message.Chat, message.From)
+		return
+	}
+
+	activateGroupChat(db, message.Chat, message.From)
+
+	if message.NewChatTitle != "" {
+		groupchat := database.Groupchat{
+			Title:      message.NewChatTitle,
+			TelegramID: message.Chat.ID,
+			InviteLink: message.Chat.InviteLink,
+		}
+		_, err := database.UpdateGroupChatTitle(db, &groupchat)
+		if err != nil {
+			dlog.Errorln(err)
+		}
+	}
+}
+
+// updateBotChatMember handles my_chat_member updates, they come when bot is added, kicked or promoted
+func updateBotChatMember(db *sql.DB, update *ChatMemberUpdated) {
+	if !(update.Chat.IsGroup() || update.Chat.IsSuperGroup()) {
+		return
+	}
+
+	dlog.Debugf(" <= bot in %s [%d]: %s -> %s", update.Chat.Title, update.Chat.ID, update.OldChatMember.Status, update.NewChatMember.Status)
+
+	switch update.NewChatMember.Status {
+	case database.Left, database.Kicked:
+		deactivateGroupChat(db, &update.Chat, &update.From)
+	default:
+		activateGroupChat(db, &update.Chat, &update.From)
+	}
+}
+
+// activateGroupChat creates groupchat or makes it active again if bot was re-added
+func activateGroupChat(db *sql.DB, chat *tgbotapi.Chat, from *tgbotapi.User) {
+	groupchat := &database.Groupchat{
+		Title:      chat.Title,
+		TelegramID: chat.ID,
+		InviteLink: chat.InviteLink,
+		State:      database.Active,
+	}
+
+	existing, err := database.AddGroupChatIfNotExist(db, groupchat)
+	if err == nil {
+		plugins.Publish(&plugins.GroupchatAdded{Groupchat: groupchat, By: from})
+		return
+	}
+
+	if err.Error() != database.GroupChatAlreadyExists {
+		dlog.Errorln(err)
+		return
+	}
+
+	if existing.State != database.Inactive {
+		return
+	}
+
+	rows, err := database.UpdateGroupChatState(db, groupchat)
+	if err != nil {
+		dlog.Errorln(err)
+		return
+	}
+
+	if rows == 1 {
+		groupchat.ID = existing.ID
+		plugins.Publish(&plugins.GroupchatAdded{Groupchat: groupchat, By: from, Returned: true})
+	}
+}
+
+// deactivateGroupChat marks groupchat as inactive when bot is removed from it
+func deactivateGroupChat(db *sql.DB, chat *tgbotapi.Chat, from *tgbotapi.User) {
+	groupchat := &database.Groupchat{
+		Title:      chat.Title,
+		TelegramID: chat.ID,
+		State:      database.Inactive,
+	}
+
+	rows, err := database.UpdateGroupChatState(db, groupchat)
+	if err != nil {
+		dlog.Errorln(err)
+		return
+	}
+
+	if rows == 1 {
+		plugins.Publish(&plugins.BotRemovedFromChat{Groupchat: groupchat, By: from})
+	}
+}
+
+func migrateGroupChat(db *sql.DB, oldTelegramID, newTelegramID int64) {
+	_, err := database.MigrateGroupChat(db, oldTelegramID, newTelegramID)
+	if err != nil {
+		if err.Error() != database.GroupChatNotFound {
+			dlog.Errorln(err)
+		}
+		return
+	}
+
+	dlog.Debugf("groupchat [%d] migrated to [%d]", oldTelegramID, newTelegramID)
+}
+
+// GetInviteLink returns stored invite link of groupchat, a new one is generated and stored if there is none
+func GetInviteLink(groupchat *database.Groupchat) (string, error) {
+	if groupchat.InviteLink != "" {
+		return groupchat.InviteLink, nil
+	}
+
+	inviteLink, err := plugins.Bot.GetInviteLink(tgbotapi.ChatConfig{ChatID: groupchat.TelegramID})
+	if err != nil {
+		return "", err
+	}
+
+	groupchat.InviteLink = inviteLink
+	if _, err = database.UpdateGroupChatInviteLink(plugins.DB, groupchat); err != nil {
+		return inviteLink, err
+	}
+
+	return inviteLink, nil
+}
+
+// CreateInviteLink creates an additional invite link which doesn't revoke the primary one, zero memberLimit and
+// expire mean no limits
+func CreateInviteLink(groupchat *database.Groupchat, memberLimit int, expire time.Time) (string, error) {
+	v := url.Values{}
+	v.Add("chat_id", strconv.FormatInt(groupchat.TelegramID, 10))
+	if memberLimit > 0 {
+		v.Add("member_limit", strconv.Itoa(memberLimit))
+	}
+	if !expire.IsZero() {
+		v.Add("expire_date", strconv.FormatInt(expire.Unix(), 10))
+	}
+
+	resp, err := plugins.Bot.MakeRequest("createChatInviteLink", v)
+	if err != nil {
+		return "", err
+	}
+
+	var link struct {
+		InviteLink string `json:"invite_link"`
+	}
+	if err = json.Unmarshal(resp.Result, &link); err != nil {
+		return "", err
+	}
+
+	return link.InviteLink, nil
+}
+
+// KickChatMember removes user from groupchat, user is unbanned right away so a new invite link still works
+func KickChatMember(groupchat *database.Groupchat, userID int64) error {
+	member := tgbotapi.ChatMemberConfig{ChatID: groupchat.TelegramID, UserID: int(userID)}
+
+	if _, err := plugins.Bot.KickChatMember(tgbotapi.KickChatMemberConfig{ChatMemberConfig: member}); err != nil {
+		return err
+	}
+
+	_, err := plugins.Bot.UnbanChatMember(member)
+
+	return err
+}
@@ -0,0 +1,82 @@
+package telegram
+
+import (
+	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/plugins"
+	dlog "github.com/amoghe/distillog"
+	sql "github.com/lazada/sqle"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// updateGroupChatMembers tracks joined, left and writing users of a groupchat
+func updateGroupChatMembers(db *sql.DB, message *tgbotapi.Message) {
+	if message == nil || message.Chat == nil || message.Chat.IsPrivate() {
+		return
+	}
+
+	if message.NewChatMembers != nil {
+		for i := range *message.NewChatMembers {
+			StoreGroupChatMember(db, message.Chat.ID, &(*message.NewChatMembers)[i], database.Member)
+		}
+	}
+
+	if message.LeftChatMember != nil {
+		StoreGroupChatMember(db, message.Chat.ID, message.LeftChatMember, database.Left)
+	}
+
+	// someone who writes to the chat is definitely in it
+	if message.From != nil && (message.LeftChatMember == nil || message.LeftChatMember.ID != message.From.ID) {
+		StoreGroupChatMember(db, message.Chat.ID, message.From, "")
+	}
+}
+
+// updateGroupChatMember handles chat_member updates
+func updateGroupChatMember(db *sql.DB, update *ChatMemberUpdated) {
+	if update.NewChatMember.User == nil {
+		return
+	}
+
+	dlog.Debugf(" <= chat member %s [%d] in %s [%d]: %s -> %s", update.NewChatMember.User.UserName, update.NewChatMember.User.ID, update.Chat.Title, update.Chat.ID, update.OldChatMember.Status, update.NewChatMember.Status)
+
+	StoreGroupChatMember(db, update.Chat.ID, update.NewChatMember.User, update.NewChatMember.Status)
+}
+
+// StoreGroupChatMember saves user status for a known groupchat, empty status keeps the stored one if user is present
+func StoreGroupChatMember(db *sql.DB, chatID int64, user *tgbotapi.User, status string) {
+	if user == nil || user.ID == plugins.Bot.Self.ID {
+		return
+	}
+
+	syncProfile(db, user)
+
+	groupchat, err := database.GetGroupChatByTelegramID(db, &database.Groupchat{TelegramID: chatID})
+	if err != nil {
+		if err.Error() != database.GroupChatNotFound {
+			dlog.Errorln(err)
+		}
+		return
+	}
+
+	member := &database.GroupchatMember{
+		GroupchatID: groupchat.ID,
+		TelegramID:  int64(user.ID),
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		UserName:    user.UserName,
+		Status:      status,
+	}
+
+	if member.Status == "" {
+		member.Status = database.Member
+
+		stored, err := database.GetGroupchatMember(db, member)
+		if err == nil && stored.IsPresent() {
+			member.Status = stored.Status
+		}
+	}
+
+	_, err = database.UpdateGroupchatMember(db, member)
+	if err != nil {
+		dlog.Errorln(err)
+	}
+}
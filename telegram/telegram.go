@@ -5,11 +5,11 @@ import (
 	"fmt"
 	"net"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 
 	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
 	"github.com/ad/corpobot/plugins"
 	dlog "github.com/amoghe/distillog"
 	sql "github.com/lazada/sqle"
@@ -52,37 +52,45 @@ func InitTelegram(token, proxyHost, proxyPort, proxyUser, proxyPassword string,
 }
 
 // ProcessTelegramMessages ...
-func ProcessTelegramMessages(db *sql.DB, bot *tgbotapi.BotAPI, updates tgbotapi.UpdatesChannel) {
+func ProcessTelegramMessages(db *sql.DB, bot *tgbotapi.BotAPI, updates UpdatesChannel) {
 	plugins.Bot = bot
 
-	for update := range updates {
-		updateGroupChat(db, update.Message)
+	for u := range updates {
+		update := u.Update
 
-		var user *database.User
+		updateGroupChat(db, update.Message)
+		updateGroupChatMembers(db, update.Message)
 
-		if update.CallbackQuery != nil {
-			user = &database.User{
-				TelegramID: int64(update.CallbackQuery.From.ID),
-				FirstName:  update.CallbackQuery.From.FirstName,
-				LastName:   update.CallbackQuery.From.LastName,
-				UserName:   update.CallbackQuery.From.UserName,
-				IsBot:      update.CallbackQuery.From.IsBot,
-			}
-			dlog.Debugf(" <= %s [%d] %s", update.CallbackQuery.From.UserName, update.CallbackQuery.From.ID, update.CallbackQuery.Data)
+		if u.ChatMember != nil {
+			updateGroupChatMember(db, u.ChatMember)
+			continue
 		}
-		if update.Message != nil {
-			user = &database.User{
-				TelegramID: int64(update.Message.From.ID),
-				FirstName:  update.Message.From.FirstName,
-				LastName:   update.Message.From.LastName,
-				UserName:   update.Message.From.UserName,
-				IsBot:      update.Message.From.IsBot,
-			}
+
+		if u.MyChatMember != nil {
+			updateBotChatMember(db, u.MyChatMember)
+			continue
 		}
-		if update.CallbackQuery == nil && update.Message == nil {
+
+		from := plugins.UpdateSender(&update)
+		if from == nil {
+			// channel posts have no sender
+			plugins.HandleUpdate(&update, nil)
 			continue
 		}
 
+		if update.CallbackQuery != nil {
+			dlog.Debugf(" <= %s [%d] %s", from.UserName, from.ID, update.CallbackQuery.Data)
+		}
+
+		user := &database.User{
+			TelegramID:   int64(from.ID),
+			FirstName:    from.FirstName,
+			LastName:     from.LastName,
+			UserName:     from.UserName,
+			IsBot:        from.IsBot,
+			LanguageCode: from.LanguageCode,
+		}
+
 		if user.Role == "" {
 			if user.TelegramID == int64(plugins.Config.BotOwnerID) {
 				user.Role = database.Owner
@@ -91,21 +99,20 @@ func ProcessTelegramMessages(db *sql.DB, bot *tgbotapi.BotAPI, updates tgbotapi.
 			}
 		}
 
+		actual := *user
+
 		user, errAddUser := database.AddUserIfNotExist(db, user)
-		if errAddUser == nil {
-			users, errGetUsers := database.GetUsers(db, []string{database.Admin, database.Owner})
-			if errGetUsers == nil {
-				newUserMessage := "New user registered: " + user.String()
-				replyKeyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("user info", "/user "+strconv.FormatInt(user.TelegramID, 10))))
-
-				for _, u := range users {
-					err := SendCustom(u.TelegramID, 0, newUserMessage, false, &replyKeyboard)
-					if err != nil {
-						dlog.Errorln(err.Error())
-					}
-				}
+		if errAddUser != nil && user != nil {
+			if _, err := database.SyncUserProfile(db, user, &actual); err != nil {
+				dlog.Errorf("sync profile of %s failed: %s", user, err)
 			}
 		}
+		// registration is asked in private chat only, a user first seen in groupchat may /register later
+		chat := plugins.UpdateChat(&update)
+		registering := errAddUser == nil && user.Role == database.New && chat != nil && chat.IsPrivate() && isRegistrationEnabled()
+		if errAddUser == nil {
+			plugins.Publish(&plugins.UserRegistered{User: user, Applying: registering})
+		}
 		if errAddUser != nil && errAddUser.Error() != database.UserAlreadyExists {
 			dlog.Errorln(errAddUser.Error())
 			continue
@@ -130,42 +137,140 @@ func ProcessTelegramMessages(db *sql.DB, bot *tgbotapi.BotAPI, updates tgbotapi.
 			}
 		}
 
-		ProcessTelegramCommand(&update, user)
+		if registering {
+			startRegistration(&update, user)
+			continue
+		}
+
+		if isCommand(&update, user) {
+			ProcessTelegramCommand(&update, user)
+			continue
+		}
+
+		// a button of command which is not registered anymore is reported as unknown command
+		if !plugins.HandleUpdate(&update, user) && update.CallbackQuery != nil {
+			ProcessTelegramCommand(&update, user)
+		}
+	}
+}
+
+// isCommand tells if update goes to a command: a command or a message awaited by command in private chat, a command
+// allowed in groupchat, or a callback query with data of a registered command, other updates go to handlers of plugins
+func isCommand(update *tgbotapi.Update, user *database.User) bool {
+	if update.CallbackQuery != nil {
+		_, ok := plugins.Commands.Load(callbackCommand(update.CallbackQuery.Data))
+		return ok
+	}
+
+	if update.Message == nil {
+		return false
+	}
+
+	// other commands in groupchats, e.g. ones of other bots, go to handlers
+	if !update.Message.Chat.IsPrivate() {
+		cmd, ok := plugins.Commands.Load(update.Message.Command())
+		return ok && isAddressed(update.Message) && cmd.(plugins.Command).RunsIn(update.Message.Chat)
+	}
+
+	if update.Message.IsCommand() {
+		return true
+	}
+
+	_, waiting := plugins.Inputs.Load(user.TelegramID)
+
+	return waiting
+}
+
+// isAddressed checks that command in groupchat is "/command" or "/command@bot" with username of this bot
+func isAddressed(message *tgbotapi.Message) bool {
+	command := message.CommandWithAt()
+
+	i := strings.Index(command, "@")
+
+	return i == -1 || strings.EqualFold(command[i+1:], plugins.Bot.Self.UserName)
+}
+
+// callbackCommand returns command of callback data "/command args"
+func callbackCommand(data string) string {
+	return strings.SplitN(strings.TrimLeft(data, "/"), " ", 2)[0]
+}
+
+// isRegistrationEnabled checks if new users apply for registration, otherwise admins are just notified about them
+func isRegistrationEnabled() bool {
+	_, ok := plugins.Commands.Load("register")
+	return ok
+}
+
+// startRegistration asks new user to apply for registration
+func startRegistration(update *tgbotapi.Update, user *database.User) {
+	cmd, ok := plugins.Commands.Load("register")
+	if !ok {
+		return
+	}
+
+	if err := cmd.(plugins.Command).Callback(update, "register", "", user); err != nil {
+		dlog.Errorln(err)
 	}
 }
 
 // ProcessTelegramCommand ...
 func ProcessTelegramCommand(update *tgbotapi.Update, user *database.User) {
-	// check if message from private chat
-	if update.Message != nil && update.Message.Chat.Type != "private" {
+	chat := plugins.UpdateChat(update)
+	private := chat == nil || chat.IsPrivate()
+
+	if update.Message != nil && !private && !isAddressed(update.Message) {
 		return
 	}
 
 	command := ""
 	if update.CallbackQuery != nil {
-		command = strings.TrimLeft(update.CallbackQuery.Data, "/")
-		commands := strings.Split(command, " ")
-		if len(commands) > 1 {
-			command = commands[0]
-		}
+		command = callbackCommand(update.CallbackQuery.Data)
 	}
 
 	if command == "" && update.Message != nil && update.Message.Command() != "" {
 		command = update.Message.Command()
 	}
 
+	args := GetArguments(update)
+
+	// any command cancels waiting for input, plain text or a document is passed to the waiting command, a forwarded
+	// message is passed as a reference to its author
+	var input plugins.Input
+	waiting := false
+	if private {
+		input, waiting = plugins.TakeInput(user.TelegramID)
+	}
+	if command == "" && waiting && update.Message != nil {
+		if ref := forwardedRef(update.Message); ref != "" {
+			command = input.Command
+			args = strings.TrimSpace(input.Args + ref)
+		} else if update.Message.Text != "" || update.Message.Document != nil {
+			command = input.Command
+			args = strings.TrimSpace(input.Args + update.Message.Text)
+		}
+	}
+
 	if command != "" {
 		if cmd, ok := plugins.Commands.Load(command); ok {
-			args := GetArguments(update)
 
+			// commands not allowed in groupchat are ignored there, not to make noise
 			cmd := cmd.(plugins.Command)
-			if cmd.IsAllowedForRole(user.Role) {
+			if !cmd.RunsIn(chat) {
+				if private {
+					if err := Send(user.TelegramID, i18n.T(user, "common.groupchat_command", "command", command)); err != nil {
+						dlog.Errorln(err)
+					}
+				}
+				return
+			}
+
+			if cmd.IsAllowedForUser(user, args) {
 				if err := cmd.Callback(update, command, args, user); err != nil {
 					dlog.Errorln(err)
 				}
 			}
-		} else {
-			err := Send(user.TelegramID, "unknown command "+command+", use /help")
+		} else if private {
+			err := Send(user.TelegramID, i18n.T(user, "common.unknown_command", "command", command))
 			if err != nil {
 				dlog.Errorln(err)
 			}
@@ -173,54 +278,43 @@ func ProcessTelegramCommand(update *tgbotapi.Update, user *database.User) {
 	}
 }
 
-func updateGroupChat(db *sql.DB, message *tgbotapi.Message) {
-	if message == nil {
-		return
-	}
-
-	needCreate := false
-
-	newChatMembers := message.NewChatMembers
+// Reply answers command in chat where it was sent: user gets it in private chat, in groupchat it's a reply to the
+// command message
+func Reply(update *tgbotapi.Update, user *database.User, message string) error {
+	return ReplyCustom(update, user, message, false, nil)
+}
 
-	// TODO: сделать какую-то реакцию на удаление бота из чата *message.LeftChatMember
-	if message.Chat.Type == "supergroup" && newChatMembers != nil && len(*newChatMembers) > 0 {
-		// ищем себя в списке, чтобы определить что нас добавили в какой-то групчат
-		for i := range *newChatMembers {
-			user := (*newChatMembers)[i]
-			if user.ID == plugins.Bot.Self.ID {
-				needCreate = true
-			}
-		}
+// ReplyCustom is Reply with markdown and buttons
+func ReplyCustom(update *tgbotapi.Update, user *database.User, message string, isMarkdown bool, replyMarkup *tgbotapi.InlineKeyboardMarkup) error {
+	if update.Message == nil || update.Message.Chat.IsPrivate() {
+		return SendCustom(user.TelegramID, 0, message, isMarkdown, replyMarkup)
 	}
 
-	if message.Chat.Type == "supergroup" {
-		needCreate = true
+	sent, err := SendCustomMessage(update.Message.Chat.ID, update.Message.MessageID, message, isMarkdown, replyMarkup)
+	if err != nil {
+		return err
 	}
 
-	if needCreate {
-		groupchat := database.Groupchat{
-			Title:      message.Chat.Title,
-			TelegramID: message.Chat.ID,
-			InviteLink: message.Chat.InviteLink,
-			State:      database.Active,
-		}
-		_, err := database.AddGroupChatIfNotExist(db, &groupchat)
-		if err != nil && err.Error() != database.GroupChatAlreadyExists {
-			dlog.Errorln(err)
-		}
+	autoDelete(update.Message.Chat.ID, update.Message.MessageID, sent.MessageID)
+
+	return nil
+}
+
+// autoDelete removes messages from groupchat after the delay of config, bot must be an admin there to delete
+// messages of users
+func autoDelete(chatID int64, messageIDs ...int) {
+	delay := time.Duration(plugins.Config.GroupAutoDelete) * time.Second
+	if delay <= 0 {
+		return
 	}
 
-	if message.NewChatTitle != "" {
-		groupchat := database.Groupchat{
-			Title:      message.NewChatTitle,
-			TelegramID: message.Chat.ID,
-			InviteLink: message.Chat.InviteLink,
-		}
-		_, err := database.UpdateGroupChatTitle(db, &groupchat)
-		if err != nil {
-			dlog.Errorln(err)
+	time.AfterFunc(delay, func() {
+		for _, messageID := range messageIDs {
+			if _, err := plugins.Bot.DeleteMessage(tgbotapi.DeleteMessageConfig{ChatID: chatID, MessageID: messageID}); err != nil {
+				dlog.Debugf("delete message %d in [%d] failed: %s", messageID, chatID, err)
+			}
 		}
-	}
+	})
 }
 
 // SendPlain ...
@@ -240,6 +334,12 @@ func SendPlain(chatID int64, replyTo int, message string) error {
 
 // Send ...
 func SendCustom(chatID int64, replyTo int, message string, isMarkdown bool, replyMarkup *tgbotapi.InlineKeyboardMarkup) error {
+	_, err := SendCustomMessage(chatID, replyTo, message, isMarkdown, replyMarkup)
+	return err
+}
+
+// SendCustomMessage is SendCustom returning sent message, e.g. to edit it later
+func SendCustomMessage(chatID int64, replyTo int, message string, isMarkdown bool, replyMarkup *tgbotapi.InlineKeyboardMarkup) (tgbotapi.Message, error) {
 	msg := tgbotapi.NewMessage(chatID, "")
 	if isMarkdown {
 		msg.ParseMode = "Markdown"
@@ -256,9 +356,9 @@ func SendCustom(chatID int64, replyTo int, message string, isMarkdown bool, repl
 		msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
 	}
 
-	_, err := plugins.Bot.Send(msg)
+	sent, err := plugins.Bot.Send(msg)
 	if err != nil {
-		return err
+		return sent, err
 	}
 
 	dlog.Debugf(" => %s [%d] %s", plugins.Bot.Self.UserName, plugins.Bot.Self.ID, message)
@@ -275,15 +375,15 @@ func SendCustom(chatID int64, replyTo int, message string, isMarkdown bool, repl
 		dlog.Errorf("store message for user [%d] failed: %s", chatID, err2)
 	}
 
-	return nil
+	return sent, nil
 }
 
 func GetArguments(update *tgbotapi.Update) string {
 	if update.CallbackQuery != nil {
 		command := strings.TrimLeft(update.CallbackQuery.Data, "/")
-		commands := strings.Split(command, " ")
+		commands := strings.SplitN(command, " ", 2)
 		if len(commands) > 1 {
-			return strings.TrimSpace(strings.Join(commands[1:], ""))
+			return strings.TrimSpace(commands[1])
 		}
 	}
 
@@ -293,17 +393,3 @@ func GetArguments(update *tgbotapi.Update) string {
 
 	return ""
 }
-
-func GetLanguage(update *tgbotapi.Update) string {
-	if update.CallbackQuery != nil {
-		if update.CallbackQuery.From.LanguageCode != "" {
-			return update.CallbackQuery.From.LanguageCode
-		}
-	}
-
-	if update.Message != nil && update.Message.From.LanguageCode != "" {
-		return update.Message.From.LanguageCode
-	}
-
-	return ""
-}
@@ -0,0 +1,89 @@
+package telegram
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+
+	dlog "github.com/amoghe/distillog"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// allowedUpdates must be passed explicitly, otherwise Telegram doesn't send chat_member updates
+var allowedUpdates = []string{"message", "edited_message", "channel_post", "edited_channel_post", "callback_query", "inline_query", "chosen_inline_result", "chat_member", "my_chat_member"}
+
+// Update is tgbotapi.Update extended with update types unknown to tgbotapi.v4
+type Update struct {
+	tgbotapi.Update
+	ChatMember   *ChatMemberUpdated `json:"chat_member"`
+	MyChatMember *ChatMemberUpdated `json:"my_chat_member"`
+}
+
+// ChatMemberUpdated ...
+type ChatMemberUpdated struct {
+	Chat          tgbotapi.Chat       `json:"chat"`
+	From          tgbotapi.User       `json:"from"`
+	Date          int                 `json:"date"`
+	OldChatMember tgbotapi.ChatMember `json:"old_chat_member"`
+	NewChatMember tgbotapi.ChatMember `json:"new_chat_member"`
+}
+
+// UpdatesChannel ...
+type UpdatesChannel <-chan Update
+
+// GetUpdatesChan starts long polling and returns a channel for getting updates
+func GetUpdatesChan(bot *tgbotapi.BotAPI, config tgbotapi.UpdateConfig) UpdatesChannel {
+	ch := make(chan Update, bot.Buffer)
+
+	go func() {
+		for {
+			updates, err := getUpdates(bot, config)
+			if err != nil {
+				dlog.Errorln(err)
+				dlog.Errorln("Failed to get updates, retrying in 3 seconds...")
+				time.Sleep(time.Second * 3)
+
+				continue
+			}
+
+			for _, update := range updates {
+				if update.UpdateID >= config.Offset {
+					config.Offset = update.UpdateID + 1
+					ch <- update
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+func getUpdates(bot *tgbotapi.BotAPI, config tgbotapi.UpdateConfig) ([]Update, error) {
+	allowed, err := json.Marshal(allowedUpdates)
+	if err != nil {
+		return nil, err
+	}
+
+	v := url.Values{}
+	if config.Offset != 0 {
+		v.Add("offset", strconv.Itoa(config.Offset))
+	}
+	if config.Limit > 0 {
+		v.Add("limit", strconv.Itoa(config.Limit))
+	}
+	if config.Timeout > 0 {
+		v.Add("timeout", strconv.Itoa(config.Timeout))
+	}
+	v.Add("allowed_updates", string(allowed))
+
+	resp, err := bot.MakeRequest("getUpdates", v)
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []Update
+	err = json.Unmarshal(resp.Result, &updates)
+
+	return updates, err
+}
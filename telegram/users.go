@@ -0,0 +1,162 @@
+package telegram
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	database "github.com/ad/corpobot/db"
+	"github.com/ad/corpobot/i18n"
+	"github.com/ad/corpobot/plugins"
+
+	dlog "github.com/amoghe/distillog"
+	sql "github.com/lazada/sqle"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// ResolveUser finds user by reference: Telegram ID, @username, "tg:" + Telegram ID, "id:" + database ID or a text
+// mention from the message. A number matching different users by Telegram ID and by database ID is ambiguous, as
+// well as a username which several users had
+func ResolveUser(update *tgbotapi.Update, ref string) (*database.User, error) {
+	ref = strings.TrimSpace(ref)
+
+	if update != nil && update.Message != nil {
+		if update.Message.ForwardDate != 0 && update.Message.ForwardFrom == nil {
+			return nil, i18n.NewError("userref.hidden")
+		}
+
+		if mentioned := textMention(update.Message, ref); mentioned != nil {
+			return byTelegramID(int64(mentioned.ID))
+		}
+	}
+
+	switch {
+	case ref == "":
+		return nil, i18n.NewError("userref.empty", "help", i18n.NewError("userref.help"))
+	case strings.HasPrefix(ref, "tg:"):
+		id, err := strconv.ParseInt(strings.TrimPrefix(ref, "tg:"), 10, 64)
+		if err != nil {
+			return nil, i18n.NewError("userref.wrong_telegram_id", "ref", ref)
+		}
+
+		return byTelegramID(id)
+	case strings.HasPrefix(ref, "id:"):
+		id, err := strconv.ParseInt(strings.TrimPrefix(ref, "id:"), 10, 64)
+		if err != nil {
+			return nil, i18n.NewError("userref.wrong_id", "ref", ref)
+		}
+
+		return database.GetUserByID(plugins.DB, id)
+	case strings.HasPrefix(ref, "@"):
+		users, err := database.GetUsersByUserName(plugins.DB, ref)
+		if err != nil {
+			return nil, err
+		}
+
+		switch len(users) {
+		case 0:
+			return nil, i18n.NewError("userref.not_found", "ref", ref)
+		case 1:
+			return users[0], nil
+		}
+
+		candidates := make([]string, 0, len(users))
+		for _, u := range users {
+			candidates = append(candidates, "tg:"+strconv.FormatInt(u.TelegramID, 10)+" "+u.String())
+		}
+
+		return nil, i18n.NewError("userref.ambiguous", "ref", ref, "candidates", strings.Join(candidates, "\n"))
+	}
+
+	id, err := strconv.ParseInt(ref, 10, 64)
+	if err != nil {
+		return nil, i18n.NewError("userref.unknown", "ref", ref, "help", i18n.NewError("userref.help"))
+	}
+
+	byTelegram, errTelegram := database.GetUserByTelegramID(plugins.DB, &database.User{TelegramID: id})
+	byID, errID := database.GetUserByID(plugins.DB, id)
+
+	switch {
+	case errTelegram == nil && errID == nil && byTelegram.ID != byID.ID:
+		return nil, i18n.NewError("userref.ambiguous", "ref", ref, "candidates", "tg:"+ref+" "+byTelegram.String()+"\nid:"+ref+" "+byID.String())
+	case errTelegram == nil:
+		return byTelegram, nil
+	case errID == nil:
+		return byID, nil
+	}
+
+	return nil, i18n.NewError("userref.not_found", "ref", ref)
+}
+
+// AskUser makes the next message from user a reference of user for command, args are prepended to it
+func AskUser(update *tgbotapi.Update, user *database.User, command, args string) error {
+	if update.CallbackQuery != nil {
+		_, err := plugins.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, ""))
+		if err != nil {
+			dlog.Errorln(err.Error())
+		}
+	}
+
+	plugins.AwaitInput(user.TelegramID, command, args)
+
+	return Send(user.TelegramID, i18n.T(user, "userref.ask", "help", i18n.T(user, "userref.help")))
+}
+
+// forwardedRef is a reference of the author of forwarded message, empty if the message isn't forwarded or the author
+// is hidden
+func forwardedRef(message *tgbotapi.Message) string {
+	if message == nil || message.ForwardFrom == nil {
+		return ""
+	}
+
+	return "tg:" + strconv.Itoa(message.ForwardFrom.ID)
+}
+
+// textMention returns user mentioned by ref, Telegram makes text mentions for users without username
+func textMention(message *tgbotapi.Message, ref string) *tgbotapi.User {
+	if message.Entities == nil {
+		return nil
+	}
+
+	text := utf16.Encode([]rune(message.Text))
+
+	for _, e := range *message.Entities {
+		if e.Type != "text_mention" || e.User == nil || e.Offset+e.Length > len(text) {
+			continue
+		}
+
+		if string(utf16.Decode(text[e.Offset:e.Offset+e.Length])) == ref {
+			return e.User
+		}
+	}
+
+	return nil
+}
+
+func byTelegramID(telegramID int64) (*database.User, error) {
+	u, err := database.GetUserByTelegramID(plugins.DB, &database.User{TelegramID: telegramID})
+	if err != nil {
+		return nil, i18n.NewError("userref.not_started")
+	}
+
+	return u, nil
+}
+
+// syncProfile refreshes names of a known user from any update mentioning the user
+func syncProfile(db *sql.DB, from *tgbotapi.User) {
+	stored, err := database.GetUserByTelegramID(db, &database.User{TelegramID: int64(from.ID)})
+	if err != nil {
+		return
+	}
+
+	actual := &database.User{
+		FirstName:    from.FirstName,
+		LastName:     from.LastName,
+		UserName:     from.UserName,
+		LanguageCode: from.LanguageCode,
+	}
+
+	if _, err = database.SyncUserProfile(db, stored, actual); err != nil {
+		dlog.Errorf("sync profile of %s failed: %s", stored, err)
+	}
+}